@@ -0,0 +1,537 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermattis/pebble/internal/crc"
+	"github.com/petermattis/pebble/vfs"
+)
+
+const (
+	backupSharedDir  = "shared"
+	backupPrivateDir = "private"
+	backupMetaDir    = "meta"
+)
+
+// BackupInfo describes a single backup stored by a BackupEngine.
+type BackupInfo struct {
+	// ID is the identifier of the backup. Backup IDs are assigned in increasing
+	// order.
+	ID uint64
+	// Timestamp is the time at which the backup was created.
+	Timestamp time.Time
+	// SeqNum is the sequence number of the DB as of the backup.
+	SeqNum uint64
+	// Size is the total size of the files making up the backup, including
+	// sstables which are shared with other backups.
+	Size uint64
+	// NumFiles is the number of files making up the backup.
+	NumFiles int
+
+	files []backupFile
+}
+
+// backupFile describes a single file within a backup.
+type backupFile struct {
+	// path is the path of the file relative to the backup directory.
+	path string
+	// name is the name of the file within a restored DB directory.
+	name     string
+	size     uint64
+	checksum uint32
+}
+
+// BackupEngine maintains a set of incremental backups of a DB within a
+// directory. Since sstables are immutable once written, an sstable is only
+// copied into the backup directory the first time it is seen and is shared
+// by all subsequent backups which contain it. Each backup is described by a
+// metadata file which lists the files making up the backup along with their
+// sizes and checksums.
+//
+// The backup directory has the following layout:
+//
+//	shared/     sstables shared between backups
+//	private/N/  the MANIFEST, CURRENT, OPTIONS and WAL files for backup N
+//	meta/N      the metadata for backup N
+//
+// A BackupEngine is not safe for concurrent use.
+type BackupEngine struct {
+	fs      vfs.FS
+	dirname string
+	backups []*BackupInfo
+	// shared maps the path of a shared sstable to its description.
+	shared map[string]backupFile
+}
+
+// OpenBackupEngine opens the backup directory dirname on the specified
+// filesystem, creating it if it does not already exist.
+func OpenBackupEngine(fs vfs.FS, dirname string) (*BackupEngine, error) {
+	e := &BackupEngine{
+		fs:      fs,
+		dirname: dirname,
+		shared:  make(map[string]backupFile),
+	}
+	for _, dir := range []string{backupSharedDir, backupPrivateDir, backupMetaDir} {
+		if err := fs.MkdirAll(filepath.Join(dirname, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	ls, err := fs.List(filepath.Join(dirname, backupMetaDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range ls {
+		id, err := strconv.ParseUint(filename, 10, 64)
+		if err != nil {
+			// Ignore temporary files left over from a failed backup.
+			continue
+		}
+		info, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		e.backups = append(e.backups, info)
+		for _, f := range info.files {
+			if isBackupShared(f.path) {
+				e.shared[f.path] = f
+			}
+		}
+	}
+	sort.Slice(e.backups, func(i, j int) bool {
+		return e.backups[i].ID < e.backups[j].ID
+	})
+	return e, nil
+}
+
+// Backups returns the backups stored by the engine, ordered from oldest to
+// newest.
+func (e *BackupEngine) Backups() []BackupInfo {
+	infos := make([]BackupInfo, len(e.backups))
+	for i, info := range e.backups {
+		infos[i] = *info
+		infos[i].files = nil
+	}
+	return infos
+}
+
+// CreateBackup creates a new backup of the specified DB, returning the
+// description of the new backup. Only the sstables which are not already
+// present in the backup directory are copied.
+func (e *BackupEngine) CreateBackup(d *DB) (_ BackupInfo, err error) {
+	c, err := d.openCheckpointState()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer c.close()
+
+	info := &BackupInfo{
+		ID:        1,
+		Timestamp: time.Now(),
+		SeqNum:    c.lastSequence,
+	}
+	if n := len(e.backups); n > 0 {
+		info.ID = e.backups[n-1].ID + 1
+	}
+
+	privateDir := e.privateDir(info.ID)
+	if err := e.fs.MkdirAll(privateDir, 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	defer func() {
+		if err != nil {
+			// NB: shared sstables which were copied are left in place. They will be
+			// removed by the next call to PurgeOldBackups.
+			e.removePrivate(info.ID)
+		}
+	}()
+
+	srcFS := d.opts.VFS
+
+	// Copy the sstables which aren't already present in the backup directory.
+	newShared := make(map[string]backupFile)
+	for _, files := range c.current.files {
+		for i := range files {
+			meta := &files[i]
+			name := filepath.Base(dbFilename("", fileTypeTable, meta.fileNum))
+			path := filepath.Join(backupSharedDir,
+				fmt.Sprintf("%06d_%d.sst", meta.fileNum, meta.size))
+			if f, ok := e.shared[path]; ok {
+				info.files = append(info.files, f)
+				continue
+			}
+			// The sstable is copied to a temporary file which is then renamed so
+			// that an interrupted copy never leaves a truncated shared sstable
+			// which a later backup would reuse.
+			destPath := filepath.Join(e.dirname, path)
+			tmpPath := destPath + ".tmp"
+			f, err := copyBackupFile(srcFS, dbFilename(d.dirname, fileTypeTable, meta.fileNum),
+				e.fs, tmpPath, -1)
+			if err != nil {
+				return BackupInfo{}, err
+			}
+			if err := e.fs.Rename(tmpPath, destPath); err != nil {
+				return BackupInfo{}, err
+			}
+			f.path, f.name = path, name
+			info.files = append(info.files, f)
+			newShared[path] = f
+		}
+	}
+
+	// Copy the OPTIONS and WAL files.
+	type privateFile struct {
+		fileType fileType
+		fileNum  uint64
+		size     int64
+	}
+	private := []privateFile{{fileTypeOptions, c.optionsFileNum, -1}}
+	for _, log := range c.logs {
+		private = append(private, privateFile{fileTypeLog, log.fileNum, log.size})
+	}
+	for _, p := range private {
+		name := filepath.Base(dbFilename("", p.fileType, p.fileNum))
+		path := filepath.Join(backupPrivateDir, fmt.Sprint(info.ID), name)
+		f, err := copyBackupFile(srcFS, dbFilename(d.dirname, p.fileType, p.fileNum),
+			e.fs, filepath.Join(e.dirname, path), p.size)
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		f.path, f.name = path, name
+		info.files = append(info.files, f)
+	}
+
+	// Write the MANIFEST and CURRENT files.
+	if err := writeCheckpointManifest(e.fs, privateDir, c.manifestFileNumber, c.manifest()); err != nil {
+		return BackupInfo{}, err
+	}
+	if err := setCurrentFile(privateDir, e.fs, c.manifestFileNumber); err != nil {
+		return BackupInfo{}, err
+	}
+	for _, fileType := range []fileType{fileTypeManifest, fileTypeCurrent} {
+		name := filepath.Base(dbFilename("", fileType, c.manifestFileNumber))
+		path := filepath.Join(backupPrivateDir, fmt.Sprint(info.ID), name)
+		f, err := checksumBackupFile(e.fs, filepath.Join(e.dirname, path))
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		f.path, f.name = path, name
+		info.files = append(info.files, f)
+	}
+
+	// Writing the metadata file makes the backup visible.
+	for _, f := range info.files {
+		info.Size += f.size
+	}
+	info.NumFiles = len(info.files)
+	if err := e.writeMeta(info); err != nil {
+		return BackupInfo{}, err
+	}
+
+	e.backups = append(e.backups, info)
+	for path, f := range newShared {
+		e.shared[path] = f
+	}
+	result := *info
+	result.files = nil
+	return result, nil
+}
+
+// VerifyBackup verifies that the size and checksum of every file in the
+// specified backup matches the values recorded when the backup was created.
+func (e *BackupEngine) VerifyBackup(id uint64) error {
+	info, err := e.lookup(id)
+	if err != nil {
+		return err
+	}
+	for _, f := range info.files {
+		actual, err := checksumBackupFile(e.fs, filepath.Join(e.dirname, f.path))
+		if err != nil {
+			return err
+		}
+		if err := f.check(actual); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore restores the specified backup into the directory dirname on the
+// specified filesystem. The checksum of every file is verified as it is
+// copied. The restored DB can be opened with Open.
+func (e *BackupEngine) Restore(id uint64, fs vfs.FS, dirname string) error {
+	info, err := e.lookup(id)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Stat(dbFilename(dirname, fileTypeCurrent, 0)); err == nil {
+		return fmt.Errorf("pebble: database %q already exists", dirname)
+	}
+	if err := fs.MkdirAll(dirname, 0755); err != nil {
+		return err
+	}
+
+	// Copy the CURRENT file last so that a partially restored DB cannot be
+	// opened.
+	files := append([]backupFile(nil), info.files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[j].name == "CURRENT" && files[i].name != "CURRENT"
+	})
+	for _, f := range files {
+		actual, err := copyBackupFile(e.fs, filepath.Join(e.dirname, f.path),
+			fs, filepath.Join(dirname, f.name), -1)
+		if err != nil {
+			return err
+		}
+		if err := f.check(actual); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeOldBackups deletes all but the numToKeep most recent backups, along
+// with any shared sstables which are no longer referenced by a backup.
+func (e *BackupEngine) PurgeOldBackups(numToKeep int) error {
+	if numToKeep < 0 {
+		numToKeep = 0
+	}
+	for len(e.backups) > numToKeep {
+		info := e.backups[0]
+		// Remove the metadata first so that an interrupted purge leaves behind
+		// unreferenced files rather than a backup with missing files.
+		if err := e.fs.Remove(e.metaPath(info.ID)); err != nil {
+			return err
+		}
+		e.backups = e.backups[1:]
+		if err := e.removePrivate(info.ID); err != nil {
+			return err
+		}
+	}
+
+	// Remove any shared sstables which are not referenced by the remaining
+	// backups.
+	live := make(map[string]bool)
+	for _, info := range e.backups {
+		for _, f := range info.files {
+			live[f.path] = true
+		}
+	}
+	sharedDir := filepath.Join(e.dirname, backupSharedDir)
+	ls, err := e.fs.List(sharedDir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range ls {
+		path := filepath.Join(backupSharedDir, filename)
+		if live[path] {
+			continue
+		}
+		if err := e.fs.Remove(filepath.Join(sharedDir, filename)); err != nil {
+			return err
+		}
+		delete(e.shared, path)
+	}
+	return nil
+}
+
+func (e *BackupEngine) lookup(id uint64) (*BackupInfo, error) {
+	for _, info := range e.backups {
+		if info.ID == id {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("pebble: backup %d not found", id)
+}
+
+func (e *BackupEngine) privateDir(id uint64) string {
+	return filepath.Join(e.dirname, backupPrivateDir, fmt.Sprint(id))
+}
+
+func (e *BackupEngine) metaPath(id uint64) string {
+	return filepath.Join(e.dirname, backupMetaDir, fmt.Sprint(id))
+}
+
+func (e *BackupEngine) removePrivate(id uint64) error {
+	dir := e.privateDir(id)
+	ls, err := e.fs.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range ls {
+		if err := e.fs.Remove(filepath.Join(dir, filename)); err != nil {
+			return err
+		}
+	}
+	return e.fs.Remove(dir)
+}
+
+// writeMeta writes the metadata file for a backup. The metadata file is a
+// text file of the form:
+//
+//	timestamp <unix-nanos>
+//	seqnum <seqnum>
+//	file <path> <name> <size> <checksum>
+//	...
+func (e *BackupEngine) writeMeta(info *BackupInfo) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "timestamp %d\n", info.Timestamp.UnixNano())
+	fmt.Fprintf(&buf, "seqnum %d\n", info.SeqNum)
+	for _, f := range info.files {
+		fmt.Fprintf(&buf, "file %s %s %d %d\n", filepath.ToSlash(f.path), f.name, f.size, f.checksum)
+	}
+
+	path := e.metaPath(info.ID)
+	tmpPath := path + ".tmp"
+	f, err := e.fs.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return e.fs.Rename(tmpPath, path)
+}
+
+func (e *BackupEngine) readMeta(id uint64) (*BackupInfo, error) {
+	path := e.metaPath(id)
+	f, err := e.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &BackupInfo{ID: id}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		var err error
+		switch fields := strings.Fields(line); {
+		case len(fields) == 2 && fields[0] == "timestamp":
+			var nanos int64
+			nanos, err = strconv.ParseInt(fields[1], 10, 64)
+			info.Timestamp = time.Unix(0, nanos)
+		case len(fields) == 2 && fields[0] == "seqnum":
+			info.SeqNum, err = strconv.ParseUint(fields[1], 10, 64)
+		case len(fields) == 5 && fields[0] == "file":
+			bf := backupFile{path: filepath.FromSlash(fields[1]), name: fields[2]}
+			_, err = fmt.Sscan(fields[3]+" "+fields[4], &bf.size, &bf.checksum)
+			info.files = append(info.files, bf)
+			info.Size += bf.size
+		default:
+			err = errors.New("unknown entry")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pebble: corrupt backup metadata %q: %q", path, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	info.NumFiles = len(info.files)
+	return info, nil
+}
+
+func (f backupFile) check(actual backupFile) error {
+	if f.size != actual.size {
+		return fmt.Errorf("pebble: backup file %q: size mismatch: expected %d, found %d",
+			f.path, f.size, actual.size)
+	}
+	if f.checksum != actual.checksum {
+		return fmt.Errorf("pebble: backup file %q: checksum mismatch: expected %d, found %d",
+			f.path, f.checksum, actual.checksum)
+	}
+	return nil
+}
+
+func isBackupShared(path string) bool {
+	return filepath.Dir(path) == backupSharedDir
+}
+
+// checksumWriter computes the size and checksum of the data written to it.
+type checksumWriter struct {
+	size uint64
+	crc  crc.CRC
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	w.size += uint64(len(p))
+	w.crc = w.crc.Update(p)
+	return len(p), nil
+}
+
+func (w *checksumWriter) file() backupFile {
+	return backupFile{size: w.size, checksum: w.crc.Value()}
+}
+
+// copyBackupFile copies the first size bytes of srcPath on srcFS to destPath
+// on destFS, returning the size and checksum of the copied data. If size is
+// negative the entire file is copied.
+func copyBackupFile(
+	srcFS vfs.FS, srcPath string, destFS vfs.FS, destPath string, size int64,
+) (backupFile, error) {
+	src, err := srcFS.Open(srcPath)
+	if err != nil {
+		return backupFile{}, err
+	}
+	defer src.Close()
+
+	dest, err := destFS.Create(destPath)
+	if err != nil {
+		return backupFile{}, err
+	}
+	defer dest.Close()
+
+	var r io.Reader = src
+	if size >= 0 {
+		r = io.LimitReader(src, size)
+	}
+	var w checksumWriter
+	if _, err := io.Copy(io.MultiWriter(dest, &w), r); err != nil {
+		return backupFile{}, err
+	}
+	if err := dest.Sync(); err != nil {
+		return backupFile{}, err
+	}
+	return w.file(), nil
+}
+
+// checksumBackupFile returns the size and checksum of the specified file.
+func checksumBackupFile(fs vfs.FS, path string) (backupFile, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return backupFile{}, fmt.Errorf("pebble: backup file %q is missing", path)
+		}
+		return backupFile{}, err
+	}
+	defer f.Close()
+
+	var w checksumWriter
+	if _, err := io.Copy(&w, f); err != nil {
+		return backupFile{}, err
+	}
+	return w.file(), nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestBackupEngine(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("db", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupFS := vfs.NewMem()
+	e, err := OpenBackupEngine(backupFS, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("%s%d", prefix, i))
+			if err := d.Set(key, []byte(prefix), db.NoSync); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	sharedFiles := func() int {
+		ls, err := backupFS.List(filepath.Join("backup", backupSharedDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ls)
+	}
+
+	// The first backup contains a single sstable plus unflushed data in the
+	// WAL.
+	write("a", 10)
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	write("b", 10)
+	info1, err := e.CreateBackup(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sharedFiles(); n != 1 {
+		t.Fatalf("expected 1 shared file, but found %d", n)
+	}
+
+	// The second backup shares the first sstable and adds a second.
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	write("c", 10)
+	info2, err := e.CreateBackup(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sharedFiles(); n != 2 {
+		t.Fatalf("expected 2 shared files, but found %d", n)
+	}
+	if info1.ID >= info2.ID || info1.SeqNum >= info2.SeqNum {
+		t.Fatalf("expected increasing backups, but found %+v and %+v", info1, info2)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the backup engine to exercise loading the metadata.
+	e, err = OpenBackupEngine(backupFS, "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backups := e.Backups(); len(backups) != 2 ||
+		backups[0].ID != info1.ID || backups[1].ID != info2.ID ||
+		backups[1].NumFiles != info2.NumFiles || backups[1].Size != info2.Size {
+		t.Fatalf("unexpected backups: %+v", backups)
+	}
+	for _, id := range []uint64{info1.ID, info2.ID} {
+		if err := e.VerifyBackup(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkRestore := func(id uint64, dirname string, present, absent []string) {
+		restoreFS := vfs.NewMem()
+		if err := e.Restore(id, restoreFS, dirname); err != nil {
+			t.Fatal(err)
+		}
+		d, err := Open(dirname, &db.Options{
+			VFS: restoreFS,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		for _, prefix := range present {
+			for i := 0; i < 10; i++ {
+				key := fmt.Sprintf("%s%d", prefix, i)
+				v, err := d.Get([]byte(key))
+				if err != nil {
+					t.Fatalf("%d: %s: %v", id, key, err)
+				}
+				if string(v) != prefix {
+					t.Fatalf("%d: %s: expected %q, but found %q", id, key, prefix, v)
+				}
+			}
+		}
+		for _, prefix := range absent {
+			key := fmt.Sprintf("%s0", prefix)
+			if v, err := d.Get([]byte(key)); err != db.ErrNotFound {
+				t.Fatalf("%d: %s: expected not found, but found %q", id, key, v)
+			}
+		}
+	}
+	checkRestore(info1.ID, "restore1", []string{"a", "b"}, []string{"c"})
+	checkRestore(info2.ID, "restore2", []string{"a", "b", "c"}, nil)
+
+	// Purging the first backup leaves the sstables used by the second backup.
+	if err := e.PurgeOldBackups(1); err != nil {
+		t.Fatal(err)
+	}
+	if backups := e.Backups(); len(backups) != 1 || backups[0].ID != info2.ID {
+		t.Fatalf("unexpected backups: %+v", backups)
+	}
+	if n := sharedFiles(); n != 2 {
+		t.Fatalf("expected 2 shared files, but found %d", n)
+	}
+	if err := e.Restore(info1.ID, vfs.NewMem(), "restore"); err == nil {
+		t.Fatalf("expected error restoring purged backup")
+	}
+	checkRestore(info2.ID, "restore2", []string{"a", "b", "c"}, nil)
+
+	// Corrupt one of the shared sstables and verify that verification and
+	// restore notice.
+	ls, err := backupFS.List(filepath.Join("backup", backupSharedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := backupFS.Create(filepath.Join("backup", backupSharedDir, ls[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := e.VerifyBackup(info2.ID); err == nil {
+		t.Fatalf("expected verification error")
+	}
+	if err := e.Restore(info2.ID, vfs.NewMem(), "restore"); err == nil {
+		t.Fatalf("expected restore error")
+	}
+
+	// Purging all of the backups removes all of the shared sstables.
+	if err := e.PurgeOldBackups(0); err != nil {
+		t.Fatal(err)
+	}
+	if n := sharedFiles(); n != 0 {
+		t.Fatalf("expected 0 shared files, but found %d", n)
+	}
+}
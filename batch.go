@@ -161,14 +161,22 @@ func (s *batchStorage) Compare(a []byte, b uint32) int {
 // exactly those specified by db.InternalKeyKind. The following table shows the
 // format for records of each kind:
 //
-//   InternalKeyKindDelete       varstring
-//   InternalKeyKindLogData      varstring
-//   InternalKeyKindSet          varstring varstring
-//   InternalKeyKindMerge        varstring varstring
-//   InternalKeyKindRangeDelete  varstring varstring
+//   InternalKeyKindDelete          varstring
+//   InternalKeyKindLogData         varstring
+//   InternalKeyKindSingleDelete    varstring
+//   InternalKeyKindSet             varstring varstring
+//   InternalKeyKindMerge           varstring varstring
+//   InternalKeyKindRangeDelete     varstring varstring
+//   InternalKeyKindBeginPrepareXID
+//   InternalKeyKindEndPrepareXID   varstring
+//   InternalKeyKindCommitXID       varstring
+//   InternalKeyKindRollbackXID     varstring
 //
 // The intuitive understanding here are that the arguments to Delete(), Set(),
-// Merge(), and DeleteRange() are encoded into the batch.
+// Merge(), SingleDelete() and DeleteRange() are encoded into the batch. The
+// XID records are markers used for two-phase commit (see DB.Prepare). They are
+// never added to a batch by the user and their varstring is the XID of the
+// prepared batch.
 //
 // The internal batch representation is the on disk format for a batch in the
 // WAL, and thus stable. New record kinds may be added, but the existing ones
@@ -194,6 +202,12 @@ type Batch struct {
 	// memtable.
 	flushable *flushableBatch
 
+	// True if the batch contains set or merge records whose values do not carry
+	// the expiration time used in TTL mode, because they were added to a batch
+	// which does not belong to a DB in TTL mode. Such a batch cannot be applied
+	// to a DB in TTL mode.
+	ttlUnencoded bool
+
 	commit  sync.WaitGroup
 	applied uint32 // updated atomically
 }
@@ -267,6 +281,24 @@ func (b *Batch) refreshMemTableSize() {
 	}
 }
 
+// verify checks that every record in the batch can be decoded and that the
+// number of records matches the count in the batch header. Records of kinds
+// Pebble does not support, such as the RocksDB column family records, cause
+// verification to fail rather than being misinterpreted.
+func (b *Batch) verify() error {
+	var count uint32
+	for iter := b.iter(); len(iter) > 0; count++ {
+		kind := db.InternalKeyKind(iter[0])
+		if _, _, _, ok := iter.next(); !ok {
+			return fmt.Errorf("pebble: invalid batch record of kind %s", kind)
+		}
+	}
+	if count != b.count() {
+		return fmt.Errorf("pebble: invalid batch count: %d != %d", count, b.count())
+	}
+	return nil
+}
+
 // Apply the operations contained in the batch to the receiver batch.
 //
 // It is safe to modify the contents of the arguments after Apply returns.
@@ -287,6 +319,9 @@ func (b *Batch) Apply(batch *Batch, _ *db.WriteOptions) error {
 
 	count := binary.LittleEndian.Uint32(batch.storage.data[8:12])
 	b.setCount(b.count() + count)
+	if batch.ttlUnencoded {
+		b.ttlUnencoded = true
+	}
 
 	for iter := batchReader(b.storage.data[offset:]); len(iter) > 0; {
 		offset := uintptr(unsafe.Pointer(&iter[0])) - uintptr(unsafe.Pointer(&b.storage.data[0]))
@@ -341,6 +376,15 @@ func (b *Batch) encodeKeyValue(key, value []byte, kind db.InternalKeyKind) uint3
 //
 // It is safe to modify the contents of the arguments after Set returns.
 func (b *Batch) Set(key, value []byte, _ *db.WriteOptions) error {
+	if b.ttlEnabled() {
+		value = encodeTTLValue(value, 0)
+	} else {
+		b.ttlUnencoded = true
+	}
+	return b.set(key, value)
+}
+
+func (b *Batch) set(key, value []byte) error {
 	if len(b.storage.data) == 0 {
 		b.init(len(key) + len(value) + 2*binary.MaxVarintLen64 + batchHeaderLen)
 	}
@@ -366,6 +410,10 @@ func (b *Batch) Set(key, value []byte, _ *db.WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Merge returns.
 func (b *Batch) Merge(key, value []byte, _ *db.WriteOptions) error {
+	if b.ttlEnabled() {
+		return errTTLMerge
+	}
+	b.ttlUnencoded = true
 	if len(b.storage.data) == 0 {
 		b.init(len(key) + len(value) + 2*binary.MaxVarintLen64 + batchHeaderLen)
 	}
@@ -389,6 +437,25 @@ func (b *Batch) Merge(key, value []byte, _ *db.WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Delete returns.
 func (b *Batch) Delete(key []byte, _ *db.WriteOptions) error {
+	return b.addDeletion(key, db.InternalKeyKindDelete)
+}
+
+// SingleDelete adds an action to the batch that single deletes the entry for
+// key. A single delete removes the most recent value for the key, and is only
+// guaranteed to work correctly if the key has been set exactly once since the
+// last time it was deleted (if ever), and has not been merged. Unlike a
+// regular deletion, a single deletion and the value it deletes are both
+// removed when they meet during compaction, rather than the tombstone being
+// carried down to the bottom of the LSM. The behavior of a single delete of a
+// key which has been set more than once or merged is undefined.
+//
+// It is safe to modify the contents of the arguments after SingleDelete
+// returns.
+func (b *Batch) SingleDelete(key []byte, _ *db.WriteOptions) error {
+	return b.addDeletion(key, db.InternalKeyKindSingleDelete)
+}
+
+func (b *Batch) addDeletion(key []byte, kind db.InternalKeyKind) error {
 	if len(b.storage.data) == 0 {
 		b.init(len(key) + binary.MaxVarintLen64 + batchHeaderLen)
 	}
@@ -399,7 +466,7 @@ func (b *Batch) Delete(key []byte, _ *db.WriteOptions) error {
 	pos := len(b.storage.data)
 	offset := uint32(pos)
 	b.grow(1 + maxVarintLen32 + len(key))
-	b.storage.data[pos] = byte(db.InternalKeyKindDelete)
+	b.storage.data[pos] = byte(kind)
 	pos, varlen1 := b.copyStr(pos+1, key)
 	b.storage.data = b.storage.data[:len(b.storage.data)-(maxVarintLen32-varlen1)]
 
@@ -466,6 +533,29 @@ func (b *Batch) LogData(data []byte, _ *db.WriteOptions) error {
 	return nil
 }
 
+// addXIDMarker adds a two-phase commit marker record of the specified kind to
+// the batch. BeginPrepareXID markers do not contain an XID.
+func (b *Batch) addXIDMarker(kind db.InternalKeyKind, xid []byte) error {
+	if len(b.storage.data) == 0 {
+		b.init(len(xid) + binary.MaxVarintLen64 + batchHeaderLen)
+	}
+	if !b.increment() {
+		return ErrInvalidBatch
+	}
+
+	pos := len(b.storage.data)
+	if kind == db.InternalKeyKindBeginPrepareXID {
+		b.grow(1)
+		b.storage.data[pos] = byte(kind)
+		return nil
+	}
+	b.grow(1 + maxVarintLen32 + len(xid))
+	b.storage.data[pos] = byte(kind)
+	_, varlen1 := b.copyStr(pos+1, xid)
+	b.storage.data = b.storage.data[:len(b.storage.data)-(maxVarintLen32-varlen1)]
+	return nil
+}
+
 // Repr returns the underlying batch representation. It is not safe to modify
 // the contents.
 func (b *Batch) Repr() []byte {
@@ -479,8 +569,7 @@ func (b *Batch) NewIter(o *db.IterOptions) *Iterator {
 	if b.index == nil {
 		return &Iterator{err: ErrNotIndexed}
 	}
-	return b.db.newIterInternal(b.newInternalIter(o),
-		b.newRangeDelIter(o), nil /* snapshot */, o)
+	return b.db.newIterInternal(b, nil /* snapshot */, o)
 }
 
 // newInternalIter creates a new internalIterator that iterates over the
@@ -537,6 +626,12 @@ func (b *Batch) Commit(o *db.WriteOptions) error {
 	return b.db.Apply(b, o)
 }
 
+// Prepare writes the batch to its parent DB as a prepared batch identified by
+// xid. See DB.Prepare.
+func (b *Batch) Prepare(xid []byte, o *db.WriteOptions) error {
+	return b.db.Prepare(xid, b, o)
+}
+
 // Close implements DB.Close, as documented in the pebble/db package.
 func (b *Batch) Close() error {
 	b.release()
@@ -561,6 +656,7 @@ func (b *Batch) init(cap int) {
 }
 
 func (b *Batch) reset() {
+	b.ttlUnencoded = false
 	if b.storage.data != nil {
 		if cap(b.storage.data) > batchMaxRetainedSize {
 			// If the capacity of the buffer is larger than our maximum
@@ -655,15 +751,34 @@ func (b *Batch) iter() batchReader {
 	return b.storage.data[batchHeaderLen:]
 }
 
+// batchKindSupported returns true if records of the specified kind can appear
+// in a batch. Notably, the RocksDB column family record kinds are not
+// supported: they prefix the key with a column family ID and would be
+// misinterpreted if decoded as records for the default column family.
+func batchKindSupported(kind db.InternalKeyKind) bool {
+	switch kind {
+	case db.InternalKeyKindDelete, db.InternalKeyKindSet, db.InternalKeyKindMerge,
+		db.InternalKeyKindLogData, db.InternalKeyKindSingleDelete,
+		db.InternalKeyKindRangeDelete, db.InternalKeyKindBeginPrepareXID,
+		db.InternalKeyKindEndPrepareXID, db.InternalKeyKindCommitXID,
+		db.InternalKeyKindRollbackXID:
+		return true
+	}
+	return false
+}
+
 func batchDecode(data []byte, offset uint32) (kind db.InternalKeyKind, ukey []byte, value []byte, ok bool) {
 	p := data[offset:]
 	if len(p) == 0 {
 		return 0, nil, nil, false
 	}
 	kind, p = db.InternalKeyKind(p[0]), p[1:]
-	if kind > db.InternalKeyKindMax {
+	if !batchKindSupported(kind) {
 		return 0, nil, nil, false
 	}
+	if kind == db.InternalKeyKindBeginPrepareXID {
+		return kind, nil, nil, true
+	}
 	p, ukey, ok = batchDecodeStr(p)
 	if !ok {
 		return 0, nil, nil, false
@@ -700,9 +815,12 @@ func (r *batchReader) next() (kind db.InternalKeyKind, ukey []byte, value []byte
 		return 0, nil, nil, false
 	}
 	kind, *r = db.InternalKeyKind(p[0]), p[1:]
-	if kind > db.InternalKeyKindMax {
+	if !batchKindSupported(kind) {
 		return 0, nil, nil, false
 	}
+	if kind == db.InternalKeyKindBeginPrepareXID {
+		return kind, nil, nil, true
+	}
 	ukey, ok = r.nextStr()
 	if !ok {
 		return 0, nil, nil, false
@@ -752,6 +870,10 @@ func (i *batchIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return ikey, i.Value()
 }
 
+func (i *batchIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return i.SeekGE(key)
+}
+
 func (i *batchIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	ikey := i.iter.SeekLT(key)
 	if ikey == nil {
@@ -872,6 +994,11 @@ func newFlushableBatch(batch *Batch, comparer *db.Comparer) *flushableBatch {
 		if !ok {
 			break
 		}
+		switch kind {
+		case db.InternalKeyKindBeginPrepareXID, db.InternalKeyKindEndPrepareXID,
+			db.InternalKeyKindCommitXID, db.InternalKeyKindRollbackXID:
+			continue
+		}
 		entry := flushableBatchEntry{
 			offset: uint32(offset),
 			index:  uint32(index),
@@ -974,6 +1101,10 @@ func (b *flushableBatch) readyForFlush() bool {
 	return true
 }
 
+func (b *flushableBatch) totalBytes() uint64 {
+	return uint64(len(b.data))
+}
+
 func (b *flushableBatch) logNumber() uint64 {
 	return b.logNum
 }
@@ -1006,6 +1137,10 @@ func (i *flushableBatchIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return &i.key, i.Value()
 }
 
+func (i *flushableBatchIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return i.SeekGE(key)
+}
+
 func (i *flushableBatchIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	ikey := db.MakeSearchKey(key)
 	i.index = sort.Search(len(i.offsets), func(j int) bool {
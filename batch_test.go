@@ -35,6 +35,7 @@ func TestBatch(t *testing.T) {
 		{db.InternalKeyKindSet, "binarydata", "\x00"},
 		{db.InternalKeyKindSet, "binarydata", "\xff"},
 		{db.InternalKeyKindMerge, "merge", "mergedata"},
+		{db.InternalKeyKindSingleDelete, "single-delete", ""},
 	}
 	var b Batch
 	for _, tc := range testCases {
@@ -45,6 +46,8 @@ func TestBatch(t *testing.T) {
 			b.Merge([]byte(tc.key), []byte(tc.value), nil)
 		case db.InternalKeyKindDelete:
 			b.Delete([]byte(tc.key), nil)
+		case db.InternalKeyKindSingleDelete:
+			b.SingleDelete([]byte(tc.key), nil)
 		}
 	}
 	iter := b.iter()
@@ -64,6 +67,33 @@ func TestBatch(t *testing.T) {
 	}
 }
 
+func TestBatchVerify(t *testing.T) {
+	var b Batch
+	b.Set([]byte("a"), []byte("1"), nil)
+	b.SingleDelete([]byte("b"), nil)
+	b.LogData([]byte("c"), nil)
+	if err := b.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A RocksDB column family record (kind 5) is prefixed with the column
+	// family ID and must not be decoded as a record for the default column
+	// family.
+	cf := Batch{}
+	cf.storage.data = append([]byte(nil), b.storage.data...)
+	cf.storage.data = append(cf.storage.data, 5, 1, 1, 'd', 1, '2')
+	cf.setCount(cf.count() + 1)
+	if err := cf.verify(); err == nil {
+		t.Fatalf("expected error verifying column family record")
+	}
+
+	// A batch whose count does not match its records is invalid.
+	b.setCount(b.count() + 1)
+	if err := b.verify(); err == nil {
+		t.Fatalf("expected error verifying batch with bad count")
+	}
+}
+
 func TestBatchIncrement(t *testing.T) {
 	testCases := []uint32{
 		0x00000000,
@@ -147,8 +147,25 @@ type WeakHandle interface {
 	Get() []byte
 }
 
+// Metrics holds metrics for the cache.
+type Metrics struct {
+	// The number of bytes inuse by the cache.
+	Size int64
+	// The count of objects (blocks or tables) in the cache.
+	Count int64
+	// The number of cache hits.
+	Hits int64
+	// The number of cache misses.
+	Misses int64
+}
+
 // Cache ...
 type Cache struct {
+	// The hits and misses counters are accessed atomically and placed at the
+	// beginning of the struct to guarantee 64-bit alignment.
+	hits   int64
+	misses int64
+
 	mu sync.Mutex
 
 	maxSize  int64
@@ -163,6 +180,9 @@ type Cache struct {
 	countHot  int64
 	countCold int64
 	countTest int64
+
+	// The number of hot and cold entries, which hold a value.
+	entries int64
 }
 
 // New creates a new cache of the specified size. Memory for the cache is
@@ -188,9 +208,16 @@ func (c *Cache) Get(fileNum, offset uint64) []byte {
 
 	e := c.blocks[key{fileNum: fileNum, offset: offset}]
 	if e == nil {
+		atomic.AddInt64(&c.misses, 1)
 		return nil
 	}
-	return e.Get()
+	v := e.Get()
+	if v == nil {
+		atomic.AddInt64(&c.misses, 1)
+	} else {
+		atomic.AddInt64(&c.hits, 1)
+	}
+	return v
 }
 
 // Set sets the cache value for the specified file and offset, overwriting an
@@ -239,9 +266,9 @@ func (c *Cache) Set(fileNum, offset uint64, value []byte) WeakHandle {
 	}
 	atomic.StoreInt32(&e.ref, 0)
 	e.val.set(value)
-	e.ptype = etHot
 	c.countTest -= e.size
 	c.metaDel(e)
+	e.ptype = etHot
 	c.metaAdd(k, e)
 	c.countHot += e.size
 	return e
@@ -293,10 +320,27 @@ func (c *Cache) Size() int64 {
 	return size
 }
 
+// Metrics returns the metrics for the cache.
+func (c *Cache) Metrics() Metrics {
+	if c == nil {
+		return Metrics{}
+	}
+	c.mu.Lock()
+	m := Metrics{
+		Size:  c.countHot + c.countCold,
+		Count: c.entries,
+	}
+	c.mu.Unlock()
+	m.Hits = atomic.LoadInt64(&c.hits)
+	m.Misses = atomic.LoadInt64(&c.misses)
+	return m
+}
+
 func (c *Cache) metaAdd(key key, e *entry) {
 	c.evict()
 
 	c.blocks[key] = e
+	c.entries++
 
 	if c.handHot == nil {
 		// first element
@@ -320,6 +364,9 @@ func (c *Cache) metaAdd(key key, e *entry) {
 
 func (c *Cache) metaDel(e *entry) {
 	delete(c.blocks, e.key)
+	if e.ptype != etTest {
+		c.entries--
+	}
 
 	if e == c.handHot {
 		c.handHot = c.handHot.prev()
@@ -366,6 +413,7 @@ func (c *Cache) runHandCold() {
 		} else {
 			e.val.set(nil)
 			e.ptype = etTest
+			c.entries--
 			c.countCold -= e.size
 			c.countTest += e.size
 			for c.maxSize < c.countTest {
@@ -11,6 +11,19 @@ import (
 	"testing"
 )
 
+// countEntries returns the number of entries in the cache which hold a value.
+func countEntries(c *Cache) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for _, e := range c.blocks {
+		if e.ptype != etTest {
+			n++
+		}
+	}
+	return n
+}
+
 func TestCache(t *testing.T) {
 	// Test data was generated from the python code
 	f, err := os.Open("testdata/cache")
@@ -20,6 +33,7 @@ func TestCache(t *testing.T) {
 
 	cache := New(200)
 	scanner := bufio.NewScanner(f)
+	var hits, misses int64
 
 	for scanner.Scan() {
 		fields := bytes.Fields(scanner.Bytes())
@@ -43,6 +57,20 @@ func TestCache(t *testing.T) {
 		if hit != wantHit {
 			t.Errorf("cache hit mismatch: got %v, want %v\n", hit, wantHit)
 		}
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+		if count, expected := cache.Metrics().Count, countEntries(cache); count != expected {
+			t.Fatalf("cache count mismatch: got %d, want %d", count, expected)
+		}
+	}
+
+	m := cache.Metrics()
+	if m.Hits != hits || m.Misses != misses {
+		t.Errorf("cache metrics mismatch: got %d/%d hits/misses, want %d/%d\n",
+			m.Hits, m.Misses, hits, misses)
 	}
 }
 
@@ -73,6 +101,9 @@ func TestEvictFile(t *testing.T) {
 	if expected, size := int64(20), cache.Size(); expected != size {
 		t.Fatalf("expected cache size %d, but found %d", expected, size)
 	}
+	if expected, count := int64(4), cache.Metrics().Count; expected != count {
+		t.Fatalf("expected cache count %d, but found %d", expected, count)
+	}
 	cache.EvictFile(1)
 	if expected, size := int64(15), cache.Size(); expected != size {
 		t.Fatalf("expected cache size %d, but found %d", expected, size)
@@ -81,4 +112,7 @@ func TestEvictFile(t *testing.T) {
 	if expected, size := int64(0), cache.Size(); expected != size {
 		t.Fatalf("expected cache size %d, but found %d", expected, size)
 	}
+	if expected, count := int64(0), cache.Metrics().Count; expected != count {
+		t.Fatalf("expected cache count %d, but found %d", expected, count)
+	}
 }
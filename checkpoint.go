@@ -0,0 +1,277 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/record"
+	"github.com/petermattis/pebble/vfs"
+)
+
+// checkpointLog is a WAL that is part of a checkpoint. Only the first size
+// bytes of the WAL are copied. A negative size indicates the entire WAL should
+// be copied.
+type checkpointLog struct {
+	fileNum uint64
+	size    int64
+}
+
+// checkpointState is a consistent view of the files making up the DB as of a
+// particular sequence number. While a checkpointState is open, the files it
+// refers to are prevented from being deleted or recycled.
+type checkpointState struct {
+	d                  *DB
+	current            *version
+	logs               []checkpointLog
+	logNumber          uint64
+	nextFileNumber     uint64
+	lastSequence       uint64
+	manifestFileNumber uint64
+	optionsFileNum     uint64
+}
+
+// openCheckpointState captures the current state of the DB. The returned
+// checkpointState must be closed once the files it references have been
+// copied.
+func (d *DB) openCheckpointState() (*checkpointState, error) {
+	if d.opts.ReadOnly {
+		// A read-only DB does not write the OPTIONS file that a checkpoint
+		// requires.
+		return nil, db.ErrReadOnly
+	}
+	if d.opts.DisableWAL {
+		if err := d.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &checkpointState{d: d}
+
+	// Capture the state of the DB while writes to the WAL are blocked. The
+	// prepare function passed to AllocateSeqNum is invoked with the commit
+	// pipeline mutex held which prevents concurrent calls to
+	// commitPipeline.write, giving us a consistent cut of the WAL.
+	prepare := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		// Wait for any memtable switch to complete as the log writer is invalid
+		// while switching.
+		for d.mu.mem.switching {
+			d.mu.mem.cond.Wait()
+		}
+
+		c.current = d.mu.versions.currentVersion()
+		c.current.ref()
+		c.logNumber = d.mu.versions.logNumber
+		c.nextFileNumber = d.mu.versions.nextFileNumber
+		c.lastSequence = atomic.LoadUint64(&d.mu.versions.logSeqNum)
+		c.manifestFileNumber = d.mu.versions.manifestFileNumber
+		c.optionsFileNum = d.optionsFileNum
+
+		// The reference to the current version prevents its sstables from being
+		// deleted. Prevent the live logs from being deleted or recycled while
+		// we're copying them.
+		d.mu.log.pinned = append(d.mu.log.pinned, c.logNumber)
+
+		if d.opts.DisableWAL {
+			return
+		}
+		for i, fileNum := range d.mu.log.queue {
+			if fileNum < c.logNumber {
+				continue
+			}
+			size := int64(-1)
+			if i == len(d.mu.log.queue)-1 {
+				// The last entry in the queue is the active log. Capture its current
+				// size so that we don't copy records written after this point.
+				size = d.mu.log.Size()
+			}
+			c.logs = append(c.logs, checkpointLog{fileNum: fileNum, size: size})
+		}
+	}
+	apply := func(seqNum uint64) {}
+	d.commit.AllocateSeqNum(prepare, apply)
+
+	if !d.opts.DisableWAL {
+		// Ensure the portion of the active log we're going to copy has been
+		// written to the underlying file.
+		if err := d.commitSync(); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// close releases the reference to the captured version and unpins the
+// captured logs, allowing them to be deleted again.
+func (c *checkpointState) close() {
+	c.current.unref()
+
+	d := c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, fileNum := range d.mu.log.pinned {
+		if fileNum == c.logNumber {
+			d.mu.log.pinned = append(d.mu.log.pinned[:i], d.mu.log.pinned[i+1:]...)
+			break
+		}
+	}
+}
+
+// manifest returns a versionEdit containing a snapshot of the captured
+// version, suitable for writing as the sole record of a new MANIFEST.
+func (c *checkpointState) manifest() *versionEdit {
+	ve := &versionEdit{
+		comparatorName: c.d.opts.Comparer.Name,
+		logNumber:      c.logNumber,
+		nextFileNumber: c.nextFileNumber,
+		lastSequence:   c.lastSequence,
+	}
+	for level, files := range c.current.files {
+		for _, meta := range files {
+			ve.newFiles = append(ve.newFiles, newFileEntry{
+				level: level,
+				meta:  meta,
+			})
+		}
+	}
+	return ve
+}
+
+// Checkpoint constructs a snapshot of the DB instance in the specified
+// directory. The sstables in the current version are hard-linked into
+// destDir, while the OPTIONS file and the live WAL files are copied. A new
+// MANIFEST describing the current version is written. Opening destDir will
+// recover the state of the DB as of the sequence number at which the
+// checkpoint was taken. The destination directory must not already exist.
+//
+// If the WAL is disabled, the memtable is flushed before the checkpoint is
+// taken, though writes which are concurrent with the checkpoint may not be
+// present in the checkpoint.
+//
+// Checkpoint is not supported in read-only mode and returns ErrReadOnly.
+func (d *DB) Checkpoint(destDir string) (err error) {
+	fs := d.opts.VFS
+	if _, err := fs.Stat(destDir); err == nil {
+		return fmt.Errorf("pebble: checkpoint directory %q already exists", destDir)
+	}
+
+	c, err := d.openCheckpointState()
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err := fs.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			removeCheckpoint(fs, destDir)
+		}
+	}()
+
+	// Link the sstables in the current version.
+	for _, files := range c.current.files {
+		for i := range files {
+			fileNum := files[i].fileNum
+			srcPath := dbFilename(d.dirname, fileTypeTable, fileNum)
+			destPath := dbFilename(destDir, fileTypeTable, fileNum)
+			if err := fs.Link(srcPath, destPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Copy the OPTIONS file.
+	if err := copyCheckpointFile(fs,
+		dbFilename(d.dirname, fileTypeOptions, c.optionsFileNum),
+		dbFilename(destDir, fileTypeOptions, c.optionsFileNum), -1); err != nil {
+		return err
+	}
+
+	// Copy the live WAL files. Note that WAL files are copied rather than
+	// linked because they are mutable: the active log is being appended to and
+	// obsolete logs are recycled.
+	for _, log := range c.logs {
+		if err := copyCheckpointFile(fs,
+			dbFilename(d.dirname, fileTypeLog, log.fileNum),
+			dbFilename(destDir, fileTypeLog, log.fileNum), log.size); err != nil {
+			return err
+		}
+	}
+
+	// Write a MANIFEST containing a snapshot of the current version, and point
+	// the CURRENT file at it.
+	if err := writeCheckpointManifest(fs, destDir, c.manifestFileNumber, c.manifest()); err != nil {
+		return err
+	}
+	return setCurrentFile(destDir, fs, c.manifestFileNumber)
+}
+
+func writeCheckpointManifest(fs vfs.FS, dirname string, fileNum uint64, ve *versionEdit) error {
+	f, err := fs.Create(dbFilename(dirname, fileTypeManifest, fileNum))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest := record.NewWriter(f)
+	w, err := manifest.Next()
+	if err != nil {
+		return err
+	}
+	if err := ve.encode(w); err != nil {
+		return err
+	}
+	if err := manifest.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// copyCheckpointFile copies the first size bytes of srcPath to destPath. If
+// size is negative the entire file is copied.
+func copyCheckpointFile(fs vfs.FS, srcPath, destPath string, size int64) error {
+	src, err := fs.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := fs.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	var r io.Reader = src
+	if size >= 0 {
+		r = io.LimitReader(src, size)
+	}
+	if _, err := io.Copy(dest, r); err != nil {
+		return err
+	}
+	return dest.Sync()
+}
+
+// removeCheckpoint removes the files in a partially constructed checkpoint.
+func removeCheckpoint(fs vfs.FS, dirname string) {
+	ls, err := fs.List(dirname)
+	if err != nil {
+		return
+	}
+	for _, filename := range ls {
+		fs.Remove(filepath.Join(dirname, filename))
+	}
+	fs.Remove(dirname)
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestCheckpoint(t *testing.T) {
+	for _, disableWAL := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disableWAL=%t", disableWAL), func(t *testing.T) {
+			mem := vfs.NewMem()
+			d, err := Open("db", &db.Options{
+				DisableWAL: disableWAL,
+				VFS:        mem,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Write some keys to an sstable and some keys to the memtable (and
+			// WAL).
+			for i := 0; i < 10; i++ {
+				if err := d.Set([]byte(fmt.Sprintf("a%d", i)), []byte("1"), db.NoSync); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := d.Set([]byte(fmt.Sprintf("b%d", i)), []byte("2"), db.NoSync); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.Delete([]byte("a0"), db.NoSync); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.Checkpoint("checkpoint"); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Checkpoint("checkpoint"); err == nil {
+				t.Fatalf("expected error checkpointing to an existing directory")
+			}
+
+			// Writes after the checkpoint should not be visible in the checkpoint.
+			if err := d.Set([]byte("c"), []byte("3"), db.NoSync); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			d, err = Open("checkpoint", &db.Options{
+				VFS: mem,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			expected := map[string]string{"c": ""}
+			for i := 0; i < 10; i++ {
+				expected[fmt.Sprintf("a%d", i)] = "1"
+				expected[fmt.Sprintf("b%d", i)] = "2"
+			}
+			expected["a0"] = ""
+
+			for key, want := range expected {
+				v, err := d.Get([]byte(key))
+				if want == "" {
+					if err != db.ErrNotFound {
+						t.Fatalf("%s: expected not found, but found %q", key, v)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", key, err)
+				}
+				if got := string(v); got != want {
+					t.Fatalf("%s: expected %q, but found %q", key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointStateDoesNotBlockFlush(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("db", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set([]byte("b"), []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := d.openCheckpointState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flushes and compactions delete obsolete files while the checkpoint is
+	// open, but not the files the checkpoint refers to.
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for _, files := range c.current.files {
+		for i := range files {
+			if _, err := mem.Stat(dbFilename("db", fileTypeTable, files[i].fileNum)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(c.logs) == 0 {
+		t.Fatalf("expected the checkpoint to capture a log")
+	}
+	for _, log := range c.logs {
+		if _, err := mem.Stat(dbFilename("db", fileTypeLog, log.fileNum)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.close()
+
+	// Once the checkpoint is closed, the next flush deletes or recycles the
+	// obsolete logs.
+	if err := d.Set([]byte("c"), []byte("3"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	queue := append([]uint64(nil), d.mu.log.queue...)
+	d.mu.Unlock()
+	for _, log := range c.logs {
+		for _, fileNum := range queue {
+			if fileNum == log.fileNum {
+				t.Fatalf("expected log %d to be obsolete, but found %d", log.fileNum, queue)
+			}
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/petermattis/pebble"
+	"github.com/petermattis/pebble/db"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check <dir>",
+	Short: "verify the invariants of the levels of a database",
+	Long: `
+Verify that the sstables of each level are sorted and non-overlapping, that
+the bounds of each sstable match its contents, that the sequence numbers of a
+key decrease from the newer levels to the older levels, and that the checksum
+of every block verifies. The database is opened read-only.
+`,
+	Args: cobra.ExactArgs(1),
+	Run:  runCheck,
+}
+
+func runCheck(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	d, err := pebble.Open(dir, &db.Options{
+		Comparer: mvccComparer,
+		ReadOnly: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := d.CheckLevels(); err != nil {
+		log.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("checked %s\n", dir)
+}
@@ -29,6 +29,8 @@ var rootCmd = &cobra.Command{
 func main() {
 	cobra.EnableCommandSorting = false
 	rootCmd.AddCommand(
+		checkCmd,
+		repairCmd,
 		scanCmd,
 		syncCmd,
 		ycsbCmd,
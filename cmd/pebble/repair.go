@@ -0,0 +1,36 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/petermattis/pebble"
+	"github.com/petermattis/pebble/db"
+	"github.com/spf13/cobra"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair <dir>",
+	Short: "rebuild the MANIFEST of a database",
+	Long: `
+Rebuild the MANIFEST of a database whose CURRENT file or MANIFEST is missing
+or corrupt. The sstables which cannot be read are moved to the "lost"
+subdirectory.
+`,
+	Args: cobra.ExactArgs(1),
+	Run:  runRepair,
+}
+
+func runRepair(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	if err := pebble.Repair(dir, &db.Options{
+		Comparer: mvccComparer,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("repaired %s\n", dir)
+}
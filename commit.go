@@ -213,6 +213,15 @@ type commitPipeline struct {
 		closed  bool
 		pending []*Batch
 	}
+
+	// The goroutines blocked in waitForPublish. The condition variable is
+	// signaled whenever the visible sequence number is ratcheted while waiters
+	// is non-zero.
+	publisher struct {
+		sync.Mutex
+		cond    sync.Cond
+		waiters int32
+	}
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
@@ -222,6 +231,7 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 	p.cond.L = &p.mu
 	p.pending.init()
 	p.syncer.cond.L = &p.syncer.Mutex
+	p.publisher.cond.L = &p.publisher.Mutex
 	go p.syncLoop()
 	return p
 }
@@ -267,14 +277,30 @@ func (p *commitPipeline) Close() {
 // Commit the specified batch, writing it to the WAL, optionally syncing the
 // WAL, and applying the batch to the memtable. Upon successful return the
 // batch's mutations will be visible for reading.
-func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
+//
+// If validate is non-nil, it is invoked before the batch is assigned a
+// sequence number, once every batch that was previously assigned a sequence
+// number has been published. No other batch can commit while validate is
+// running. If validate returns an error the batch is not committed and the
+// error is returned.
+func (p *commitPipeline) Commit(b *Batch, syncWAL bool, validate func() error) error {
 	if len(b.storage.data) == 0 {
 		return nil
 	}
 
+	p.mu.Lock()
+
+	if validate != nil {
+		p.waitForPublish()
+		if err := validate(); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+	}
+
 	// Prepare the batch for committing: enqueuing the batch in the pending
 	// queue, determining the batch sequence number and writing the data to the
-	// WAL.
+	// WAL. Note that prepare releases commitPipeline.mu.
 	mem, err := p.prepare(b, syncWAL)
 	if err != nil {
 		// TODO(peter): what to do on error? the pipeline will be horked at this
@@ -324,11 +350,9 @@ func (p *commitPipeline) AllocateSeqNum(prepare func(), apply func(seqNum uint64
 	p.pending.enqueue(b, &p.cond)
 
 	// Assign the batch a sequence number.
-	seqNum := *p.env.logSeqNum
-	*p.env.logSeqNum++
+	seqNum := atomic.AddUint64(p.env.logSeqNum, 1) - 1
 	if seqNum == 0 {
-		seqNum = *p.env.logSeqNum
-		*p.env.logSeqNum++
+		seqNum = atomic.AddUint64(p.env.logSeqNum, 1) - 1
 		b.setCount(2)
 	}
 	b.setSeqNum(seqNum)
@@ -347,9 +371,12 @@ func (p *commitPipeline) AllocateSeqNum(prepare func(), apply func(seqNum uint64
 	p.publish(b)
 }
 
+// prepare must be called with commitPipeline.mu held. It releases the mutex
+// once the batch has been written to the WAL.
 func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 	n := uint64(b.count())
 	if n == invalidBatchCount {
+		p.mu.Unlock()
 		return nil, ErrInvalidBatch
 	}
 	count := 1
@@ -358,16 +385,15 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 	}
 	b.commit.Add(count)
 
-	p.mu.Lock()
-
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
 	p.pending.enqueue(b, &p.cond)
 
 	// Assign the batch a sequence number.
-	b.setSeqNum(*p.env.logSeqNum)
-	*p.env.logSeqNum += n
+	// Note that logSeqNum is read atomically, without holding commitPipeline.mu,
+	// when a version edit is logged.
+	b.setSeqNum(atomic.AddUint64(p.env.logSeqNum, n) - n)
 
 	// Write the data to the WAL.
 	mem, err := p.env.write(b)
@@ -385,6 +411,25 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 	return mem, err
 }
 
+// waitForPublish waits for all of the batches which have been assigned
+// sequence numbers to be published. It must be called with commitPipeline.mu
+// held, which prevents new sequence numbers from being assigned.
+func (p *commitPipeline) waitForPublish() {
+	seqNum := atomic.LoadUint64(p.env.logSeqNum)
+	if atomic.LoadUint64(p.env.visibleSeqNum) >= seqNum {
+		return
+	}
+
+	w := &p.publisher
+	w.Lock()
+	atomic.AddInt32(&w.waiters, 1)
+	for atomic.LoadUint64(p.env.visibleSeqNum) < seqNum {
+		w.cond.Wait()
+	}
+	atomic.AddInt32(&w.waiters, -1)
+	w.Unlock()
+}
+
 func (p *commitPipeline) publish(b *Batch) {
 	// Mark the batch as applied.
 	atomic.StoreUint32(&b.applied, 1)
@@ -417,7 +462,13 @@ func (p *commitPipeline) publish(b *Batch) {
 				break
 			}
 			if atomic.CompareAndSwapUint64(p.env.visibleSeqNum, curSeqNum, newSeqNum) {
-				// We successfully published t's sequence number.
+				// We successfully published t's sequence number. Wake up anyone
+				// waiting for it.
+				if atomic.LoadInt32(&p.publisher.waiters) > 0 {
+					p.publisher.Lock()
+					p.publisher.cond.Broadcast()
+					p.publisher.Unlock()
+				}
 				break
 			}
 		}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/petermattis/pebble/db"
@@ -40,8 +42,11 @@ type compaction struct {
 	version *version
 
 	// level is the level that is being compacted. Inputs from level and
-	// level+1 will be merged to produce a set of level+1 files.
+	// outputLevel will be merged to produce a set of outputLevel files.
 	level int
+	// outputLevel is the level the compaction writes to. It is level+1, except
+	// for some compactions picked by the universal compaction style.
+	outputLevel int
 
 	// maxOutputFileSize is the maximum size of an individual table created
 	// during compaction.
@@ -62,6 +67,16 @@ type compaction struct {
 	grandparents    []fileMetadata
 	overlappedBytes uint64 // bytes of overlap with grandparent tables
 	seenKey         bool   // some output key has been seen
+
+	// lower and upper bound the user keys processed by a sub-compaction to the
+	// range [lower, upper). A nil bound leaves that side of the range
+	// unbounded.
+	lower, upper []byte
+
+	// deletionHints are generated from the range tombstones written to the
+	// compaction outputs. They are added to the DB's hints once the compaction
+	// has been applied to the current version.
+	deletionHints []deletionHint
 }
 
 func newCompaction(opts *db.Options, cur *version, level int) *compaction {
@@ -69,6 +84,7 @@ func newCompaction(opts *db.Options, cur *version, level int) *compaction {
 		cmp:               opts.Comparer.Compare,
 		version:           cur,
 		level:             level,
+		outputLevel:       level + 1,
 		maxOutputFileSize: uint64(opts.Level(level + 1).TargetFileSize),
 		maxOverlapBytes:   maxGrandparentOverlapBytes(opts, level+1),
 		maxExpandedBytes:  expandedCompactionByteSizeLimit(opts, level+1),
@@ -76,6 +92,15 @@ func newCompaction(opts *db.Options, cur *version, level int) *compaction {
 	return c
 }
 
+// trivialMove returns true if the compaction can be performed by moving the
+// single input table from one level to the next. We avoid such a move if there
+// is lots of overlapping grandparent data. Otherwise, the move could create a
+// parent file that will require a very expensive merge later on.
+func (c *compaction) trivialMove() bool {
+	return len(c.inputs[0]) == 1 && len(c.inputs[1]) == 0 &&
+		totalSize(c.grandparents) <= c.maxOverlapBytes
+}
+
 // setupOtherInputs fills in the rest of the compaction inputs, regardless of
 // whether the compaction was automatically scheduled or user initiated.
 func (c *compaction) setupOtherInputs() {
@@ -222,12 +247,17 @@ func (c *compaction) allowZeroSeqNum() bool {
 
 // elideTombstone returns true if it is ok to elide a tombstone for the
 // specified key. A return value of true guarantees that there are no key/value
-// pairs at c.level+2 or higher that possibly contain the specified user key.
+// pairs at c.outputLevel+1 or higher that possibly contain the specified user
+// key. Tombstones are never elided by compactions into L0, as older L0 tables
+// may not be part of the compaction.
 func (c *compaction) elideTombstone(key []byte) bool {
+	if c.outputLevel == 0 {
+		return false
+	}
 	// TODO(peter): this can be faster if ukey is always increasing between
 	// successive elideTombstones calls and we can keep some state in between
 	// calls.
-	for level := c.level + 2; level < numLevels; level++ {
+	for level := c.outputLevel + 1; level < numLevels; level++ {
 		for _, f := range c.version.files[level] {
 			if c.cmp(key, f.largest.UserKey) <= 0 {
 				if c.cmp(key, f.smallest.UserKey) >= 0 {
@@ -244,9 +274,13 @@ func (c *compaction) elideTombstone(key []byte) bool {
 
 // elideRangeTombstone returns true if it is ok to elide the specified range
 // tombstone. A return value of true guarantees that there are no key/value
-// pairs at c.level+2 or higher that possibly overlap the specified tombstone.
+// pairs at c.outputLevel+1 or higher that possibly overlap the specified
+// tombstone.
 func (c *compaction) elideRangeTombstone(start, end []byte) bool {
-	for level := c.level + 2; level < numLevels; level++ {
+	if c.outputLevel == 0 {
+		return false
+	}
+	for level := c.outputLevel + 1; level < numLevels; level++ {
 		overlaps := c.version.overlaps(level, c.cmp, start, end)
 		if len(overlaps) > 0 {
 			return false
@@ -345,41 +379,135 @@ func (c *compaction) newInputIter(
 		}
 		if rangeDelIter != nil {
 			// Truncate the range tombstones returned by the iterator to the upper
-			// bound of the atomic compaction unit.
+			// bound of the atomic compaction unit, and to the bounds of the
+			// sub-compaction.
 			lowerBound, upperBound := c.atomicUnitBounds(f)
+			if c.lower != nil && (lowerBound == nil || c.cmp(lowerBound, c.lower) < 0) {
+				lowerBound = c.lower
+			}
+			if c.upper != nil && (upperBound == nil || c.cmp(upperBound, c.upper) > 0) {
+				upperBound = c.upper
+			}
 			if lowerBound != nil || upperBound != nil {
 				rangeDelIter = rangedel.Truncate(c.cmp, rangeDelIter, lowerBound, upperBound)
 			}
+		} else if err == nil {
+			// A nil iterator would terminate the levelIter, skipping the range
+			// tombstones in the subsequent tables in the level.
+			rangeDelIter = emptyIter
 		}
 		return rangeDelIter, nil, err
 	}
 
+	// The point iterators of a sub-compaction enforce its upper bound, while
+	// the lower bound is enforced by boundedInputIter.First.
+	var opts *db.IterOptions
+	if c.lower != nil || c.upper != nil {
+		opts = &db.IterOptions{
+			LowerBound: c.lower,
+			UpperBound: c.upper,
+		}
+	}
+
 	if c.level != 0 {
-		iters = append(iters, newLevelIter(nil, c.cmp, newIters, c.inputs[0]))
-		iters = append(iters, newLevelIter(nil, c.cmp, newRangeDelIter, c.inputs[0]))
+		iters = append(iters, newLevelIter(opts, c.cmp, newIters, c.inputs[0]))
+		iters = append(iters, newLevelIter(opts, c.cmp, newRangeDelIter, c.inputs[0]))
 	} else {
 		for i := range c.inputs[0] {
 			f := &c.inputs[0][i]
-			iter, rangeDelIter, err := newIters(f, nil /* iter options */)
+			iter, rangeDelIter, err := newIters(f, opts)
 			if err != nil {
 				return nil, fmt.Errorf("pebble: could not open table %d: %v", f.fileNum, err)
 			}
 			iters = append(iters, iter)
 			if rangeDelIter != nil {
+				if opts != nil {
+					rangeDelIter = rangedel.Truncate(c.cmp, rangeDelIter, c.lower, c.upper)
+				}
 				iters = append(iters, rangeDelIter)
 			}
 		}
 	}
 
-	iters = append(iters, newLevelIter(nil, c.cmp, newIters, c.inputs[1]))
-	iters = append(iters, newLevelIter(nil, c.cmp, newRangeDelIter, c.inputs[1]))
-	return newMergingIter(c.cmp, iters...), nil
+	iters = append(iters, newLevelIter(opts, c.cmp, newIters, c.inputs[1]))
+	iters = append(iters, newLevelIter(opts, c.cmp, newRangeDelIter, c.inputs[1]))
+	var iter internalIterator = newMergingIter(c.cmp, iters...)
+	if c.lower != nil {
+		iter = &boundedInputIter{internalIterator: iter, lower: c.lower}
+	}
+	return iter, nil
+}
+
+// boundedInputIter positions the input iterator of a sub-compaction at the
+// lower bound of the sub-compaction when First is called.
+type boundedInputIter struct {
+	internalIterator
+	lower []byte
+}
+
+func (i *boundedInputIter) First() (*db.InternalKey, []byte) {
+	return i.internalIterator.SeekGE(i.lower)
+}
+
+// subCompactionBounds returns up to n-1 user keys at which the compaction can
+// be split into n sub-compactions. The keys are chosen from the smallest keys
+// of the level+1 and grandparent tables, and are spaced so that each
+// sub-compaction overlaps a similar number of tables.
+func (c *compaction) subCompactionBounds(n int) [][]byte {
+	if n <= 1 || c.outputLevel == 0 {
+		// A compaction into L0 produces a single table.
+		return nil
+	}
+	smallest, largest := ikeyRange(c.cmp, c.inputs[0], c.inputs[1])
+	var keys [][]byte
+	add := func(files []fileMetadata) {
+		for i := range files {
+			key := files[i].smallest.UserKey
+			if c.cmp(key, smallest.UserKey) > 0 && c.cmp(key, largest.UserKey) <= 0 {
+				keys = append(keys, key)
+			}
+		}
+	}
+	add(c.inputs[1])
+	add(c.grandparents)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.cmp(keys[i], keys[j]) < 0
+	})
+	j := 1
+	for i := 1; i < len(keys); i++ {
+		if c.cmp(keys[j-1], keys[i]) != 0 {
+			keys[j] = keys[i]
+			j++
+		}
+	}
+	keys = keys[:j]
+
+	if len(keys) < n {
+		return keys
+	}
+	bounds := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		bounds = append(bounds, keys[i*len(keys)/n])
+	}
+	return bounds
+}
+
+// inputLevel returns the level of the tables in c.inputs[i].
+func (c *compaction) inputLevel(i int) int {
+	if i == 0 {
+		return c.level
+	}
+	return c.outputLevel
 }
 
 func (c *compaction) String() string {
 	var buf bytes.Buffer
 	for i := range c.inputs {
-		fmt.Fprintf(&buf, "%d:", i+c.level)
+		fmt.Fprintf(&buf, "%d:", c.inputLevel(i))
 		for _, f := range c.inputs[i] {
 			fmt.Fprintf(&buf, " %d:%s-%s", f.fileNum, f.smallest, f.largest)
 		}
@@ -395,11 +523,242 @@ type manualCompaction struct {
 	end   db.InternalKey
 }
 
+// deletionHint records that the range tombstones in an sstable cover the
+// user key range [start,end), allowing sstables in lower levels which are
+// entirely contained within that range to be deleted without being compacted.
+// The hints are held in memory only and are discarded if the DB is closed.
+type deletionHint struct {
+	// The level and file number of the sstable containing the tombstones.
+	level   int
+	fileNum uint64
+	// The user key range covered by the tombstones.
+	start, end []byte
+	// The smallest and largest sequence numbers of the tombstones covering the
+	// range. Every key in [start,end) is deleted by a tombstone with a sequence
+	// number of at least smallestSeqNum.
+	smallestSeqNum uint64
+	largestSeqNum  uint64
+	// True if the hint's sstable contains only range tombstones, in which case
+	// the sstable itself is deleted once no older sstable overlaps it.
+	onlyTombstones bool
+}
+
+// canDelete returns true if the hint allows the sstable f, which resides in a
+// level below the hint's sstable, to be deleted. The sstable must be entirely
+// contained within the hint's range and every entry within it must be deleted
+// by the tombstones. Additionally, no snapshot may be able to see entries in
+// the sstable without also seeing the tombstones.
+func (h *deletionHint) canDelete(cmp db.Compare, f *fileMetadata, snapshots []uint64) bool {
+	if cmp(f.smallest.UserKey, h.start) < 0 {
+		return false
+	}
+	// The end of the range is exclusive, though an sstable whose largest key is
+	// a range deletion sentinel does not contain its largest user key.
+	if c := cmp(f.largest.UserKey, h.end); c > 0 ||
+		(c == 0 && f.largest.Trailer != db.InternalKeyRangeDeleteSentinel) {
+		return false
+	}
+	if f.largestSeqNum >= h.smallestSeqNum {
+		return false
+	}
+	// A snapshot at seqnum s sees entries with seqnums less than s. A snapshot
+	// which sees entries in the sstable, but not all of the tombstones, needs
+	// the sstable.
+	for _, s := range snapshots {
+		if f.smallestSeqNum < s && s <= h.largestSeqNum {
+			return false
+		}
+	}
+	return true
+}
+
+// makeDeletionHints returns the deletion hints for the range tombstones
+// written to the sstable described by meta, which resides in the specified
+// level. The tombstones must be fragmented and sorted, as returned by
+// compactionIter.Tombstones. Adjacent fragments are coalesced into a single
+// hint so that a hint can cover sstables spanning multiple fragments.
+// onlyTombstones indicates whether the sstable contains no point entries.
+func makeDeletionHints(
+	cmp db.Compare,
+	level int,
+	meta *fileMetadata,
+	tombstones []rangedel.Tombstone,
+	onlyTombstones bool,
+) []deletionHint {
+	var hints []deletionHint
+	var cur *deletionHint
+	for i := 0; i < len(tombstones); {
+		// The fragments with the same start key share the same end key and are
+		// sorted by decreasing seqnum. The newest fragment determines the seqnum
+		// below which keys in the fragment are deleted.
+		t := &tombstones[i]
+		seqNum := t.Start.SeqNum()
+		for i++; i < len(tombstones) && cmp(tombstones[i].Start.UserKey, t.Start.UserKey) == 0; i++ {
+		}
+
+		if cur != nil && cmp(cur.end, t.Start.UserKey) == 0 {
+			cur.end = t.End
+			if cur.smallestSeqNum > seqNum {
+				cur.smallestSeqNum = seqNum
+			}
+			if cur.largestSeqNum < seqNum {
+				cur.largestSeqNum = seqNum
+			}
+			continue
+		}
+		hints = append(hints, deletionHint{
+			level:          level,
+			fileNum:        meta.fileNum,
+			start:          t.Start.UserKey,
+			end:            t.End,
+			smallestSeqNum: seqNum,
+			largestSeqNum:  seqNum,
+			onlyTombstones: onlyTombstones,
+		})
+		cur = &hints[len(hints)-1]
+	}
+
+	// The tombstones are truncated to the bounds of the sstable. Note that the
+	// largest key of the sstable is treated as exclusive, which is
+	// conservative.
+	n := 0
+	for i := range hints {
+		h := &hints[i]
+		if cmp(h.start, meta.smallest.UserKey) < 0 {
+			h.start = meta.smallest.UserKey
+		}
+		if cmp(h.end, meta.largest.UserKey) > 0 {
+			h.end = meta.largest.UserKey
+		}
+		if cmp(h.start, h.end) >= 0 {
+			continue
+		}
+		// The tombstone keys may point into memory which is reused.
+		h.start = append([]byte(nil), h.start...)
+		h.end = append([]byte(nil), h.end...)
+		hints[n] = *h
+		n++
+	}
+	return hints[:n]
+}
+
+// pickDeleteOnlyCompactionLocked returns a version edit which deletes the
+// sstables covered by the deletion hints, or nil if there are no such
+// sstables. The sstables containing only range tombstones are deleted as well
+// once no older sstable overlaps them, as their tombstones no longer delete
+// anything. Hints whose sstable is no longer present in the current version,
+// and hints which no longer cover any older sstable, are discarded.
+//
+// d.mu must be held when calling this.
+func (d *DB) pickDeleteOnlyCompactionLocked() *versionEdit {
+	hints := d.mu.compact.deletionHints
+	if len(hints) == 0 {
+		return nil
+	}
+
+	current := d.mu.versions.currentVersion()
+	snapshots := d.mu.snapshots.toSlice()
+	ve := &versionEdit{
+		deletedFiles: map[deletedFileEntry]bool{},
+	}
+	n := 0
+	for i := range hints {
+		h := &hints[i]
+		if findFile(current.files[h.level], h.fileNum) < 0 {
+			continue
+		}
+		hints[n] = *h
+		n++
+
+		for level := h.level + 1; level < numLevels; level++ {
+			overlaps := current.overlaps(level, d.cmp, h.start, h.end)
+			for j := range overlaps {
+				f := &overlaps[j]
+				if !h.canDelete(d.cmp, f, snapshots) || d.mu.compact.inProgress.busy(f.fileNum) {
+					continue
+				}
+				ve.deletedFiles[deletedFileEntry{level: level, fileNum: f.fileNum}] = true
+			}
+		}
+	}
+	hints = hints[:n]
+
+	// Discard the hints which do not cover any older sstable that remains. Such
+	// a hint cannot become useful again: the sstables later compacted into the
+	// range are newer than its tombstones.
+	n = 0
+	for i := range hints {
+		h := &hints[i]
+		files := current.files[h.level]
+		f := &files[findFile(files, h.fileNum)]
+		if h.onlyTombstones && !ve.deletedFiles[deletedFileEntry{level: h.level, fileNum: f.fileNum}] &&
+			!d.mu.compact.inProgress.busy(f.fileNum) &&
+			!overlapsOlder(d.cmp, current, h.level, f, f.smallest.UserKey, f.largest.UserKey, ve) {
+			ve.deletedFiles[deletedFileEntry{level: h.level, fileNum: f.fileNum}] = true
+		}
+		if ve.deletedFiles[deletedFileEntry{level: h.level, fileNum: f.fileNum}] ||
+			!overlapsOlder(d.cmp, current, h.level, f, h.start, h.end, ve) {
+			continue
+		}
+		hints[n] = *h
+		n++
+	}
+	d.mu.compact.deletionHints = hints[:n]
+
+	if len(ve.deletedFiles) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// findFile returns the index of the sstable with the specified file number in
+// files, or -1 if there is no such sstable.
+func findFile(files []fileMetadata, fileNum uint64) int {
+	for i := range files {
+		if files[i].fileNum == fileNum {
+			return i
+		}
+	}
+	return -1
+}
+
+// overlapsOlder returns true if an sstable older than f, which resides in the
+// specified level, overlaps the user key range [start,end]. The older sstables
+// are those in the levels below, and, for an sstable in L0, the L0 sstables
+// preceding it. The sstables deleted by ve are ignored.
+func overlapsOlder(
+	cmp db.Compare, v *version, level int, f *fileMetadata, start, end []byte, ve *versionEdit,
+) bool {
+	if level == 0 {
+		for i := range v.files[0] {
+			g := &v.files[0][i]
+			if g.fileNum == f.fileNum {
+				break
+			}
+			if ve.deletedFiles[deletedFileEntry{level: 0, fileNum: g.fileNum}] {
+				continue
+			}
+			if cmp(g.largest.UserKey, start) >= 0 && cmp(g.smallest.UserKey, end) <= 0 {
+				return true
+			}
+		}
+	}
+	for l := level + 1; l < numLevels; l++ {
+		overlaps := v.overlaps(l, cmp, start, end)
+		for i := range overlaps {
+			if !ve.deletedFiles[deletedFileEntry{level: l, fileNum: overlaps[i].fileNum}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // maybeScheduleFlush schedules a flush if necessary.
 //
 // d.mu must be held when calling this.
 func (d *DB) maybeScheduleFlush() {
-	if d.mu.compact.flushing || d.mu.closed {
+	if d.mu.compact.flushing || d.mu.closed || d.opts.ReadOnly {
 		return
 	}
 	if len(d.mu.mem.queue) <= 1 {
@@ -478,8 +837,10 @@ func (d *DB) flush1() error {
 		})
 	}
 
-	meta, err := d.writeLevel0Table(d.opts.VFS, iter,
+	startTime := time.Now()
+	meta, hints, err := d.writeLevel0Table(d.opts.VFS, iter,
 		true /* allowRangeTombstoneElision */)
+	duration := time.Since(startTime)
 
 	if d.opts.EventListener != nil && d.opts.EventListener.FlushEnd != nil {
 		info := db.FlushInfo{
@@ -519,6 +880,15 @@ func (d *DB) flush1() error {
 		return err
 	}
 
+	d.mu.compact.deletionHints = append(d.mu.compact.deletionHints, hints...)
+
+	metrics := &d.mu.versions.metrics
+	metrics.Flush.Count++
+	metrics.Flush.Duration += duration
+	for i := range ve.newFiles {
+		metrics.Levels[0].BytesWritten += ve.newFiles[i].meta.size
+	}
+
 	// Mark all the memtables we flushed as flushed.
 	for i := 0; i < n; i++ {
 		close(d.mu.mem.queue[i].flushed())
@@ -529,7 +899,8 @@ func (d *DB) flush1() error {
 	return nil
 }
 
-// writeLevel0Table writes a memtable to a level-0 on-disk table.
+// writeLevel0Table writes a memtable to a level-0 on-disk table. It returns
+// the deletion hints for the range tombstones written to the table.
 //
 // If no error is returned, it adds the file number of that on-disk table to
 // d.pendingOutputs. It is the caller's responsibility to remove that fileNum
@@ -539,7 +910,7 @@ func (d *DB) flush1() error {
 // re-acquired during the course of this method.
 func (d *DB) writeLevel0Table(
 	fs vfs.FS, iiter internalIterator, allowRangeTombstoneElision bool,
-) (meta fileMetadata, err error) {
+) (meta fileMetadata, hints []deletionHint, err error) {
 	meta.fileNum = d.mu.versions.nextFileNum()
 	filename := dbFilename(d.dirname, fileTypeTable, meta.fileNum)
 	d.mu.compact.pendingOutputs[meta.fileNum] = struct{}{}
@@ -583,12 +954,17 @@ func (d *DB) writeLevel0Table(
 			// set of L0 tables.
 			return false
 		}
-		lower, _ := iiter.First()
+		// NB: the key returned by First may be invalidated by the call to Last,
+		// so it is copied.
+		var lower []byte
+		if key, _ := iiter.First(); key != nil {
+			lower = append(lower, key.UserKey...)
+		}
 		upper, _ := iiter.Last()
 		if lower == nil || upper == nil {
 			return false
 		}
-		return elideRangeTombstone(lower.UserKey, upper.UserKey)
+		return elideRangeTombstone(lower, upper.UserKey)
 	}()
 
 	iter := newCompactionIter(
@@ -596,6 +972,7 @@ func (d *DB) writeLevel0Table(
 		allowZeroSeqNum,
 		func([]byte) bool { return false },
 		elideRangeTombstone,
+		d.compactionFilter(0),
 	)
 	var (
 		file vfs.File
@@ -611,121 +988,175 @@ func (d *DB) writeLevel0Table(
 		if err != nil {
 			fs.Remove(filename)
 			meta = fileMetadata{}
+			hints = nil
 		}
 	}()
 
 	file, err = fs.Create(filename)
 	if err != nil {
-		return fileMetadata{}, err
+		return fileMetadata{}, nil, err
 	}
+	file = newRateLimitedFile(file, d.opts.RateLimiter, db.IOPriorityHigh)
 	tw = sstable.NewWriter(file, d.opts, d.opts.Level(0))
 
 	var count int
 	for key, val := iter.First(); key != nil; key, val = iter.Next() {
 		if err1 := tw.Add(*key, val); err1 != nil {
-			return fileMetadata{}, err1
+			return fileMetadata{}, nil, err1
 		}
 		count++
 	}
 
-	for _, v := range iter.Tombstones(nil) {
+	tombstones := iter.Tombstones(nil)
+	for _, v := range tombstones {
 		if err1 := tw.Add(v.Start, v.End); err1 != nil {
-			return fileMetadata{}, err1
+			return fileMetadata{}, nil, err1
 		}
 		count++
 	}
 
 	if err1 := iter.Close(); err1 != nil {
 		iter = nil
-		return fileMetadata{}, err1
+		return fileMetadata{}, nil, err1
 	}
 	iter = nil
 
 	if err1 := tw.Close(); err1 != nil {
 		tw = nil
-		return fileMetadata{}, err1
+		return fileMetadata{}, nil, err1
 	}
 
 	if count == 0 {
 		// The flush may have produced an empty table if a range tombstone deleted
 		// all the entries in the table and the range tombstone could be elided.
-		return fileMetadata{}, errEmptyTable
+		return fileMetadata{}, nil, errEmptyTable
 	}
 
 	writerMeta, err := tw.Metadata()
 	if err != nil {
-		return fileMetadata{}, err
+		return fileMetadata{}, nil, err
 	}
 	meta.size = writerMeta.Size
 	meta.smallest = writerMeta.Smallest(d.cmp)
 	meta.largest = writerMeta.Largest(d.cmp)
 	meta.smallestSeqNum = writerMeta.SmallestSeqNum
 	meta.largestSeqNum = writerMeta.LargestSeqNum
+	meta.creationTime = writerMeta.CreationTime
 	tw = nil
 
 	// TODO(peter): compaction stats.
 
-	return meta, nil
+	onlyTombstones := writerMeta.LargestPoint.UserKey == nil
+	return meta, makeDeletionHints(d.cmp, 0, &meta, tombstones, onlyTombstones), nil
 }
 
-// maybeScheduleCompaction schedules a compaction if necessary.
+// maybeScheduleCompaction schedules compactions if necessary, up to
+// Options.MaxConcurrentCompactions at a time.
 //
 // d.mu must be held when calling this.
 func (d *DB) maybeScheduleCompaction() {
-	if d.mu.compact.compacting || d.mu.closed {
+	if d.mu.closed || d.opts.ReadOnly {
 		return
 	}
 
-	if len(d.mu.compact.manual) > 0 {
-		d.mu.compact.compacting = true
-		go d.compact()
-		return
-	}
+	for d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
+		if len(d.mu.compact.manual) > 0 {
+			manual := d.mu.compact.manual[0]
+			c := d.mu.versions.picker.pickManual(d.opts, manual)
+			if c == nil {
+				d.mu.compact.manual = d.mu.compact.manual[1:]
+				manual.done <- nil
+				continue
+			}
+			if d.mu.compact.inProgress.conflicts(d.cmp, c) {
+				// The manual compaction is retried when a compaction in progress
+				// finishes. No automatic compactions are started in the meantime
+				// so that the manual compaction is not starved.
+				return
+			}
+			d.mu.compact.manual = d.mu.compact.manual[1:]
+			d.mu.compact.inProgress.add(c)
+			d.mu.compact.compactingCount++
+			go d.compact(c, manual)
+			continue
+		}
 
-	if !d.mu.versions.picker.compactionNeeded() {
-		// There is no work to be done.
-		return
-	}
+		// Deleting sstables covered by range tombstones is cheap, so it takes
+		// priority over compactions which rewrite sstables.
+		if ve := d.pickDeleteOnlyCompactionLocked(); ve != nil {
+			for e := range ve.deletedFiles {
+				d.mu.compact.inProgress.addFile(e.fileNum)
+			}
+			d.mu.compact.compactingCount++
+			go d.compactDeleteOnly(ve)
+			continue
+		}
+
+		if d.opts.CompactionStyle == db.CompactionStyleFIFO {
+			now := uint64(d.opts.Clock.Now().Unix())
+			ve := d.mu.versions.picker.pickFIFO(d.opts, now, &d.mu.compact.inProgress)
+			if ve == nil {
+				// There is no work to be done.
+				return
+			}
+			for e := range ve.deletedFiles {
+				d.mu.compact.inProgress.addFile(e.fileNum)
+			}
+			d.mu.compact.compactingCount++
+			go d.compactDeleteOnly(ve)
+			continue
+		}
 
-	d.mu.compact.compacting = true
-	go d.compact()
+		c := d.mu.versions.picker.pickAuto(d.opts, &d.mu.compact.inProgress)
+		if c == nil {
+			// There is no work to be done.
+			return
+		}
+		d.mu.compact.inProgress.add(c)
+		d.mu.compact.compactingCount++
+		go d.compact(c, nil)
+	}
 }
 
 // compact runs one compaction and maybe schedules another call to compact.
-func (d *DB) compact() {
+func (d *DB) compact(c *compaction, manual *manualCompaction) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if err := d.compact1(); err != nil {
-		// TODO(peter): count consecutive compaction errors and backoff.
-		_ = err
+	// TODO(peter): count consecutive compaction errors and backoff.
+	err := d.compact1(c)
+	d.mu.compact.inProgress.remove(c)
+	d.mu.compact.compactingCount--
+	if manual != nil {
+		manual.done <- err
 	}
-	d.mu.compact.compacting = false
 	// The previous compaction may have produced too many files in a
 	// level, so reschedule another compaction if needed.
 	d.maybeScheduleCompaction()
 	d.mu.compact.cond.Broadcast()
 }
 
+// compactDeleteOnly runs one delete-only compaction and maybe schedules
+// another compaction.
+func (d *DB) compactDeleteOnly(ve *versionEdit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.deleteOnlyCompaction(ve); err != nil {
+		// TODO(peter): count consecutive compaction errors and backoff.
+		_ = err
+	}
+	for e := range ve.deletedFiles {
+		d.mu.compact.inProgress.removeFile(e.fileNum)
+	}
+	d.mu.compact.compactingCount--
+	d.maybeScheduleCompaction()
+	d.mu.compact.cond.Broadcast()
+}
+
 // compact1 runs one compaction.
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
-func (d *DB) compact1() (err error) {
-	var c *compaction
-	if len(d.mu.compact.manual) > 0 {
-		manual := d.mu.compact.manual[0]
-		d.mu.compact.manual = d.mu.compact.manual[1:]
-		c = d.mu.versions.picker.pickManual(d.opts, manual)
-		defer func() {
-			manual.done <- err
-		}()
-	} else {
-		c = d.mu.versions.picker.pickAuto(d.opts)
-	}
-	if c == nil {
-		return nil
-	}
-
+func (d *DB) compact1(c *compaction) (err error) {
 	jobID := d.mu.nextJobID
 	d.mu.nextJobID++
 	if d.opts.EventListener != nil && d.opts.EventListener.CompactionBegin != nil {
@@ -735,16 +1166,18 @@ func (d *DB) compact1() (err error) {
 		d.opts.EventListener.CompactionBegin(info)
 	}
 
+	startTime := time.Now()
 	ve, pendingOutputs, err := d.compactDiskTables(c)
+	duration := time.Since(startTime)
 
 	if d.opts.EventListener != nil && d.opts.EventListener.CompactionEnd != nil {
 		info := db.CompactionInfo{
 			JobID: jobID,
 			Err:   err,
 		}
-		if err != nil {
+		if err == nil {
 			info.Input.Level = c.level
-			info.Output.Level = c.level + 1
+			info.Output.Level = c.outputLevel
 			for i := range c.inputs {
 				for j := range c.inputs[i] {
 					m := &c.inputs[i][j]
@@ -772,30 +1205,99 @@ func (d *DB) compact1() (err error) {
 	if err != nil {
 		return err
 	}
+
+	d.mu.compact.deletionHints = append(d.mu.compact.deletionHints, c.deletionHints...)
+
+	metrics := &d.mu.versions.metrics
+	metrics.Compact.Count++
+	metrics.Compact.Duration += duration
+	outputLevel := &metrics.Levels[c.outputLevel]
+	if c.trivialMove() {
+		outputLevel.BytesMoved += totalSize(c.inputs[0])
+	} else {
+		outputLevel.BytesIn += totalSize(c.inputs[0])
+		outputLevel.BytesRead += totalSize(c.inputs[0]) + totalSize(c.inputs[1])
+		for i := range ve.newFiles {
+			outputLevel.BytesWritten += ve.newFiles[i].meta.size
+		}
+	}
+
 	d.updateReadStateLocked()
 	d.deleteObsoleteFiles(jobID)
 	return nil
 }
 
+// deleteOnlyCompaction runs a compaction that deletes sstables which are
+// entirely covered by range tombstones in higher levels, as determined by
+// pickDeleteOnlyCompactionLocked. The sstables are removed from the LSM
+// without being read or rewritten.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) deleteOnlyCompaction(ve *versionEdit) error {
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	if d.opts.EventListener != nil && d.opts.EventListener.CompactionBegin != nil {
+		d.opts.EventListener.CompactionBegin(db.CompactionInfo{
+			JobID: jobID,
+		})
+	}
+
+	err := d.mu.versions.logAndApply(ve)
+
+	if d.opts.EventListener != nil && d.opts.EventListener.CompactionEnd != nil {
+		d.opts.EventListener.CompactionEnd(db.CompactionInfo{
+			JobID: jobID,
+			Err:   err,
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+	d.mu.versions.metrics.Compact.Count++
+	d.updateReadStateLocked()
+	d.deleteObsoleteFiles(jobID)
+	return nil
+}
+
+// compactionFilter returns the function used by compactionIter to apply the
+// configured compaction filter to the entries written to the specified level,
+// or nil if there is no compaction filter. In TTL mode, the returned function
+// also removes expired entries.
+func (d *DB) compactionFilter(
+	level int,
+) func(key []byte, kind db.InternalKeyKind, value []byte) (db.CompactionFilterDecision, []byte) {
+	filter := d.opts.CompactionFilter
+	if d.opts.EnableTTL {
+		now := d.ttlNow()
+		return func(key []byte, kind db.InternalKeyKind, value []byte) (db.CompactionFilterDecision, []byte) {
+			return ttlFilter(filter, now, level, key, kind, value)
+		}
+	}
+	if filter == nil {
+		return nil
+	}
+	return func(key []byte, kind db.InternalKeyKind, value []byte) (db.CompactionFilterDecision, []byte) {
+		return filter.Filter(level, key, kind, value)
+	}
+}
+
 // compactDiskTables runs a compaction that produces new on-disk tables from
 // old on-disk tables.
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs []uint64, retErr error) {
-	// Check for a trivial move of one table from one level to the next. We avoid
-	// such a move if there is lots of overlapping grandparent data. Otherwise,
-	// the move could create a parent file that will require a very expensive
-	// merge later on.
-	if len(c.inputs[0]) == 1 && len(c.inputs[1]) == 0 &&
-		totalSize(c.grandparents) <= maxGrandparentOverlapBytes(d.opts, c.level+1) {
+	// Check for a trivial move of one table from one level to the next.
+	if c.trivialMove() {
 		meta := &c.inputs[0][0]
 		return &versionEdit{
 			deletedFiles: map[deletedFileEntry]bool{
 				deletedFileEntry{level: c.level, fileNum: meta.fileNum}: true,
 			},
 			newFiles: []newFileEntry{
-				{level: c.level + 1, meta: *meta},
+				{level: c.outputLevel, meta: *meta},
 			},
 		}, nil, nil
 	}
@@ -817,35 +1319,99 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 	defer d.mu.Lock()
 
 	c.cmp = d.cmp
+	allowZeroSeqNum := c.allowZeroSeqNum()
+
+	// Split the compaction into sub-compactions covering disjoint key ranges.
+	// Each sub-compaction operates on a copy of the compaction so that the
+	// state used to determine output table boundaries is not shared.
+	bounds := c.subCompactionBounds(d.opts.MaxSubCompactions)
+	subs := make([]compaction, len(bounds)+1)
+	for i := range subs {
+		subs[i] = *c
+		if i > 0 {
+			subs[i].lower = bounds[i-1]
+		}
+		if i < len(bounds) {
+			subs[i].upper = bounds[i]
+		}
+	}
+
+	type subResult struct {
+		newFiles       []newFileEntry
+		pendingOutputs []uint64
+		err            error
+	}
+	results := make([]subResult, len(subs))
+	if len(subs) == 1 {
+		r := &results[0]
+		r.newFiles, r.pendingOutputs, r.err = d.runSubCompaction(&subs[0], snapshots, allowZeroSeqNum)
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(subs))
+		for i := range subs {
+			go func(i int) {
+				defer wg.Done()
+				r := &results[i]
+				r.newFiles, r.pendingOutputs, r.err = d.runSubCompaction(&subs[i], snapshots, allowZeroSeqNum)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	// The outputs of the sub-compactions are combined, in key order, into a
+	// single version edit so that the compaction is applied atomically.
+	ve = &versionEdit{
+		deletedFiles: map[deletedFileEntry]bool{},
+	}
+	for i := range results {
+		r := &results[i]
+		pendingOutputs = append(pendingOutputs, r.pendingOutputs...)
+		retErr = firstError(retErr, r.err)
+		ve.newFiles = append(ve.newFiles, r.newFiles...)
+		c.deletionHints = append(c.deletionHints, subs[i].deletionHints...)
+	}
+	if retErr != nil {
+		for _, fileNum := range pendingOutputs {
+			d.opts.VFS.Remove(dbFilename(d.dirname, fileTypeTable, fileNum))
+		}
+		c.deletionHints = nil
+		return nil, pendingOutputs, retErr
+	}
+
+	for i := range c.inputs {
+		for _, f := range c.inputs[i] {
+			ve.deletedFiles[deletedFileEntry{
+				level:   c.inputLevel(i),
+				fileNum: f.fileNum,
+			}] = true
+		}
+	}
+	return ve, pendingOutputs, nil
+}
+
+// runSubCompaction compacts the entries of the compaction inputs that fall
+// within the bounds of c, returning the output tables and the file numbers
+// allocated for them. The output tables are not removed if an error occurs;
+// that is left to the caller. d.mu must not be held when calling this.
+func (d *DB) runSubCompaction(
+	c *compaction, snapshots []uint64, allowZeroSeqNum bool,
+) (newFiles []newFileEntry, pendingOutputs []uint64, retErr error) {
 	iiter, err := c.newInputIter(d.newIters)
 	if err != nil {
-		return nil, pendingOutputs, err
+		return nil, nil, err
 	}
 	iter := newCompactionIter(d.cmp, d.merge, iiter, snapshots,
-		c.allowZeroSeqNum(), c.elideTombstone, c.elideRangeTombstone)
+		allowZeroSeqNum, c.elideTombstone, c.elideRangeTombstone,
+		d.compactionFilter(c.outputLevel))
 
-	var (
-		filenames []string
-		tw        *sstable.Writer
-	)
+	var tw *sstable.Writer
 	defer func() {
-		if iter != nil {
-			retErr = firstError(retErr, iter.Close())
-		}
+		retErr = firstError(retErr, iter.Close())
 		if tw != nil {
 			retErr = firstError(retErr, tw.Close())
 		}
-		if retErr != nil {
-			for _, filename := range filenames {
-				d.opts.VFS.Remove(filename)
-			}
-		}
 	}()
 
-	ve = &versionEdit{
-		deletedFiles: map[deletedFileEntry]bool{},
-	}
-
 	newOutput := func() error {
 		d.mu.Lock()
 		fileNum := d.mu.versions.nextFileNum()
@@ -858,11 +1424,11 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 		if err != nil {
 			return err
 		}
-		filenames = append(filenames, filename)
-		tw = sstable.NewWriter(file, d.opts, d.opts.Level(c.level+1))
+		file = newRateLimitedFile(file, d.opts.RateLimiter, db.IOPriorityLow)
+		tw = sstable.NewWriter(file, d.opts, d.opts.Level(c.outputLevel))
 
-		ve.newFiles = append(ve.newFiles, newFileEntry{
-			level: c.level + 1,
+		newFiles = append(newFiles, newFileEntry{
+			level: c.outputLevel,
 			meta: fileMetadata{
 				fileNum: fileNum,
 			},
@@ -871,14 +1437,22 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 	}
 
 	finishOutput := func(key db.InternalKey) error {
-		if tw == nil {
-			return nil
-		}
-
 		// NB: clone the key because the data can be held on to by the call to
 		// compactionIter.Tombstones via rangedel.Fragmenter.FlushTo.
 		key = key.Clone()
-		for _, v := range iter.Tombstones(key.UserKey) {
+		tombstones := iter.Tombstones(key.UserKey)
+		if tw == nil {
+			// The range tombstones must be written out even if there are no
+			// point entries in the output, as would be the case for a
+			// sub-compaction covering only part of a tombstone.
+			if len(tombstones) == 0 {
+				return nil
+			}
+			if err := newOutput(); err != nil {
+				return err
+			}
+		}
+		for _, v := range tombstones {
 			if err := tw.Add(v.Start, v.End); err != nil {
 				return err
 			}
@@ -894,16 +1468,17 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 			return err
 		}
 		tw = nil
-		meta := &ve.newFiles[len(ve.newFiles)-1].meta
+		meta := &newFiles[len(newFiles)-1].meta
 		meta.size = writerMeta.Size
 		meta.smallestSeqNum = writerMeta.SmallestSeqNum
 		meta.largestSeqNum = writerMeta.LargestSeqNum
+		meta.creationTime = writerMeta.CreationTime
 
 		// The handling of range boundaries is a bit complicated.
-		if n := len(ve.newFiles); n > 1 {
+		if n := len(newFiles); n > 1 {
 			// This is not the first output. Bound the smallest range key by the
 			// previous tables largest key.
-			prevMeta := &ve.newFiles[n-2].meta
+			prevMeta := &newFiles[n-2].meta
 			if writerMeta.SmallestRange.UserKey != nil &&
 				d.cmp(writerMeta.SmallestRange.UserKey, prevMeta.largest.UserKey) <= 0 {
 				// The range boundary user key is less than or equal to the previous
@@ -933,6 +1508,9 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 		meta.smallest = writerMeta.Smallest(d.cmp)
 		meta.largest = writerMeta.Largest(d.cmp)
 
+		c.deletionHints = append(c.deletionHints,
+			makeDeletionHints(d.cmp, c.outputLevel, meta, tombstones,
+				writerMeta.LargestPoint.UserKey == nil)...)
 		return nil
 	}
 
@@ -941,34 +1519,25 @@ func (d *DB) compactDiskTables(c *compaction) (ve *versionEdit, pendingOutputs [
 		// shouldStopBefore decision.
 		if tw != nil && (tw.EstimatedSize() >= c.maxOutputFileSize || c.shouldStopBefore(*key)) {
 			if err := finishOutput(*key); err != nil {
-				return nil, pendingOutputs, err
+				return newFiles, pendingOutputs, err
 			}
 		}
 
 		if tw == nil {
 			if err := newOutput(); err != nil {
-				return nil, pendingOutputs, err
+				return newFiles, pendingOutputs, err
 			}
 		}
 
 		if err := tw.Add(*key, val); err != nil {
-			return nil, pendingOutputs, err
+			return newFiles, pendingOutputs, err
 		}
 	}
 
 	if err := finishOutput(db.InternalKey{}); err != nil {
-		return nil, pendingOutputs, nil
+		return newFiles, pendingOutputs, err
 	}
-
-	for i := range c.inputs {
-		for _, f := range c.inputs[i] {
-			ve.deletedFiles[deletedFileEntry{
-				level:   c.level + i,
-				fileNum: f.fileNum,
-			}] = true
-		}
-	}
-	return ve, pendingOutputs, nil
+	return newFiles, pendingOutputs, nil
 }
 
 // scanObsoleteFiles scans the filesystem for files that are no longer needed
@@ -1065,11 +1634,18 @@ func (d *DB) deleteObsoleteFiles(jobID int) {
 		d.mu.cleaner.cond.Signal()
 	}()
 
+	// NB: d.mu.versions.logNumber is the file number of the latest log that
+	// has had its contents persisted to the LSM. The logs being copied by a
+	// checkpoint are kept as well.
+	minLogNumber := d.mu.versions.logNumber
+	for _, fileNum := range d.mu.log.pinned {
+		if minLogNumber > fileNum {
+			minLogNumber = fileNum
+		}
+	}
 	var obsoleteLogs []uint64
 	for i := range d.mu.log.queue {
-		// NB: d.mu.versions.logNumber is the file number of the latest log that
-		// has had its contents persisted to the LSM.
-		if d.mu.log.queue[i] >= d.mu.versions.logNumber {
+		if d.mu.log.queue[i] >= minLogNumber {
 			obsoleteLogs = d.mu.log.queue[:i]
 			d.mu.log.queue = d.mu.log.queue[i:]
 			break
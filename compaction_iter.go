@@ -55,6 +55,12 @@ import (
 // a.MERGE.3 and a.SET.2 produced a.MERGE.3, a subsequent compaction with
 // a.MERGE.1 would merge the values together incorrectly.
 //
+// An entry which is deleted by a SINGLEDEL is expected to have been set
+// exactly once. When a SINGLEDEL meets the SET beneath it within a snapshot
+// stripe, both entries are elided. If the SINGLEDEL instead meets a DELETE or
+// MERGE, it is converted into a DELETE so that it continues to shadow the
+// older entries.
+//
 // 3. Snapshots
 //
 // Snapshots are lightweight point-in-time views of the DB state. At its core,
@@ -158,6 +164,8 @@ type compactionIter struct {
 	allowZeroSeqNum     bool
 	elideTombstone      func(key []byte) bool
 	elideRangeTombstone func(start, end []byte) bool
+	// The compaction filter, if any. See db.CompactionFilter.
+	filter func(key []byte, kind db.InternalKeyKind, value []byte) (db.CompactionFilterDecision, []byte)
 }
 
 func newCompactionIter(
@@ -168,6 +176,7 @@ func newCompactionIter(
 	allowZeroSeqNum bool,
 	elideTombstone func(key []byte) bool,
 	elideRangeTombstone func(start, end []byte) bool,
+	filter func(key []byte, kind db.InternalKeyKind, value []byte) (db.CompactionFilterDecision, []byte),
 ) *compactionIter {
 	i := &compactionIter{
 		cmp:                 cmp,
@@ -177,6 +186,7 @@ func newCompactionIter(
 		allowZeroSeqNum:     allowZeroSeqNum,
 		elideTombstone:      elideTombstone,
 		elideRangeTombstone: elideRangeTombstone,
+		filter:              filter,
 	}
 	i.rangeDelFrag.Cmp = cmp
 	i.rangeDelFrag.Emit = i.emitRangeDelChunk
@@ -223,6 +233,23 @@ func (i *compactionIter) Next() (*db.InternalKey, []byte) {
 			i.skip = true
 			return &i.key, i.value
 
+		case db.InternalKeyKindSingleDelete:
+			// If we're at the last snapshot stripe and the tombstone can be elided
+			// skip to the next stripe (which will be the next user key).
+			if i.curSnapshotIdx == 0 && i.elideTombstone(i.key.UserKey) {
+				i.saveKey()
+				i.skipStripe()
+				continue
+			}
+
+			if i.singleDeleteNext() {
+				return &i.key, i.value
+			}
+			if i.err != nil {
+				return nil, nil
+			}
+			continue
+
 		case db.InternalKeyKindRangeDelete:
 			i.key = i.cloneKey(i.key)
 			i.rangeDelFrag.Add(i.key, i.iterValue)
@@ -240,7 +267,14 @@ func (i *compactionIter) Next() (*db.InternalKey, []byte) {
 			i.value = i.iterValue
 			i.valid = true
 			i.skip = true
-			i.maybeZeroSeqnum()
+			if i.filterable() && !i.filterEntry() {
+				i.skip = false
+				i.skipStripe()
+				continue
+			}
+			if i.key.Kind() == db.InternalKeyKindSet {
+				i.maybeZeroSeqnum()
+			}
 			return &i.key, i.value
 
 		case db.InternalKeyKindMerge:
@@ -250,12 +284,24 @@ func (i *compactionIter) Next() (*db.InternalKey, []byte) {
 				continue
 			}
 
-			// NB: it is important to call maybeZeroSeqnum before mergeNext as
-			// merging advances the iterator, adjusting curSnapshotIdx and thus
-			// invalidating the state that maybeZeroSeqnum uses to make its
+			// NB: it is important to call maybeZeroSeqnum and filterable before
+			// mergeNext as merging advances the iterator, adjusting curSnapshotIdx
+			// and thus invalidating the state that they use to make their
 			// determination.
 			i.maybeZeroSeqnum()
-			return i.mergeNext()
+			filterable := i.filterable()
+			key, value := i.mergeNext()
+			if key == nil || !filterable {
+				return key, value
+			}
+			if !i.filterEntry() {
+				if i.skip {
+					i.skip = false
+					i.skipStripe()
+				}
+				continue
+			}
+			return &i.key, i.value
 
 		case db.InternalKeyKindInvalid:
 			// NB: Invalid keys occur when there is some error parsing the key. Pass
@@ -275,6 +321,34 @@ func (i *compactionIter) Next() (*db.InternalKey, []byte) {
 	return nil, nil
 }
 
+// filterable returns true if the compaction filter should be applied to the
+// current entry. The filter is only applied to entries in the newest snapshot
+// stripe, which are not visible to any snapshot, so that snapshot reads are
+// unaffected by the filter.
+func (i *compactionIter) filterable() bool {
+	return i.filter != nil && i.curSnapshotIdx == len(i.snapshots)
+}
+
+// filterEntry applies the compaction filter to the current entry. A removed
+// entry is converted into a deletion tombstone so that it continues to shadow
+// older entries for the key, unless the tombstone can be elided. Returns false
+// if the entry should not be output.
+func (i *compactionIter) filterEntry() bool {
+	decision, newValue := i.filter(i.key.UserKey, i.key.Kind(), i.value)
+	switch decision {
+	case db.CompactionFilterRemove:
+		if len(i.snapshots) == 0 && i.elideTombstone(i.key.UserKey) {
+			i.valid = false
+			return false
+		}
+		i.key.SetKind(db.InternalKeyKindDelete)
+		i.value = nil
+	case db.CompactionFilterChangeValue:
+		i.value = newValue
+	}
+	return true
+}
+
 // snapshotIndex returns the index of the first sequence number in snapshots
 // which is greater than or equal to seq.
 func snapshotIndex(seq uint64, snapshots []uint64) (int, uint64) {
@@ -339,7 +413,7 @@ func (i *compactionIter) mergeNext() (*db.InternalKey, []byte) {
 		}
 		key := i.iterKey
 		switch key.Kind() {
-		case db.InternalKeyKindDelete:
+		case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 			// We've hit a deletion tombstone. Return everything up to this point and
 			// then skip entries until the next snapshot stripe.
 			i.valueBuf = i.value[:0]
@@ -385,6 +459,53 @@ func (i *compactionIter) mergeNext() (*db.InternalKey, []byte) {
 	}
 }
 
+// singleDeleteNext processes a SINGLEDEL, looking at the next entry in the
+// current snapshot stripe. If that entry is a SET, both the SINGLEDEL and the
+// SET are elided and false is returned. Otherwise the SINGLEDEL (possibly
+// converted into a DELETE) is output and true is returned.
+func (i *compactionIter) singleDeleteNext() bool {
+	// Save the current key.
+	i.saveKey()
+	i.value = i.iterValue
+	i.valid = true
+
+	for {
+		if !i.nextInStripe() {
+			// There are no more entries in the stripe: output the SINGLEDEL so that
+			// it can meet its SET in a later compaction.
+			i.skip = false
+			return true
+		}
+
+		key := i.iterKey
+		switch key.Kind() {
+		case db.InternalKeyKindDelete, db.InternalKeyKindMerge:
+			// We've hit a DELETE or MERGE. Transform the SINGLEDEL into a full
+			// DELETE and skip the remainder of the stripe.
+			i.key.SetKind(db.InternalKeyKindDelete)
+			i.skip = true
+			return true
+
+		case db.InternalKeyKindSet:
+			// We've hit the SET deleted by the SINGLEDEL. Elide both entries and
+			// resume processing with the entry following the SET.
+			i.nextInStripe()
+			i.valid = false
+			return false
+
+		case db.InternalKeyKindSingleDelete, db.InternalKeyKindRangeDelete:
+			// Consecutive SINGLEDELs collapse into the newer one. Range tombstones
+			// have already been added to the fragmenter by nextInStripe.
+			continue
+
+		default:
+			i.err = fmt.Errorf("invalid internal key kind: %d", i.iterKey.Kind())
+			i.valid = false
+			return false
+		}
+	}
+}
+
 func (i *compactionIter) saveKey() {
 	i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
 	i.key.UserKey = i.keyBuf
@@ -51,6 +51,21 @@ func TestCompactionIter(t *testing.T) {
 	var vals [][]byte
 	var snapshots []uint64
 	var elideTombstones bool
+	var filter func([]byte, db.InternalKeyKind, []byte) (db.CompactionFilterDecision, []byte)
+
+	// The test filter removes entries with the value "drop" and changes the
+	// value "old" to "new".
+	testFilter := func(
+		key []byte, kind db.InternalKeyKind, value []byte,
+	) (db.CompactionFilterDecision, []byte) {
+		switch string(value) {
+		case "drop":
+			return db.CompactionFilterRemove, nil
+		case "old":
+			return db.CompactionFilterChangeValue, []byte("new")
+		}
+		return db.CompactionFilterKeep, nil
+	}
 
 	newIter := func() *compactionIter {
 		return newCompactionIter(
@@ -65,6 +80,7 @@ func TestCompactionIter(t *testing.T) {
 			func(_, _ []byte) bool {
 				return elideTombstones
 			},
+			filter,
 		)
 	}
 
@@ -83,6 +99,7 @@ func TestCompactionIter(t *testing.T) {
 		case "iter":
 			snapshots = snapshots[:0]
 			elideTombstones = false
+			filter = nil
 			for _, arg := range d.CmdArgs {
 				switch arg.Key {
 				case "snapshots":
@@ -99,6 +116,14 @@ func TestCompactionIter(t *testing.T) {
 					if err != nil {
 						return err.Error()
 					}
+				case "filter":
+					enabled, err := strconv.ParseBool(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
+					if enabled {
+						filter = testFilter
+					}
 				default:
 					return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
 				}
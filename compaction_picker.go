@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"math"
+	"sort"
 
 	"github.com/petermattis/pebble/db"
 )
@@ -24,12 +25,21 @@ type compactionPicker struct {
 	// level.
 	levelMaxBytes [numLevels]int64
 
+	// scores holds the compaction score for each level. The score for the last
+	// level is always 0 as the last level is never compacted into another
+	// level.
+	scores [numLevels]float64
+
 	// These fields are the level that should be compacted next and its
 	// compaction score. A score < 1 means that compaction is not strictly
 	// needed.
 	score float64
 	level int
 	file  int
+
+	// compactionDebt is the estimated number of bytes which need to be
+	// compacted before every level is within its levelMaxBytes.
+	compactionDebt uint64
 }
 
 func newCompactionPicker(v *version, opts *db.Options) *compactionPicker {
@@ -38,6 +48,7 @@ func newCompactionPicker(v *version, opts *db.Options) *compactionPicker {
 	}
 	p.initLevelMaxBytes(v, opts)
 	p.initTarget(v, opts)
+	p.initCompactionDebt(v, opts)
 	return p
 }
 
@@ -146,6 +157,45 @@ func (p *compactionPicker) initLevelMaxBytes(v *version, opts *db.Options) {
 	}
 }
 
+// initCompactionDebt estimates the number of bytes which need to be compacted
+// before every level is within its levelMaxBytes.
+func (p *compactionPicker) initCompactionDebt(v *version, opts *db.Options) {
+	switch opts.CompactionStyle {
+	case db.CompactionStyleUniversal:
+		p.compactionDebt = universalCompactionDebt(v, opts)
+		return
+	case db.CompactionStyleFIFO:
+		// The tables are never compacted.
+		return
+	}
+
+	// Every L0->Lbase compaction of roughly L0CompactionThreshold memtables
+	// worth of L0 tables rewrites the base level.
+	l0Size := totalSize(v.files[0])
+	debt := l0Size
+	if l0Size > 0 {
+		l0CompactionSize := float64(opts.L0CompactionThreshold * opts.MemTableSize)
+		baseSize := totalSize(v.files[p.baseLevel])
+		debt += uint64(float64(baseSize) * float64(l0Size) / l0CompactionSize)
+	}
+
+	// The bytes compacted into a level may push it over its maximum size. The
+	// excess bytes are compacted into the next level, rewriting the overlapping
+	// bytes in that level, which are estimated from the ratio of the sizes of
+	// the levels.
+	added := l0Size
+	for level := p.baseLevel; level < numLevels-1; level++ {
+		levelSize := totalSize(v.files[level]) + added
+		added = 0
+		if maxBytes := uint64(p.levelMaxBytes[level]); levelSize > maxBytes {
+			added = levelSize - maxBytes
+			nextSize := totalSize(v.files[level+1])
+			debt += uint64(float64(added) * (float64(nextSize)/float64(levelSize) + 1))
+		}
+	}
+	p.compactionDebt = debt
+}
+
 // initTarget initializes the compaction score and level. If the compaction
 // score indicates compaction is needed, a target table within the target level
 // is selected for compaction.
@@ -160,11 +210,13 @@ func (p *compactionPicker) initTarget(v *version, opts *db.Options) {
 	// wish to avoid too many files when the individual file size is small
 	// (perhaps because of a small write-buffer setting, or very high
 	// compression ratios, or lots of overwrites/deletions).
-	p.score = float64(len(v.files[0])) / float64(opts.L0CompactionThreshold)
+	p.scores[0] = float64(len(v.files[0])) / float64(opts.L0CompactionThreshold)
+	p.score = p.scores[0]
 	p.level = 0
 
 	for level := 1; level < numLevels-1; level++ {
 		score := float64(totalSize(v.files[level])) / float64(p.levelMaxBytes[level])
+		p.scores[level] = score
 		if p.score < score {
 			p.score = score
 			p.level = level
@@ -225,15 +277,65 @@ func (p *compactionPicker) initTarget(v *version, opts *db.Options) {
 	// snapshot.
 }
 
-// pickAuto picks the best compaction, if any.
-func (p *compactionPicker) pickAuto(opts *db.Options) (c *compaction) {
+// pickAuto picks the best compaction, if any, which does not conflict with the
+// compactions in progress.
+func (p *compactionPicker) pickAuto(
+	opts *db.Options, inProgress *compactionsInProgress,
+) (c *compaction) {
+	switch opts.CompactionStyle {
+	case db.CompactionStyleUniversal:
+		return p.pickUniversal(opts, inProgress)
+	case db.CompactionStyleFIFO:
+		// The FIFO compaction style never merges sstables. See pickFIFO.
+		return nil
+	}
 	if !p.compactionNeeded() {
 		return nil
 	}
 
+	cmp := opts.Comparer.Compare
+	if c = p.pickFile(opts, p.level, p.file); !inProgress.conflicts(cmp, c) {
+		return c
+	}
+
+	// The best compaction conflicts with a compaction in progress. Consider the
+	// other tables in the levels which need compaction, in order of decreasing
+	// level score and increasing table age.
+	var levels []int
+	for level := 0; level < numLevels-1; level++ {
+		if p.scores[level] >= 1 {
+			levels = append(levels, level)
+		}
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		return p.scores[levels[i]] > p.scores[levels[j]]
+	})
+	for _, level := range levels {
+		files := p.vers.files[level]
+		order := make([]int, len(files))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return files[order[i]].smallestSeqNum < files[order[j]].smallestSeqNum
+		})
+		for _, i := range order {
+			if level == p.level && i == p.file {
+				continue
+			}
+			if c = p.pickFile(opts, level, i); !inProgress.conflicts(cmp, c) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// pickFile returns a compaction of the specified table in the specified level.
+func (p *compactionPicker) pickFile(opts *db.Options, level, file int) (c *compaction) {
 	vers := p.vers
-	c = newCompaction(opts, vers, p.level)
-	c.inputs[0] = vers.files[c.level][p.file : p.file+1]
+	c = newCompaction(opts, vers, level)
+	c.inputs[0] = vers.files[c.level][file : file+1]
 
 	// Files in level 0 may overlap each other, so pick up all overlapping ones.
 	if c.level == 0 {
@@ -250,7 +352,7 @@ func (p *compactionPicker) pickAuto(opts *db.Options) (c *compaction) {
 }
 
 func (p *compactionPicker) pickManual(opts *db.Options, manual *manualCompaction) (c *compaction) {
-	if p == nil {
+	if p == nil || opts.CompactionStyle == db.CompactionStyleFIFO {
 		return nil
 	}
 
@@ -265,3 +367,84 @@ func (p *compactionPicker) pickManual(opts *db.Options, manual *manualCompaction
 	c.setupOtherInputs()
 	return c
 }
+
+// compactionsInProgress tracks the compactions which are running, so that
+// the compactions started concurrently with them do not conflict. Two
+// compactions conflict if they share an input table, or if they write to the
+// same level and their key ranges overlap.
+type compactionsInProgress struct {
+	compactions map[*compaction]struct{}
+	// files holds the file numbers of the input tables of the compactions in
+	// progress, along with the tables being deleted by delete-only compactions
+	// and DB.DeleteFilesInRange.
+	files map[uint64]struct{}
+}
+
+func (p *compactionsInProgress) add(c *compaction) {
+	if p.compactions == nil {
+		p.compactions = make(map[*compaction]struct{})
+	}
+	p.compactions[c] = struct{}{}
+	for i := range c.inputs {
+		for j := range c.inputs[i] {
+			p.addFile(c.inputs[i][j].fileNum)
+		}
+	}
+}
+
+func (p *compactionsInProgress) remove(c *compaction) {
+	delete(p.compactions, c)
+	for i := range c.inputs {
+		for j := range c.inputs[i] {
+			p.removeFile(c.inputs[i][j].fileNum)
+		}
+	}
+}
+
+func (p *compactionsInProgress) addFile(fileNum uint64) {
+	if p.files == nil {
+		p.files = make(map[uint64]struct{})
+	}
+	p.files[fileNum] = struct{}{}
+}
+
+func (p *compactionsInProgress) removeFile(fileNum uint64) {
+	delete(p.files, fileNum)
+}
+
+// busy returns true if the specified table is in use by a compaction in
+// progress.
+func (p *compactionsInProgress) busy(fileNum uint64) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.files[fileNum]
+	return ok
+}
+
+// conflicts returns true if c conflicts with any of the compactions in
+// progress.
+func (p *compactionsInProgress) conflicts(cmp db.Compare, c *compaction) bool {
+	if p == nil {
+		return false
+	}
+	for i := range c.inputs {
+		for j := range c.inputs[i] {
+			if p.busy(c.inputs[i][j].fileNum) {
+				return true
+			}
+		}
+	}
+	smallest, largest := ikeyRange(cmp, c.inputs[0], c.inputs[1])
+	for o := range p.compactions {
+		if o.outputLevel != c.outputLevel {
+			continue
+		}
+		oSmallest, oLargest := ikeyRange(cmp, o.inputs[0], o.inputs[1])
+		if cmp(largest.UserKey, oSmallest.UserKey) >= 0 &&
+			cmp(oLargest.UserKey, smallest.UserKey) >= 0 {
+			return true
+		}
+	}
+	return false
+}
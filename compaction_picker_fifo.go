@@ -0,0 +1,50 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/petermattis/pebble/db"
+
+// pickFIFO picks the L0 tables to delete for the FIFO compaction style, if
+// any, returning a version edit which deletes them. The current time is
+// specified in seconds since the Unix epoch.
+//
+// The L0 tables are deleted from oldest to newest while their total size
+// exceeds FIFOCompactionOptions.MaxTableFilesSize or the oldest table has
+// outlived FIFOCompactionOptions.TTL. Tables with an unknown creation time
+// never expire. Tables which are already being deleted by a compaction in
+// progress are skipped, and do not count towards the total size.
+func (p *compactionPicker) pickFIFO(
+	opts *db.Options, now uint64, inProgress *compactionsInProgress,
+) *versionEdit {
+	files := p.vers.files[0]
+	var size uint64
+	for i := range files {
+		if !inProgress.busy(files[i].fileNum) {
+			size += files[i].size
+		}
+	}
+
+	fopts := &opts.FIFOCompaction
+	ttl := uint64(fopts.TTL.Seconds())
+	var ve *versionEdit
+	for i := range files {
+		f := &files[i]
+		if inProgress.busy(f.fileNum) {
+			continue
+		}
+		expired := ttl > 0 && f.creationTime != 0 && f.creationTime+ttl <= now
+		if !expired && size <= uint64(fopts.MaxTableFilesSize) {
+			break
+		}
+		if ve == nil {
+			ve = &versionEdit{
+				deletedFiles: map[deletedFileEntry]bool{},
+			}
+		}
+		ve.deletedFiles[deletedFileEntry{level: 0, fileNum: f.fileNum}] = true
+		size -= f.size
+	}
+	return ve
+}
@@ -7,9 +7,11 @@ package pebble
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/petermattis/pebble/db"
 	"github.com/petermattis/pebble/internal/datadriven"
@@ -134,3 +136,189 @@ func TestCompactionPickerTargetLevel(t *testing.T) {
 			}
 		})
 }
+
+func TestCompactionPickerUniversal(t *testing.T) {
+	datadriven.RunTest(t, "testdata/compaction_picker_universal",
+		func(d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "pick":
+				opts := &db.Options{
+					CompactionStyle:       db.CompactionStyleUniversal,
+					L0CompactionThreshold: 4,
+				}
+				opts.EnsureDefaults()
+
+				// Every table spans the same key range. The sizes of the L0 tables are
+				// listed from oldest to newest.
+				vers := &version{}
+				var fileNum uint64
+				for _, data := range strings.Split(d.Input, "\n") {
+					parts := strings.Split(data, ":")
+					if len(parts) != 2 {
+						return fmt.Sprintf("malformed test:\n%s", d.Input)
+					}
+					level, err := strconv.Atoi(parts[0])
+					if err != nil {
+						return err.Error()
+					}
+					for _, field := range strings.Fields(parts[1]) {
+						size, err := strconv.ParseUint(field, 10, 64)
+						if err != nil {
+							return err.Error()
+						}
+						fileNum++
+						vers.files[level] = append(vers.files[level], fileMetadata{
+							fileNum:        fileNum,
+							size:           size,
+							smallest:       db.ParseInternalKey(fmt.Sprintf("a.SET.%d", fileNum)),
+							largest:        db.ParseInternalKey(fmt.Sprintf("z.SET.%d", fileNum)),
+							smallestSeqNum: fileNum,
+							largestSeqNum:  fileNum,
+						})
+					}
+				}
+
+				p := newCompactionPicker(vers, opts)
+				c := p.pickAuto(opts, nil)
+				if c == nil {
+					return "no compaction"
+				}
+				var buf bytes.Buffer
+				fmt.Fprintf(&buf, "%d -> %d\n", c.level, c.outputLevel)
+				for i := range c.inputs {
+					if len(c.inputs[i]) == 0 {
+						continue
+					}
+					fmt.Fprintf(&buf, "%d:", c.inputLevel(i))
+					for _, f := range c.inputs[i] {
+						fmt.Fprintf(&buf, " %d", f.fileNum)
+					}
+					fmt.Fprintf(&buf, "\n")
+				}
+				return buf.String()
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
+
+func TestCompactionPickerFIFO(t *testing.T) {
+	datadriven.RunTest(t, "testdata/compaction_picker_fifo",
+		func(d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "pick":
+				opts := &db.Options{
+					CompactionStyle: db.CompactionStyleFIFO,
+				}
+				var now uint64
+				var inProgress compactionsInProgress
+				for _, arg := range d.CmdArgs {
+					if len(arg.Vals) == 0 {
+						return fmt.Sprintf("%s: %s expects a value", d.Cmd, arg.Key)
+					}
+					var err error
+					switch arg.Key {
+					case "max-size":
+						opts.FIFOCompaction.MaxTableFilesSize, err = strconv.ParseInt(arg.Vals[0], 10, 64)
+					case "ttl":
+						opts.FIFOCompaction.TTL, err = time.ParseDuration(arg.Vals[0])
+					case "now":
+						now, err = strconv.ParseUint(arg.Vals[0], 10, 64)
+					case "busy":
+						for _, val := range arg.Vals {
+							var fileNum uint64
+							fileNum, err = strconv.ParseUint(val, 10, 64)
+							if err != nil {
+								break
+							}
+							inProgress.addFile(fileNum)
+						}
+					default:
+						return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
+					}
+					if err != nil {
+						return err.Error()
+					}
+				}
+				opts.EnsureDefaults()
+
+				// Each line holds the size and creation time of an L0 table, from
+				// oldest to newest.
+				vers := &version{}
+				for i, data := range strings.Split(d.Input, "\n") {
+					var size, creationTime uint64
+					if _, err := fmt.Sscan(data, &size, &creationTime); err != nil {
+						return fmt.Sprintf("malformed test:\n%s", d.Input)
+					}
+					fileNum := uint64(i + 1)
+					vers.files[0] = append(vers.files[0], fileMetadata{
+						fileNum:        fileNum,
+						size:           size,
+						creationTime:   creationTime,
+						smallestSeqNum: fileNum,
+						largestSeqNum:  fileNum,
+					})
+				}
+
+				p := newCompactionPicker(vers, opts)
+				ve := p.pickFIFO(opts, now, &inProgress)
+				if ve == nil {
+					return "no compaction"
+				}
+				var fileNums []uint64
+				for e := range ve.deletedFiles {
+					fileNums = append(fileNums, e.fileNum)
+				}
+				sort.Slice(fileNums, func(i, j int) bool {
+					return fileNums[i] < fileNums[j]
+				})
+				return fmt.Sprintf("deleted: %v\n", fileNums)
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
+
+func TestCompactionPickerDebt(t *testing.T) {
+	testCases := []struct {
+		style    db.CompactionStyle
+		sizes    [numLevels]uint64
+		expected uint64
+	}{
+		{db.CompactionStyleLevel, [numLevels]uint64{}, 0},
+		// L0 is compacted into L5, which is empty.
+		{db.CompactionStyleLevel, [numLevels]uint64{0: 200, 6: 4000}, 200},
+		// Each compaction of 400 bytes of L0 rewrites the 1000 bytes in L5, and
+		// pushes L5 over its maximum size of 1000 bytes. The excess 200 bytes are
+		// compacted into L6, rewriting 4000/1200ths of them in L6.
+		{db.CompactionStyleLevel, [numLevels]uint64{0: 200, 5: 1000, 6: 4000}, 200 + 500 + 866},
+		// L5 is over its maximum size, even without L0.
+		{db.CompactionStyleLevel, [numLevels]uint64{5: 1100, 6: 4000}, 463},
+		// The two sorted runs are fewer than L0CompactionThreshold, so they are
+		// not compacted.
+		{db.CompactionStyleUniversal, [numLevels]uint64{0: 200, 6: 4000}, 0},
+		// The tables in the intermediate levels are compacted down.
+		{db.CompactionStyleUniversal, [numLevels]uint64{0: 200, 5: 300, 6: 4000}, 300},
+		{db.CompactionStyleFIFO, [numLevels]uint64{0: 200}, 0},
+	}
+	for _, c := range testCases {
+		opts := (&db.Options{
+			CompactionStyle:       c.style,
+			L0CompactionThreshold: 4,
+			L1MaxBytes:            1000,
+			MemTableSize:          100,
+		}).EnsureDefaults()
+		vers := &version{}
+		for level, size := range c.sizes {
+			if size > 0 {
+				vers.files[level] = []fileMetadata{{size: size}}
+			}
+		}
+		p := newCompactionPicker(vers, opts)
+		if p.compactionDebt != c.expected {
+			t.Errorf("%s %v: expected %d, but found %d", c.style, c.sizes, c.expected, p.compactionDebt)
+		}
+	}
+}
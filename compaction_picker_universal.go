@@ -0,0 +1,199 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+
+	"github.com/petermattis/pebble/db"
+)
+
+// sortedRun is a sorted run in the universal compaction style: either a single
+// L0 table, or all of the tables in the bottom level.
+type sortedRun struct {
+	level int
+	files []fileMetadata
+	size  uint64
+}
+
+// universalSortedRuns returns the sorted runs in the version, ordered from
+// newest to oldest.
+func universalSortedRuns(v *version) []sortedRun {
+	files := v.files[0]
+	runs := make([]sortedRun, 0, len(files)+1)
+	// The L0 tables are ordered from oldest to newest.
+	for i := len(files) - 1; i >= 0; i-- {
+		runs = append(runs, sortedRun{
+			level: 0,
+			files: files[i : i+1],
+			size:  files[i].size,
+		})
+	}
+	if files := v.files[numLevels-1]; len(files) > 0 {
+		runs = append(runs, sortedRun{
+			level: numLevels - 1,
+			files: files,
+			size:  totalSize(files),
+		})
+	}
+	return runs
+}
+
+// pickUniversal picks a compaction for the universal compaction style, if
+// any, which does not conflict with the compactions in progress.
+//
+// Every L0 table is a sorted run, and the bottom level forms the oldest sorted
+// run. The intermediate levels are kept empty: any tables in them, such as
+// those left behind by the level compaction style, are compacted down one
+// level at a time. A compaction merges a contiguous sequence of sorted runs,
+// and is picked using the following heuristics, in order:
+//
+//  1. If the size of the sorted runs other than the oldest exceeds
+//     MaxSizeAmplificationPercent of the size of the oldest sorted run, all
+//     of the sorted runs are merged.
+//  2. The newest sequence of at least MinMergeWidth sorted runs where each
+//     sorted run is no larger than the total size of the newer sorted runs
+//     in the sequence (plus SizeRatio percent) is merged.
+//  3. The newest sorted runs are merged to bring the number of sorted runs
+//     below L0CompactionThreshold.
+//
+// A compaction which includes the oldest L0 table writes to the bottom level,
+// unless the bottom level is non-empty and not part of the compaction. All
+// other compactions write a single table to L0.
+func (p *compactionPicker) pickUniversal(
+	opts *db.Options, inProgress *compactionsInProgress,
+) *compaction {
+	vers := p.vers
+	cmp := opts.Comparer.Compare
+	for level := 1; level < numLevels-1; level++ {
+		if len(vers.files[level]) == 0 {
+			continue
+		}
+		c := newCompaction(opts, vers, level)
+		c.inputs[0] = vers.files[level]
+		smallest, largest := ikeyRange(cmp, c.inputs[0], nil)
+		c.inputs[1] = vers.overlaps(level+1, cmp, smallest.UserKey, largest.UserKey)
+		if inProgress.conflicts(cmp, c) {
+			return nil
+		}
+		return c
+	}
+
+	runs := universalSortedRuns(vers)
+	if len(runs) < opts.L0CompactionThreshold || len(runs) < 2 {
+		return nil
+	}
+	uopts := &opts.UniversalCompaction
+
+	var n int
+	if universalSizeAmplified(runs, uopts) {
+		n = len(runs)
+	}
+	var start int
+	for start = 0; n == 0 && start < len(runs); start++ {
+		sum := runs[start].size
+		count := 1
+		for i := start + 1; i < len(runs); i++ {
+			if sum*uint64(100+uopts.SizeRatio)/100 < runs[i].size {
+				break
+			}
+			sum += runs[i].size
+			count++
+		}
+		if count >= uopts.MinMergeWidth {
+			n = count
+			break
+		}
+	}
+	if n == 0 {
+		start = 0
+		n = universalRunCountMergeWidth(len(runs), opts)
+	}
+
+	c := p.newUniversalCompaction(opts, runs[start:start+n], start+n == len(runs))
+	if inProgress.conflicts(cmp, c) {
+		return nil
+	}
+	return c
+}
+
+// newUniversalCompaction returns a compaction of the specified sorted runs.
+// The oldest parameter indicates whether the runs include the oldest sorted
+// run.
+func (p *compactionPicker) newUniversalCompaction(
+	opts *db.Options, runs []sortedRun, oldest bool,
+) *compaction {
+	c := newCompaction(opts, p.vers, 0)
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].level == 0 {
+			// Keep the L0 tables ordered from oldest to newest.
+			c.inputs[0] = append(c.inputs[0], runs[i].files...)
+		}
+	}
+
+	if oldest {
+		// Only the tables in the bottom level which overlap the L0 tables need
+		// to be rewritten.
+		c.outputLevel = numLevels - 1
+		c.maxOutputFileSize = uint64(opts.Level(c.outputLevel).TargetFileSize)
+		cmp := opts.Comparer.Compare
+		smallest, largest := ikeyRange(cmp, c.inputs[0], nil)
+		c.inputs[1] = p.vers.overlaps(c.outputLevel, cmp, smallest.UserKey, largest.UserKey)
+	} else {
+		// The sorted runs are written to a single L0 table, which must not be
+		// split as the L0 tables are ordered by sequence number.
+		c.outputLevel = 0
+		c.maxOutputFileSize = math.MaxUint64
+	}
+	return c
+}
+
+// universalSizeAmplified returns true if the size of the sorted runs other
+// than the oldest exceeds MaxSizeAmplificationPercent of the size of the
+// oldest sorted run.
+func universalSizeAmplified(runs []sortedRun, uopts *db.UniversalCompactionOptions) bool {
+	oldest := runs[len(runs)-1].size
+	return oldest > 0 &&
+		(totalRunSize(runs)-oldest)*100 > oldest*uint64(uopts.MaxSizeAmplificationPercent)
+}
+
+// universalRunCountMergeWidth returns the number of newest sorted runs to merge
+// to bring the number of sorted runs below L0CompactionThreshold.
+func universalRunCountMergeWidth(runs int, opts *db.Options) int {
+	n := runs - opts.L0CompactionThreshold + 1
+	if n < 2 {
+		n = 2
+	}
+	return n
+}
+
+// universalCompactionDebt estimates the number of bytes which need to be
+// compacted before pickUniversal stops picking compactions. These are the
+// tables in the intermediate levels, and the sorted runs which are merged to
+// bring the size amplification or the number of sorted runs within its limit.
+// The sorted runs are not compacted while there are fewer of them than
+// L0CompactionThreshold, however large they are.
+func universalCompactionDebt(v *version, opts *db.Options) uint64 {
+	var debt uint64
+	for level := 1; level < numLevels-1; level++ {
+		debt += totalSize(v.files[level])
+	}
+	runs := universalSortedRuns(v)
+	if len(runs) < opts.L0CompactionThreshold || len(runs) < 2 {
+		return debt
+	}
+	if universalSizeAmplified(runs, &opts.UniversalCompaction) {
+		return debt + totalRunSize(runs)
+	}
+	return debt + totalRunSize(runs[:universalRunCountMergeWidth(len(runs), opts)])
+}
+
+func totalRunSize(runs []sortedRun) uint64 {
+	var size uint64
+	for i := range runs {
+		size += runs[i].size
+	}
+	return size
+}
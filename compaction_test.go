@@ -18,6 +18,7 @@ import (
 	"github.com/petermattis/pebble/internal/datadriven"
 	"github.com/petermattis/pebble/sstable"
 	"github.com/petermattis/pebble/vfs"
+	"golang.org/x/exp/rand"
 )
 
 func TestPickCompaction(t *testing.T) {
@@ -423,7 +424,7 @@ func TestPickCompaction(t *testing.T) {
 		vs.picker = &tc.picker
 		vs.picker.vers = &tc.version
 
-		c, got := vs.picker.pickAuto(opts), ""
+		c, got := vs.picker.pickAuto(opts, nil), ""
 		if c != nil {
 			got0 := fileNums(c.inputs[0])
 			got1 := fileNums(c.inputs[1])
@@ -436,6 +437,109 @@ func TestPickCompaction(t *testing.T) {
 	}
 }
 
+func TestPickCompactionInProgress(t *testing.T) {
+	opts := (*db.Options)(nil).EnsureDefaults()
+	vers := &version{
+		files: [numLevels][]fileMetadata{
+			1: []fileMetadata{
+				{
+					fileNum:        100,
+					size:           1,
+					smallest:       db.ParseInternalKey("a.SET.101"),
+					largest:        db.ParseInternalKey("c.SET.102"),
+					smallestSeqNum: 101,
+				},
+				{
+					fileNum:        110,
+					size:           1,
+					smallest:       db.ParseInternalKey("d.SET.111"),
+					largest:        db.ParseInternalKey("f.SET.112"),
+					smallestSeqNum: 111,
+				},
+			},
+			2: []fileMetadata{
+				{
+					fileNum:  200,
+					size:     1,
+					smallest: db.ParseInternalKey("a.SET.201"),
+					largest:  db.ParseInternalKey("c.SET.202"),
+				},
+				{
+					fileNum:  210,
+					size:     1,
+					smallest: db.ParseInternalKey("d.SET.211"),
+					largest:  db.ParseInternalKey("f.SET.212"),
+				},
+			},
+		},
+	}
+	picker := &compactionPicker{
+		vers:  vers,
+		score: 99,
+		level: 1,
+		file:  0,
+	}
+	picker.scores[1] = 99
+
+	inProgressCompaction := func(level int, fileNum uint64, smallest, largest string) *compaction {
+		c := &compaction{level: level, outputLevel: level + 1}
+		c.inputs[0] = []fileMetadata{{
+			fileNum:  fileNum,
+			smallest: db.ParseInternalKey(smallest),
+			largest:  db.ParseInternalKey(largest),
+		}}
+		return c
+	}
+
+	testCases := []struct {
+		desc       string
+		inProgress []*compaction
+		want       string
+	}{
+		{
+			desc: "no compactions in progress",
+			want: "100 200",
+		},
+		{
+			desc:       "shared input table",
+			inProgress: []*compaction{inProgressCompaction(2, 200, "a.SET.201", "c.SET.202")},
+			want:       "110 210",
+		},
+		{
+			desc:       "overlapping output key range",
+			inProgress: []*compaction{inProgressCompaction(1, 120, "b.SET.121", "b.SET.122")},
+			want:       "110 210",
+		},
+		{
+			desc:       "non-overlapping output key range",
+			inProgress: []*compaction{inProgressCompaction(1, 120, "g.SET.121", "h.SET.122")},
+			want:       "100 200",
+		},
+		{
+			desc: "all tables in use",
+			inProgress: []*compaction{
+				inProgressCompaction(1, 120, "b.SET.121", "b.SET.122"),
+				inProgressCompaction(1, 130, "e.SET.131", "e.SET.132"),
+			},
+			want: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		var inProgress compactionsInProgress
+		for _, c := range tc.inProgress {
+			inProgress.add(c)
+		}
+		c, got := picker.pickAuto(opts, &inProgress), ""
+		if c != nil {
+			got = fmt.Sprintf("%d %d", c.inputs[0][0].fileNum, c.inputs[1][0].fileNum)
+		}
+		if got != tc.want {
+			t.Fatalf("%s:\ngot  %q\nwant %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
 func TestIsBaseLevelForUkey(t *testing.T) {
 	testCases := []struct {
 		desc    string
@@ -560,9 +664,10 @@ func TestIsBaseLevelForUkey(t *testing.T) {
 
 	for _, tc := range testCases {
 		c := compaction{
-			cmp:     db.DefaultComparer.Compare,
-			version: &tc.version,
-			level:   tc.level,
+			cmp:         db.DefaultComparer.Compare,
+			version:     &tc.version,
+			level:       tc.level,
+			outputLevel: tc.level + 1,
 		}
 		for ukey, want := range tc.wants {
 			if got := c.elideTombstone([]byte(ukey)); got != want {
@@ -1024,6 +1129,7 @@ func TestCompactionAllowZeroSeqNum(t *testing.T) {
 						switch {
 						case c.level == -1:
 							c.level = level
+							c.outputLevel = level + 1
 						case c.level+1 == level:
 							i = 1
 						case c.level != level:
@@ -1041,3 +1147,541 @@ func TestCompactionAllowZeroSeqNum(t *testing.T) {
 			}
 		})
 }
+
+func TestDeleteOnlyCompaction(t *testing.T) {
+	var deleted []uint64
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+		EventListener: &db.EventListener{
+			TableDeleted: func(info db.TableDeleteInfo) {
+				deleted = append(deleted, info.FileNum)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Write each pair of keys to a separate sstable below L0.
+	for _, keys := range [][]string{{"a", "b"}, {"c", "d"}} {
+		for _, key := range keys {
+			if err := d.Set([]byte(key), []byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Compact([]byte(keys[0]), []byte(keys[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := func() string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		return strings.TrimSpace(d.mu.versions.currentVersion().DebugString())
+	}
+	before := files()
+	deleted = nil
+
+	// The range tombstone covers the sstable containing a-b, and part of the
+	// sstable containing c-d. Neither is deleted while the snapshot is open, as
+	// the snapshot can still see the keys.
+	snap := d.NewSnapshot()
+	if err := d.DeleteRange([]byte("a"), []byte("d"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	after := files()
+	if len(deleted) != 0 {
+		t.Fatalf("expected no sstables to be deleted, but found %d\n%s", deleted, after)
+	}
+	if !strings.HasPrefix(after, "0:") || !strings.HasSuffix(after, before) {
+		t.Fatalf("expected flushed sstable in L0 above\n%s\nbut found\n%s", before, after)
+	}
+
+	// Closing the snapshot allows the sstable containing a-b to be deleted
+	// without rewriting any sstables.
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after = files()
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 sstable to be deleted, but found %d\n%s", deleted, after)
+	}
+	if strings.Contains(after, "a#0,1-b#0,1") || !strings.Contains(after, "c#0,1-d#0,1") {
+		t.Fatalf("expected only the sstable containing a-b to be deleted\n%s", after)
+	}
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.Join(keys, " "); v != "d" {
+		t.Fatalf("expected d, but found %q", v)
+	}
+
+	// Deleting the remaining keys deletes the sstable containing c-d. Neither
+	// of the flushed sstables covers anything once it is gone, so both are
+	// deleted along with their hints.
+	deleted = nil
+	if err := d.DeleteRange([]byte("c"), []byte("e"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	after = files()
+	if len(deleted) != 3 || after != "" {
+		t.Fatalf("expected 3 sstables to be deleted, but found %d\n%s", deleted, after)
+	}
+	d.mu.Lock()
+	hints := len(d.mu.compact.deletionHints)
+	d.mu.Unlock()
+	if hints != 0 {
+		t.Fatalf("expected no deletion hints, but found %d", hints)
+	}
+}
+
+type testCompactionFilter struct {
+	levels []int
+}
+
+func (f *testCompactionFilter) Filter(
+	level int, key []byte, kind db.InternalKeyKind, value []byte,
+) (db.CompactionFilterDecision, []byte) {
+	f.levels = append(f.levels, level)
+	switch string(value) {
+	case "expired":
+		return db.CompactionFilterRemove, nil
+	case "v1":
+		return db.CompactionFilterChangeValue, []byte("v2")
+	}
+	return db.CompactionFilterKeep, nil
+}
+
+func TestCompactionFilter(t *testing.T) {
+	filter := &testCompactionFilter{}
+	d, err := Open("", &db.Options{
+		CompactionFilter: filter,
+		VFS:              vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	get := func(key string) string {
+		v, err := d.Get([]byte(key))
+		if err == db.ErrNotFound {
+			return "<not found>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+	set := func(key, value string) {
+		if err := d.Set([]byte(key), []byte(value), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The removed entry must not uncover the older value for the key.
+	set("a", "a")
+	set("c", "v1")
+	if err := d.Compact([]byte("a"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	set("a", "expired")
+	set("b", "expired")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("a") + " " + get("b") + " " + get("c"); v != "<not found> <not found> v2" {
+		t.Fatalf("expected <not found> <not found> v2, but found %q", v)
+	}
+	if len(filter.levels) != 4 || filter.levels[3] != 0 {
+		t.Fatalf("expected the filter to be invoked 4 times, ending with L0, but found %d", filter.levels)
+	}
+
+	// Entries visible to a snapshot are not filtered.
+	set("d", "expired")
+	snap := d.NewSnapshot()
+	defer snap.Close()
+	set("e", "expired")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := snap.Get([]byte("d")); err != nil || string(v) != "expired" {
+		t.Fatalf("expected d=expired at the snapshot, but found %q, %v", v, err)
+	}
+	if v := get("e"); v != "<not found>" {
+		t.Fatalf("expected e to not be found, but found %q", v)
+	}
+}
+
+func TestSubCompaction(t *testing.T) {
+	for _, n := range []int{1, 4} {
+		t.Run(fmt.Sprintf("max=%d", n), func(t *testing.T) {
+			var outputs []string
+			d, err := Open("", &db.Options{
+				MaxSubCompactions: n,
+				VFS:               vfs.NewMem(),
+				EventListener: &db.EventListener{
+					CompactionEnd: func(info db.CompactionInfo) {
+						if info.Input.Level != 0 || len(info.Input.Tables[1]) == 0 {
+							return
+						}
+						for _, m := range info.Output.Tables {
+							outputs = append(outputs, string(m.Smallest.UserKey))
+						}
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			// Write each key to a separate sstable in L1.
+			keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+			for _, key := range keys {
+				if err := d.Set([]byte(key), []byte("v1"), nil); err != nil {
+					t.Fatal(err)
+				}
+				if err := d.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				if err := d.Compact([]byte(key), []byte(key)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The snapshot keeps the range tombstone from being elided, and
+			// prevents the L1 sstables it covers from being deleted by a
+			// delete-only compaction.
+			snap := d.NewSnapshot()
+			defer snap.Close()
+
+			for _, key := range keys {
+				if err := d.Set([]byte(key), []byte("v2"), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.DeleteRange([]byte("c"), []byte("e"), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Compact([]byte("a"), []byte("h")); err != nil {
+				t.Fatal(err)
+			}
+
+			// The L0->L1 compaction is split at c, e and g.
+			expected := "a"
+			if n > 1 {
+				expected = "a c e g"
+			}
+			if v := strings.Join(outputs, " "); v != expected {
+				t.Fatalf("expected output tables starting at %q, but found %q", expected, v)
+			}
+
+			iter := d.NewIter(nil)
+			var kvs []string
+			for valid := iter.First(); valid; valid = iter.Next() {
+				kvs = append(kvs, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+			}
+			if err := iter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if v := strings.Join(kvs, " "); v != "a:v2 b:v2 e:v2 f:v2 g:v2 h:v2" {
+				t.Fatalf("expected a:v2 b:v2 e:v2 f:v2 g:v2 h:v2, but found %q", v)
+			}
+		})
+	}
+}
+
+func TestConcurrentCompactions(t *testing.T) {
+	for _, n := range []int{1, 2} {
+		t.Run(fmt.Sprintf("max=%d", n), func(t *testing.T) {
+			opts := &db.Options{
+				L0CompactionThreshold:    100,
+				MaxConcurrentCompactions: n,
+				VFS:                      vfs.NewMem(),
+			}
+			d, err := Open("", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := d.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			// Create 2 pairs of overlapping sstables in L0. The sstables in each
+			// pair can be compacted independently of the other pair.
+			for _, keys := range [][]string{{"a", "b"}, {"y", "z"}, {"a", "b"}, {"y", "z"}} {
+				for _, key := range keys {
+					if err := d.Set([]byte(key), []byte(key), nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := d.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Holding d.mu prevents the compactions from making progress, so the
+			// number of compactions started can be observed.
+			d.mu.Lock()
+			opts.L0CompactionThreshold = 2
+			d.mu.versions.picker = newCompactionPicker(d.mu.versions.currentVersion(), opts)
+			d.maybeScheduleCompaction()
+			if count := d.mu.compact.compactingCount; count != n {
+				d.mu.Unlock()
+				t.Fatalf("expected %d compactions in progress, but found %d", n, count)
+			}
+			for d.mu.compact.compactingCount > 0 {
+				d.mu.compact.cond.Wait()
+			}
+			v := d.mu.versions.currentVersion()
+			l0, l1 := len(v.files[0]), len(v.files[1])
+			d.mu.Unlock()
+			if l0 != 0 || l1 != 2 {
+				t.Fatalf("expected 0 L0 and 2 L1 sstables, but found %d and %d", l0, l1)
+			}
+		})
+	}
+}
+
+func TestUniversalCompaction(t *testing.T) {
+	opts := &db.Options{
+		CompactionStyle:       db.CompactionStyleUniversal,
+		L0CompactionThreshold: 4,
+		VFS:                   vfs.NewMem(),
+	}
+	d, err := Open("", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Overwrite the same keys in every round, deleting a range of them in
+	// every other round.
+	const rounds = 20
+	for i := 0; i < rounds; i++ {
+		for j := 0; j < 10; j++ {
+			key := []byte(fmt.Sprintf("%02d", j))
+			if err := d.Set(key, []byte(fmt.Sprint(i)), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%2 == 1 {
+			if err := d.DeleteRange([]byte("03"), []byte("06"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	v := d.mu.versions.currentVersion()
+	d.mu.Unlock()
+
+	for level := 1; level < numLevels-1; level++ {
+		if n := len(v.files[level]); n != 0 {
+			t.Fatalf("expected L%d to be empty, but found %d sstables", level, n)
+		}
+	}
+	if runs := len(universalSortedRuns(v)); runs >= opts.L0CompactionThreshold {
+		t.Fatalf("expected fewer than %d sorted runs, but found %d",
+			opts.L0CompactionThreshold, runs)
+	}
+
+	var buf bytes.Buffer
+	iter := d.NewIter(nil)
+	for iter.First(); iter.Valid(); iter.Next() {
+		fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "00:19 01:19 02:19 06:19 07:19 08:19 09:19 "
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected %q, but found %q", expected, got)
+	}
+}
+
+func TestFIFOCompaction(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	mem := vfs.NewMem()
+	opts := &db.Options{
+		Clock:           clock,
+		CompactionStyle: db.CompactionStyleFIFO,
+		FIFOCompaction: db.FIFOCompactionOptions{
+			TTL: 10 * time.Second,
+		},
+		L0SlowdownWritesThreshold: 1,
+		L0StopWritesThreshold:     1,
+		VFS:                       mem,
+	}
+	d, err := Open("", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The clock is read by maybeScheduleCompaction with d.mu held.
+	advance := func(d *DB, seconds int) {
+		d.mu.Lock()
+		clock.now = clock.now.Add(time.Duration(seconds) * time.Second)
+		d.mu.Unlock()
+	}
+	flush := func(d *DB, keys ...string) {
+		for _, key := range keys {
+			if err := d.Set([]byte(key), []byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(d *DB, expected string) {
+		d.mu.Lock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		v := d.mu.versions.currentVersion()
+		d.mu.Unlock()
+		for level := 1; level < numLevels; level++ {
+			if n := len(v.files[level]); n != 0 {
+				t.Fatalf("expected L%d to be empty, but found %d sstables", level, n)
+			}
+		}
+
+		var keys []string
+		iter := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(keys, " "); got != expected {
+			t.Fatalf("expected %q, but found %q", expected, got)
+		}
+	}
+
+	// The L0 write stall thresholds are ignored, and the sstables are never
+	// merged, even by a manual compaction.
+	flush(d, "a", "b")
+	advance(d, 5)
+	flush(d, "c")
+	advance(d, 1)
+	flush(d, "a")
+	if err := d.Compact([]byte("a"), []byte("z")); err != nil {
+		t.Fatal(err)
+	}
+	check(d, "a b c")
+
+	// The first sstable expires. The key "a" remains as it was written again.
+	advance(d, 5)
+	flush(d, "d")
+	check(d, "a c d")
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The creation times of the sstables are loaded from their properties when
+	// the DB is reopened, and the second sstable expires.
+	clock.now = clock.now.Add(4 * time.Second)
+	d, err = Open("", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(d, "a d")
+
+	// The oldest sstables are deleted once the total size is exceeded.
+	d.mu.Lock()
+	var size int64
+	for _, f := range d.mu.versions.currentVersion().files[0] {
+		size += int64(f.size)
+	}
+	opts.FIFOCompaction.MaxTableFilesSize = size
+	d.mu.Unlock()
+	flush(d, "e")
+	check(d, "d e")
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitedFlush(t *testing.T) {
+	// The limiter allows a burst of 10 KB, after which 100 KB can be written
+	// every second.
+	d, err := Open("", &db.Options{
+		RateLimiter: db.NewRateLimiter(100 << 10),
+		VFS:         vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Use random values, which do not compress.
+	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+	value := make([]byte, 1<<10)
+	for i := 0; i < 30; i++ {
+		rng.Read(value)
+		if err := d.Set([]byte(fmt.Sprint(i)), value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the flush to be delayed by ~200ms, but it took %s", elapsed)
+	}
+}
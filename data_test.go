@@ -35,6 +35,11 @@ func runIterCmd(d *datadriven.TestData, iter *Iterator) string {
 				return fmt.Sprintf("seek-ge <key>\n")
 			}
 			valid = iter.SeekGE([]byte(strings.TrimSpace(parts[1])))
+		case "seek-prefix-ge":
+			if len(parts) != 2 {
+				return fmt.Sprintf("seek-prefix-ge <key>\n")
+			}
+			valid = iter.SeekPrefixGE([]byte(strings.TrimSpace(parts[1])))
 		case "seek-lt":
 			if len(parts) != 2 {
 				return fmt.Sprintf("seek-lt <key>\n")
@@ -247,7 +252,7 @@ func runDBDefineCmd(td *datadriven.TestData) (*DB, error) {
 		if rangeDelIter := mem.newRangeDelIter(nil); rangeDelIter != nil {
 			iter = newMergingIter(d.cmp, iter, rangeDelIter)
 		}
-		meta, err := d.writeLevel0Table(d.opts.VFS, iter,
+		meta, _, err := d.writeLevel0Table(d.opts.VFS, iter,
 			false /* allowRangeTombstoneElision */)
 		if err != nil {
 			return nil
@@ -34,6 +34,7 @@ type flushable interface {
 	flushed() chan struct{}
 	readyForFlush() bool
 	logNumber() uint64
+	totalBytes() uint64
 }
 
 // Reader is a readable key/value store.
@@ -76,6 +77,16 @@ type Writer interface {
 	// It is safe to modify the contents of the arguments after Delete returns.
 	Delete(key []byte, o *db.WriteOptions) error
 
+	// SingleDelete is similar to Delete in that it deletes the value for the
+	// given key. Like Delete, it is a blind operation that will succeed even if
+	// the given key does not exist. SingleDelete is only guaranteed to work
+	// correctly if the key has been set exactly once since the last time it was
+	// deleted (if ever), and has not been merged.
+	//
+	// It is safe to modify the contents of the arguments after SingleDelete
+	// returns.
+	SingleDelete(key []byte, o *db.WriteOptions) error
+
 	// DeleteRange deletes all of the keys (and values) in the range [start,end)
 	// (inclusive on start, exclusive on end).
 	//
@@ -129,11 +140,23 @@ type Writer interface {
 //		Comparer: myComparer,
 //	})
 type DB struct {
+	// WARNING: The following struct `atomic` contains fields which are accessed
+	// atomically. Go allocations are guaranteed to be 64-bit aligned which we
+	// take advantage of by placing the 64-bit fields which we access atomically
+	// at the beginning of the DB struct.
+	atomic struct {
+		// The number of bytes written to the WAL.
+		logBytesIn uint64
+		// The size of the current log file (i.e. d.mu.log.queue[len(queue)-1]).
+		logSize uint64
+	}
+
 	dirname        string
 	opts           *db.Options
 	cmp            db.Compare
 	equal          db.Equal
 	merge          db.Merge
+	split          db.Split
 	abbreviatedKey db.AbbreviatedKey
 
 	tableCache tableCache
@@ -166,6 +189,10 @@ type DB struct {
 
 		log struct {
 			queue []uint64
+			// The oldest log captured by each open checkpoint. These logs and the
+			// logs following them are neither deleted nor recycled. See
+			// DB.openCheckpointState.
+			pinned []uint64
 			*record.LogWriter
 		}
 
@@ -184,11 +211,20 @@ type DB struct {
 		}
 
 		compact struct {
-			cond           sync.Cond
-			flushing       bool
-			compacting     bool
+			cond     sync.Cond
+			flushing bool
+			// compactingCount is the number of compactions in progress, including
+			// delete-only compactions. At most Options.MaxConcurrentCompactions
+			// compactions run concurrently.
+			compactingCount int
+			// inProgress tracks the compactions in progress so that concurrent
+			// compactions do not conflict.
+			inProgress     compactionsInProgress
 			pendingOutputs map[uint64]struct{}
 			manual         []*manualCompaction
+			// The deletion hints generated from the range tombstones in flushed
+			// and compacted sstables. See deletionHint.
+			deletionHints []deletionHint
 		}
 
 		cleaner struct {
@@ -196,8 +232,19 @@ type DB struct {
 			cleaning bool
 		}
 
+		// pacer paces user writes when flushes and compactions fall behind.
+		pacer *writePacer
+
 		// The list of active snapshots.
 		snapshots snapshotList
+
+		// The batches which have been prepared, but neither committed nor rolled
+		// back, indexed by XID. See DB.Prepare.
+		prepared map[string]*preparedBatch
+
+		// secondary is the state of a DB opened with OpenSecondary, and is nil
+		// otherwise. See DB.TryCatchUp.
+		secondary *secondaryState
 	}
 }
 
@@ -239,6 +286,10 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, error) {
 	get.newIters = d.newIters
 	get.snapshot = seqNum
 	get.key = key
+	get.prefix = key
+	if d.split != nil {
+		get.prefix = key[:d.split(key)]
+	}
 	get.batch = b
 	get.mem = readState.memtables
 	get.l0 = readState.current.files[0]
@@ -250,6 +301,10 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, error) {
 	i.merge = d.merge
 	i.iter = get
 	i.readState = readState
+	if d.opts.EnableTTL {
+		i.ttl = true
+		i.ttlNow = d.ttlNow()
+	}
 
 	defer i.Close()
 	if !i.Next() {
@@ -284,6 +339,18 @@ func (d *DB) Delete(key []byte, opts *db.WriteOptions) error {
 	return d.Apply(b, opts)
 }
 
+// SingleDelete adds an action to the DB that single deletes the entry for
+// key. See Writer.SingleDelete for the restrictions on its use.
+//
+// It is safe to modify the contents of the arguments after SingleDelete
+// returns.
+func (d *DB) SingleDelete(key []byte, opts *db.WriteOptions) error {
+	b := newBatch(d)
+	defer b.release()
+	_ = b.SingleDelete(key, opts)
+	return d.Apply(b, opts)
+}
+
 // DeleteRange deletes all of the keys (and values) in the range [start,end)
 // (inclusive on start, exclusive on end).
 //
@@ -304,7 +371,9 @@ func (d *DB) DeleteRange(start, end []byte, opts *db.WriteOptions) error {
 func (d *DB) Merge(key, value []byte, opts *db.WriteOptions) error {
 	b := newBatch(d)
 	defer b.release()
-	_ = b.Merge(key, value, opts)
+	if err := b.Merge(key, value, opts); err != nil {
+		return err
+	}
 	return d.Apply(b, opts)
 }
 
@@ -329,15 +398,29 @@ func (d *DB) LogData(data []byte, opts *db.WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Apply returns.
 func (d *DB) Apply(batch *Batch, opts *db.WriteOptions) error {
+	return d.applyInternal(batch, opts, nil /* validate */)
+}
+
+// applyInternal applies the batch to the DB. If validate is non-nil, it is
+// invoked by the commit pipeline just before the batch is committed, at a
+// point where no other batch can commit concurrently. See
+// commitPipeline.Commit.
+func (d *DB) applyInternal(batch *Batch, opts *db.WriteOptions, validate func() error) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
 	sync := opts.GetSync()
 	if sync && d.opts.DisableWAL {
 		return errors.New("pebble: WAL disabled")
 	}
+	if d.opts.EnableTTL && batch.ttlUnencoded {
+		return errTTLBatch
+	}
 
 	if int(batch.memTableSize) >= d.largeBatchThreshold {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
-	err := d.commit.Commit(batch, sync)
+	err := d.commit.Commit(batch, sync, validate)
 	if err == nil {
 		// If this is a large batch, we need to clear the batch contents as the
 		// flushable batch may still be present in the flushables queue.
@@ -382,8 +465,8 @@ func (d *DB) commitSync() error {
 func (d *DB) commitWrite(b *Batch) (*memTable, error) {
 	d.mu.Lock()
 
-	// Throttle writes if there are too many L0 tables.
-	d.throttleWrite()
+	// Pace writes if flushes and compactions are falling behind.
+	d.paceWrite(len(b.storage.data))
 
 	if b.flushable != nil {
 		b.flushable.seqNum = b.seqNum()
@@ -392,6 +475,10 @@ func (d *DB) commitWrite(b *Batch) (*memTable, error) {
 	// Switch out the memtable if there was not enough room to store the batch.
 	err := d.makeRoomForWrite(b)
 
+	if err == nil && len(d.mu.prepared) > 0 {
+		d.forgetDecidedLocked(b)
+	}
+
 	d.mu.Unlock()
 	if err != nil {
 		return nil, err
@@ -401,10 +488,12 @@ func (d *DB) commitWrite(b *Batch) (*memTable, error) {
 		return d.mu.mem.mutable, nil
 	}
 
-	_, err = d.mu.log.WriteRecord(b.storage.data)
+	size, err := d.mu.log.WriteRecord(b.storage.data)
 	if err != nil {
 		panic(err)
 	}
+	atomic.AddUint64(&d.atomic.logBytesIn, uint64(len(b.storage.data)))
+	atomic.StoreUint64(&d.atomic.logSize, uint64(size))
 	return d.mu.mem.mutable, err
 }
 
@@ -423,45 +512,56 @@ var iterAllocPool = sync.Pool{
 	},
 }
 
-// newIterInternal constructs a new iterator, merging in batchIter as an extra
-// level.
-func (d *DB) newIterInternal(
-	batchIter internalIterator,
-	batchRangeDelIter internalIterator,
-	s *Snapshot,
-	o *db.IterOptions,
-) *Iterator {
+// newIterInternal constructs a new iterator, merging in the contents of batch
+// as an extra level.
+func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *db.IterOptions) *Iterator {
+	// Bundle various structures under a single umbrella in order to allocate
+	// them together.
+	buf := iterAllocPool.Get().(*iterAlloc)
+	dbi := &buf.dbi
+	dbi.alloc = buf
+	dbi.opts = o
+	dbi.cmp = d.cmp
+	dbi.equal = d.equal
+	dbi.merge = d.merge
+	dbi.split = d.split
+	dbi.db = d
+	dbi.batch = batch
+	dbi.snapshot = s
+	d.initIterInternal(dbi)
+	return dbi
+}
+
+// initIterInternal constructs the internal iterators for dbi from the current
+// readState. The internal iterators are allocated from dbi.alloc.
+func (d *DB) initIterInternal(dbi *Iterator) {
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
 	readState := d.loadReadState()
+	dbi.readState = readState
+	if d.opts.EnableTTL {
+		dbi.ttl = true
+		dbi.ttlNow = d.ttlNow()
+	}
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
 	var seqNum uint64
-	if s != nil {
-		seqNum = s.seqNum
+	if dbi.snapshot != nil {
+		seqNum = dbi.snapshot.seqNum
 	} else {
 		seqNum = atomic.LoadUint64(&d.mu.versions.visibleSeqNum)
 	}
 
-	// Bundle various structures under a single umbrella in order to allocate
-	// them together.
-	buf := iterAllocPool.Get().(*iterAlloc)
-	dbi := &buf.dbi
-	dbi.alloc = buf
-	dbi.opts = o
-	dbi.cmp = d.cmp
-	dbi.equal = d.equal
-	dbi.merge = d.merge
-	dbi.readState = readState
-
+	o := dbi.opts
+	buf := dbi.alloc
 	iters := buf.iters[:0]
 	rangeDelIters := buf.rangeDelIters[:0]
 	largestUserKeys := buf.largestUserKeys[:0]
-	if batchIter != nil {
-		iters = append(iters, batchIter)
-		rangeDelIters = append(rangeDelIters, batchRangeDelIter)
+	if dbi.batch != nil {
+		iters = append(iters, dbi.batch.newInternalIter(o))
+		rangeDelIters = append(rangeDelIters, dbi.batch.newRangeDelIter(o))
 		largestUserKeys = append(largestUserKeys, nil)
 	}
 
@@ -483,7 +583,7 @@ func (d *DB) newIterInternal(
 		iter, rangeDelIter, err := d.newIters(f, o)
 		if err != nil {
 			dbi.err = err
-			return dbi
+			return
 		}
 		iters = append(iters, iter)
 		rangeDelIters = append(rangeDelIters, rangeDelIter)
@@ -529,7 +629,68 @@ func (d *DB) newIterInternal(
 	buf.merging.init(d.cmp, iters...)
 	buf.merging.snapshot = seqNum
 	dbi.iter = &buf.merging
-	return dbi
+}
+
+// refreshIterInternal updates the internal iterators of dbi to observe the
+// current state of the DB. If the readState is unchanged, dbi iterates over the
+// current memtables and sstables already: the sstable iterators are retained,
+// and only the iterators over the batch and memtables are recreated in order
+// to observe the range tombstones added to them. Otherwise, the internal
+// iterators are rebuilt from the current readState.
+func (d *DB) refreshIterInternal(dbi *Iterator) {
+	readState := d.loadReadState()
+	readState.unref()
+	if readState != dbi.readState {
+		dbi.readState.unref()
+		dbi.readState = nil
+		err := dbi.iter.Close()
+		dbi.iter = nil
+		if err != nil {
+			dbi.err = err
+			return
+		}
+		d.initIterInternal(dbi)
+		return
+	}
+
+	m := &dbi.alloc.merging
+	memtables := readState.memtables
+	n := len(memtables)
+	if dbi.batch != nil {
+		n++
+	}
+	for j := 0; j < n; j++ {
+		if err := m.iters[j].Close(); err != nil && dbi.err == nil {
+			dbi.err = err
+		}
+		if m.rangeDelIters[j] != nil {
+			if err := m.rangeDelIters[j].Close(); err != nil && dbi.err == nil {
+				dbi.err = err
+			}
+		}
+	}
+	if dbi.err != nil {
+		return
+	}
+
+	o := dbi.opts
+	iters := m.iters[:0]
+	rangeDelIters := m.rangeDelIters[:0]
+	if dbi.batch != nil {
+		iters = append(iters, dbi.batch.newInternalIter(o))
+		rangeDelIters = append(rangeDelIters, dbi.batch.newRangeDelIter(o))
+	}
+	for i := len(memtables) - 1; i >= 0; i-- {
+		mem := memtables[i]
+		iters = append(iters, mem.newIter(o))
+		rangeDelIters = append(rangeDelIters, mem.newRangeDelIter(o))
+	}
+	if dbi.ttl {
+		dbi.ttlNow = d.ttlNow()
+	}
+	if dbi.snapshot == nil {
+		m.snapshot = atomic.LoadUint64(&d.mu.versions.visibleSeqNum)
+	}
 }
 
 // NewBatch returns a new empty write-only batch. Any reads on the batch will
@@ -556,8 +717,7 @@ func (d *DB) NewIndexedBatch() *Batch {
 // apparent memory and disk usage leak. Use snapshots (see NewSnapshot) for
 // point-in-time snapshots which avoids these problems.
 func (d *DB) NewIter(o *db.IterOptions) *Iterator {
-	return d.newIterInternal(nil, /* batchIter */
-		nil /* batchRangeDelIter */, nil /* snapshot */, o)
+	return d.newIterInternal(nil /* batch */, nil /* snapshot */, o)
 }
 
 // NewSnapshot returns a point-in-time view of the current DB state. Iterators
@@ -579,6 +739,18 @@ func (d *DB) NewSnapshot() *Snapshot {
 	return s
 }
 
+// NewTxn returns a new optimistic transaction. Reads performed through the
+// transaction observe the writes buffered in the transaction merged with a
+// point-in-time view of the DB taken when the transaction was created. The
+// caller must call Txn.Close() when the transaction is no longer needed.
+func (d *DB) NewTxn() *Txn {
+	return &Txn{
+		db:       d,
+		batch:    d.NewIndexedBatch(),
+		snapshot: d.NewSnapshot(),
+	}
+}
+
 // Close closes the DB.
 //
 // It is not safe to close a DB until all outstanding iterators are closed.
@@ -590,12 +762,16 @@ func (d *DB) Close() error {
 	if d.mu.closed {
 		return nil
 	}
-	for d.mu.compact.compacting || d.mu.compact.flushing {
+	for d.mu.compact.compactingCount > 0 || d.mu.compact.flushing {
 		d.mu.compact.cond.Wait()
 	}
 	err := d.tableCache.Close()
-	err = firstError(err, d.mu.log.Close())
-	err = firstError(err, d.fileLock.Close())
+	if d.mu.log.LogWriter != nil {
+		err = firstError(err, d.mu.log.Close())
+	}
+	if d.fileLock != nil {
+		err = firstError(err, d.fileLock.Close())
+	}
 	d.commit.Close()
 	d.mu.closed = true
 
@@ -621,6 +797,9 @@ func (d *DB) Close() error {
 
 // Compact the specified range of keys in the database.
 func (d *DB) Compact(start, end []byte /* CompactionOptions */) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
 	iStart := db.MakeInternalKey(start, db.InternalKeySeqNumMax, db.InternalKeyKindMax)
 	iEnd := db.MakeInternalKey(end, 0, 0)
 	meta := []*fileMetadata{&fileMetadata{smallest: iStart, largest: iEnd}}
@@ -684,8 +863,122 @@ func (d *DB) manualCompact(manual *manualCompaction) error {
 	return <-manual.done
 }
 
+// DeleteFilesInRange deletes the sstables whose keys are entirely contained
+// in the range [start,end). Unlike DeleteRange, the disk space used by the
+// deleted sstables is reclaimed immediately rather than when compaction of
+// the range reaches the bottom level of the LSM. sstables which overlap data
+// in the memtables, or which contain data visible to an open snapshot, are not
+// deleted.
+//
+// DeleteFilesInRange does not delete keys in the range that reside in the
+// memtables or in sstables which are not entirely contained in the range. As
+// a consequence, deleting an sstable which contains a deletion tombstone can
+// cause an older version of the deleted key to become visible again. The
+// usual approach is to DeleteRange the range, Flush, and then call
+// DeleteFilesInRange to quickly reclaim the bulk of the space.
+func (d *DB) DeleteFilesInRange(start, end []byte) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ve *versionEdit
+	for {
+		// Wait for any in progress compaction using the sstables we're deleting as
+		// inputs to finish.
+		var busy bool
+		ve, busy = d.pickFilesInRangeLocked(start, end)
+		if !busy {
+			break
+		}
+		d.mu.compact.cond.Wait()
+	}
+	if len(ve.deletedFiles) == 0 {
+		return nil
+	}
+
+	// Prevent a compaction from using the sstables as inputs while the version
+	// edit is applied.
+	for e := range ve.deletedFiles {
+		d.mu.compact.inProgress.addFile(e.fileNum)
+	}
+	defer func() {
+		for e := range ve.deletedFiles {
+			d.mu.compact.inProgress.removeFile(e.fileNum)
+		}
+		d.maybeScheduleCompaction()
+		d.mu.compact.cond.Broadcast()
+	}()
+
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	if err := d.mu.versions.logAndApply(ve); err != nil {
+		return err
+	}
+	d.updateReadStateLocked()
+	d.deleteObsoleteFiles(jobID)
+	return nil
+}
+
+// pickFilesInRangeLocked returns a version edit which deletes the sstables
+// that DeleteFilesInRange can delete. It also returns whether any of those
+// sstables is in use by a compaction in progress.
+//
+// d.mu must be held when calling this.
+func (d *DB) pickFilesInRangeLocked(start, end []byte) (ve *versionEdit, busy bool) {
+	// An sstable is visible to a snapshot if it contains an entry older than
+	// the snapshot. It suffices to check the newest snapshot.
+	var snapshot uint64
+	if !d.mu.snapshots.empty() {
+		snapshot = d.mu.snapshots.root.prev.seqNum
+	}
+
+	ve = &versionEdit{
+		deletedFiles: map[deletedFileEntry]bool{},
+	}
+	current := d.mu.versions.currentVersion()
+	for level := range current.files {
+		overlaps := current.overlaps(level, d.cmp, start, end)
+		for i := range overlaps {
+			f := &overlaps[i]
+			if d.cmp(f.smallest.UserKey, start) < 0 {
+				continue
+			}
+			// The end of the range is exclusive, though an sstable whose largest key
+			// is a range deletion sentinel does not contain its largest user key.
+			if c := d.cmp(f.largest.UserKey, end); c > 0 ||
+				(c == 0 && f.largest.Trailer != db.InternalKeyRangeDeleteSentinel) {
+				continue
+			}
+			if f.smallestSeqNum < snapshot {
+				continue
+			}
+			meta := []*fileMetadata{f}
+			memOverlaps := false
+			for _, mem := range d.mu.mem.queue {
+				if ingestMemtableOverlaps(d.cmp, mem, meta) {
+					memOverlaps = true
+					break
+				}
+			}
+			if memOverlaps {
+				continue
+			}
+			if d.mu.compact.inProgress.busy(f.fileNum) {
+				busy = true
+			}
+			ve.deletedFiles[deletedFileEntry{level: level, fileNum: f.fileNum}] = true
+		}
+	}
+	return ve, busy
+}
+
 // Flush the memtable to stable storage.
 func (d *DB) Flush() error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
 	d.mu.Lock()
 	mem := d.mu.mem.mutable
 	err := d.makeRoomForWrite(nil)
@@ -701,6 +994,9 @@ func (d *DB) Flush() error {
 //
 // TODO(peter): untested
 func (d *DB) AsyncFlush() error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
 	d.mu.Lock()
 	err := d.makeRoomForWrite(nil)
 	d.mu.Unlock()
@@ -721,20 +1017,30 @@ func (d *DB) walPreallocateSize() int {
 	return size
 }
 
-func (d *DB) throttleWrite() {
-	if len(d.mu.versions.currentVersion().files[0]) <= d.opts.L0SlowdownWritesThreshold {
+// paceWrite delays a write of the specified size if flushes and compactions
+// are falling behind user writes. See writePacer.
+//
+// d.mu must be held when calling this, but the mutex is dropped while waiting.
+func (d *DB) paceWrite(size int) {
+	if d.opts.CompactionStyle == db.CompactionStyleFIFO {
+		// The sstables are never compacted.
 		return
 	}
-	// fmt.Printf("L0 slowdown writes threshold\n")
-	// We are getting close to hitting a hard limit on the number of L0
-	// files. Rather than delaying a single write by several seconds when we hit
-	// the hard limit, start delaying each individual write by 1ms to reduce
-	// latency variance.
-	//
-	// TODO(peter): Use more sophisticated rate limiting.
-	d.mu.Unlock()
-	time.Sleep(1 * time.Millisecond)
-	d.mu.Lock()
+	// The flush debt is the size of the immutable memtables.
+	var debt uint64
+	queue := d.mu.mem.queue
+	for i := 0; i < len(queue)-1; i++ {
+		debt += queue[i].totalBytes()
+	}
+	if p := d.mu.versions.picker; p != nil {
+		debt += p.compactionDebt
+	}
+	pressure := writePressure(d.opts, debt, len(d.mu.versions.currentVersion().files[0]))
+	if delay := d.mu.pacer.delay(time.Now(), size, pressure); delay > 0 {
+		d.mu.Unlock()
+		time.Sleep(delay)
+		d.mu.Lock()
+	}
 }
 
 func (d *DB) makeRoomForWrite(b *Batch) error {
@@ -762,7 +1068,8 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			d.mu.compact.cond.Wait()
 			continue
 		}
-		if len(d.mu.versions.currentVersion().files[0]) > d.opts.L0StopWritesThreshold {
+		if d.opts.CompactionStyle != db.CompactionStyleFIFO &&
+			len(d.mu.versions.currentVersion().files[0]) > d.opts.L0StopWritesThreshold {
 			// There are too many level-0 files, so we wait.
 			// fmt.Printf("L0 stop writes threshold\n")
 			d.mu.compact.cond.Wait()
@@ -839,8 +1146,14 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		}
 
 		if !d.opts.DisableWAL {
+			w := record.NewLogWriter(newLogFile, newLogNumber)
+			// Prepared batches are only present in the WAL. Rewrite them to the
+			// new WAL so that they survive the deletion of the older WALs.
+			if err := d.logPreparedLocked(w); err != nil {
+				panic(err)
+			}
 			d.mu.log.queue = append(d.mu.log.queue, newLogNumber)
-			d.mu.log.LogWriter = record.NewLogWriter(newLogFile, newLogNumber)
+			d.mu.log.LogWriter = w
 		}
 
 		prevLogNumber := d.mu.mem.mutable.logNum
@@ -862,6 +1175,11 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		// flush. Additionally, the memtable is tied to particular WAL file and we
 		// want to go through the flush path in order to recycle that WAL file.
 		imm := d.mu.mem.mutable
+		if !d.opts.DisableWAL {
+			// Record the size of the previous log which is now associated with the
+			// immutable memtable.
+			imm.logSize = atomic.SwapUint64(&d.atomic.logSize, 0)
+		}
 		d.mu.mem.mutable = newMemTable(d.opts)
 		// NB: When the immutable memtable is flushed to disk it will apply a
 		// versionEdit to the manifest telling it that log files < newLogNumber
@@ -0,0 +1,19 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package db
+
+import "time"
+
+// Clock defines an interface for retrieving the current time. It allows the
+// passage of time to be controlled in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type defaultClock struct{}
+
+func (defaultClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package db
+
+// CompactionFilterDecision is the decision made by a CompactionFilter for an
+// entry.
+type CompactionFilterDecision int8
+
+const (
+	// CompactionFilterKeep keeps the entry unchanged.
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// CompactionFilterRemove removes the entry. The entry is replaced by a
+	// deletion tombstone if necessary to prevent older values for the key from
+	// becoming visible.
+	CompactionFilterRemove
+	// CompactionFilterChangeValue replaces the value of the entry.
+	CompactionFilterChangeValue
+)
+
+// CompactionFilter allows an application to remove entries or to modify their
+// values as they are written by flushes and compactions. Typical uses include
+// expiring data, garbage collecting old versions of keys stored by the
+// application, and rewriting values to a new format.
+//
+// The filter is only consulted for entries which are not visible to any
+// snapshot, which guarantees that reads from a snapshot are not affected by
+// the filter. As a consequence, an open snapshot can prevent entries from
+// being filtered. The filter only sees the entries involved in a particular
+// flush or compaction, and may not be invoked for an entry at all if the entry
+// is never compacted.
+type CompactionFilter interface {
+	// Filter is invoked for the newest entry for key in a flush or compaction
+	// which writes to the specified level. The kind is either
+	// InternalKeyKindSet, or InternalKeyKindMerge in which case value is the
+	// merge of the operands seen by the compaction. Removing a merge entry
+	// removes the key, including any operands and values in other levels.
+	//
+	// If the decision is CompactionFilterChangeValue, newValue is used as the
+	// new value of the entry. The filter must not modify the contents of key or
+	// value, and newValue must remain valid until the next call to Filter.
+	//
+	// Filter may be invoked concurrently from different flushes and
+	// compactions.
+	Filter(level int, key []byte, kind InternalKeyKind, value []byte) (
+		decision CompactionFilterDecision, newValue []byte)
+}
@@ -10,3 +10,7 @@ import (
 
 // ErrNotFound means that a get or delete call did not find the requested key.
 var ErrNotFound = errors.New("pebble: not found")
+
+// ErrReadOnly is returned by operations which modify a DB that was opened
+// with Options.ReadOnly.
+var ErrReadOnly = errors.New("pebble: read-only")
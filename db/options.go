@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/petermattis/pebble/cache"
 	"github.com/petermattis/pebble/vfs"
@@ -52,6 +53,39 @@ func (t FilterType) String() string {
 	return "unknown"
 }
 
+// CompactionStyle is the strategy used to pick compactions.
+type CompactionStyle int
+
+// The available compaction styles.
+const (
+	// CompactionStyleLevel organizes the sstables into levels of exponentially
+	// increasing size, and compacts a level into the next level when the level
+	// exceeds its size limit.
+	CompactionStyleLevel CompactionStyle = iota
+	// CompactionStyleUniversal organizes the sstables into sorted runs, and
+	// merges sorted runs of similar size. It trades higher read and space
+	// amplification for lower write amplification. See
+	// UniversalCompactionOptions.
+	CompactionStyleUniversal
+	// CompactionStyleFIFO keeps all of the sstables in L0 and never merges
+	// them. The oldest sstables are deleted once the total size of the sstables
+	// or their age exceeds a limit. It is suitable for data, such as metrics
+	// and logs, where only recent entries matter. See FIFOCompactionOptions.
+	CompactionStyleFIFO
+)
+
+func (s CompactionStyle) String() string {
+	switch s {
+	case CompactionStyleLevel:
+		return "level"
+	case CompactionStyleUniversal:
+		return "universal"
+	case CompactionStyleFIFO:
+		return "fifo"
+	}
+	return "unknown"
+}
+
 // FilterWriter provides an interface for creating filter blocks. See
 // FilterPolicy for more details about filters.
 type FilterWriter interface {
@@ -180,6 +214,88 @@ func (o *LevelOptions) EnsureDefaults() *LevelOptions {
 	return o
 }
 
+// FIFOCompactionOptions holds the optional parameters for the FIFO compaction
+// style.
+//
+// With FIFO compaction, the sstables are deleted in the order in which they
+// were written. Deleting an sstable deletes the entries in it, regardless of
+// whether they have been overwritten or deleted.
+type FIFOCompactionOptions struct {
+	// MaxTableFilesSize is the maximum total size of the sstables. When it is
+	// exceeded, the oldest sstables are deleted.
+	//
+	// The default value is 1GB.
+	MaxTableFilesSize int64
+
+	// TTL is the maximum age of an sstable, based on the creation time recorded
+	// in its properties. Expired sstables are deleted when a flush or
+	// compaction completes, and when the DB is opened.
+	//
+	// The default value is 0, which disables deletion based on age.
+	TTL time.Duration
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *FIFOCompactionOptions) EnsureDefaults() *FIFOCompactionOptions {
+	if o == nil {
+		o = &FIFOCompactionOptions{}
+	}
+	if o.MaxTableFilesSize <= 0 {
+		o.MaxTableFilesSize = 1 << 30 // 1 GB
+	}
+	return o
+}
+
+// UniversalCompactionOptions holds the optional parameters for the universal
+// compaction style.
+//
+// With universal compaction, every L0 sstable is a sorted run, and the bottom
+// level forms the oldest sorted run. A compaction merges a contiguous sequence
+// of sorted runs, ordered from newest to oldest, into a single sorted run.
+type UniversalCompactionOptions struct {
+	// MaxSizeAmplificationPercent is the maximum size of all of the sorted runs
+	// other than the oldest, as a percentage of the size of the oldest sorted
+	// run. When it is exceeded, all of the sorted runs are compacted together.
+	//
+	// The default value is 200.
+	MaxSizeAmplificationPercent int
+
+	// MinMergeWidth is the minimum number of sorted runs merged by a compaction
+	// picked by size ratio.
+	//
+	// The default value is 2.
+	MinMergeWidth int
+
+	// SizeRatio is the percentage of flexibility when comparing the sizes of
+	// sorted runs. A sorted run is added to a compaction if its size is no
+	// larger than the total size of the newer sorted runs in the compaction
+	// plus SizeRatio percent.
+	//
+	// The default value is 1.
+	SizeRatio int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *UniversalCompactionOptions) EnsureDefaults() *UniversalCompactionOptions {
+	if o == nil {
+		o = &UniversalCompactionOptions{}
+	}
+	if o.MaxSizeAmplificationPercent <= 0 {
+		o.MaxSizeAmplificationPercent = 200
+	}
+	if o.MinMergeWidth < 2 {
+		o.MinMergeWidth = 2
+	}
+	if o.SizeRatio <= 0 {
+		o.SizeRatio = 1
+	}
+	return o
+}
+
 // Options holds the optional parameters for configuring pebble. These options
 // apply to the DB at large; per-query options are defined by the ReadOptions
 // and WriteOptions types.
@@ -195,6 +311,13 @@ type Options struct {
 	// TODO(peter): provide a cache interface.
 	Cache *cache.Cache
 
+	// Clock provides the current time, which is used to determine whether
+	// entries written with a TTL have expired (see EnableTTL) and to record the
+	// creation time of sstables.
+	//
+	// The default value uses time.Now.
+	Clock Clock
+
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
 	// and writes over the lifetime of the DB.
@@ -202,6 +325,30 @@ type Options struct {
 	// The default value uses the same ordering as bytes.Compare.
 	Comparer *Comparer
 
+	// CompactionFilter allows entries to be removed or their values to be
+	// modified as they are flushed and compacted. See CompactionFilter for
+	// details.
+	//
+	// The default value is nil, which keeps every entry.
+	CompactionFilter CompactionFilter
+
+	// CompactionStyle is the strategy used to pick compactions. With the
+	// universal compaction style, L0CompactionThreshold is the number of sorted
+	// runs which triggers a compaction. With the FIFO compaction style,
+	// L0SlowdownWritesThreshold and L0StopWritesThreshold are ignored, and
+	// manual compactions do nothing.
+	//
+	// The default value is CompactionStyleLevel.
+	CompactionStyle CompactionStyle
+
+	// DelayedWriteRate is the rate, in bytes per second, at which user writes
+	// are allowed once flushes and compactions start falling behind. The rate
+	// decreases as they fall further behind, down to 1% of DelayedWriteRate.
+	// See WriteDebtSlowdownThreshold and L0SlowdownWritesThreshold.
+	//
+	// The default value is 16MB/s.
+	DelayedWriteRate int64
+
 	// Disable the write-ahead log (WAL). Disabling the write-ahead log prohibits
 	// crash recovery, but can improve performance if crash recovery is not
 	// needed (e.g. when only temporary state is being stored in the database).
@@ -209,6 +356,17 @@ type Options struct {
 	// TODO(peter): untested
 	DisableWAL bool
 
+	// EnableTTL enables TTL mode, which allows entries to be written with an
+	// expiration time via {Batch,DB}.SetWithTTL. Expired entries are hidden from
+	// reads and are dropped by flushes and compactions. In TTL mode every value
+	// is stored along with its expiration time, so a DB created in TTL mode must
+	// always be opened in TTL mode, and vice versa. Merge and sstable ingestion
+	// are not supported in TTL mode, and a batch which sets values must be
+	// created by the DB (see DB.NewBatch).
+	//
+	// The default value is false.
+	EnableTTL bool
+
 	// ErrorIfDBExists is whether it is an error if the database already exists.
 	//
 	// The default value is false.
@@ -218,11 +376,16 @@ type Options struct {
 	// flushes, compactions, and table deletion.
 	EventListener *EventListener
 
+	// FIFOCompaction holds the parameters for the FIFO compaction style. See
+	// CompactionStyle.
+	FIFOCompaction FIFOCompactionOptions
+
 	// The number of files necessary to trigger an L0 compaction.
 	L0CompactionThreshold int
 
-	// Soft limit on the number of L0 files. Writes are slowed down when this
-	// threshold is reached.
+	// Soft limit on the number of L0 files. Writes are paced when this
+	// threshold is exceeded, at a rate which decreases as the number of L0
+	// files approaches L0StopWritesThreshold. See DelayedWriteRate.
 	L0SlowdownWritesThreshold int
 
 	// Hard limit on the number of L0 files. Writes are stopped when this
@@ -243,6 +406,14 @@ type Options struct {
 	// The default logger uses the Go standard library log package.
 	Logger Logger
 
+	// MaxConcurrentCompactions is the maximum number of compactions which may
+	// run concurrently. Compactions only run concurrently if they do not share
+	// any input tables and do not write overlapping key ranges to the same
+	// level.
+	//
+	// The default value is 1.
+	MaxConcurrentCompactions int
+
 	// MaxManifestFileSize is the maximum size the MANIFEST file is allowed to
 	// become. When the MANIFEST exceeds this size it is rolled over and a new
 	// MANIFEST is created.
@@ -254,6 +425,15 @@ type Options struct {
 	// The default value is 1000.
 	MaxOpenFiles int
 
+	// MaxSubCompactions is the maximum number of shards a compaction is split
+	// into. The key range of a compaction is split at the boundaries of the
+	// tables in the output and grandparent levels, and the shards are compacted
+	// concurrently. Note that the CompactionFilter may be invoked concurrently
+	// when sub-compactions are enabled.
+	//
+	// The default value is 1, which disables sub-compactions.
+	MaxSubCompactions int
+
 	// The size of a MemTable. Note that more than one MemTable can be in
 	// existence since flushing a MemTable involves creating a new one and
 	// writing the contents of the old one in the
@@ -272,16 +452,50 @@ type Options struct {
 	// The default merger concatenates values.
 	Merger *Merger
 
+	// RateLimiter limits the rate at which flushes and compactions write
+	// sstables. Flushes take precedence over compactions. A RateLimiter may be
+	// shared by several DBs to limit their combined writes.
+	//
+	// The default value is nil, which does not limit the writes.
+	RateLimiter *RateLimiter
+
+	// ReadOnly indicates that the DB should be opened in read-only mode. The
+	// database directory is not locked, the WAL is replayed into memtables
+	// rather than flushed, and no files are created, compacted or deleted.
+	// Writes, ingestions, flushes and compactions return ErrReadOnly. This
+	// allows opening a DB owned by another process, or a read-only snapshot of
+	// its files, without modifying it.
+	ReadOnly bool
+
 	// TableFormat specifies the format version for sstables. The default is
 	// TableFormatRocksDBv2 which creates RocksDB compatible sstables. Use
 	// TableFormatLevelDB to create LevelDB compatible sstable which can be used
 	// by a wider range of tools and libraries.
 	TableFormat TableFormat
 
+	// UniversalCompaction holds the parameters for the universal compaction
+	// style. See CompactionStyle.
+	UniversalCompaction UniversalCompactionOptions
+
 	// VFS provides the interface for persistent file storage.
 	//
 	// The default value uses the underlying operating system's file system.
 	VFS vfs.FS
+
+	// WriteDebtSlowdownThreshold is the flush and compaction debt, in bytes,
+	// above which user writes are paced. The debt is the estimated number of
+	// bytes which need to be flushed and compacted before every level is
+	// within its target size. The write rate decreases from DelayedWriteRate
+	// as the debt grows towards WriteDebtLimit.
+	//
+	// The default value is 256MB.
+	WriteDebtSlowdownThreshold int64
+
+	// WriteDebtLimit is the flush and compaction debt, in bytes, at which user
+	// writes are paced at the minimum rate. See WriteDebtSlowdownThreshold.
+	//
+	// The default value is 4 times WriteDebtSlowdownThreshold.
+	WriteDebtLimit int64
 }
 
 // EnsureDefaults ensures that the default values for all options are set if a
@@ -293,9 +507,16 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.BytesPerSync <= 0 {
 		o.BytesPerSync = 512 << 10
 	}
+	if o.Clock == nil {
+		o.Clock = defaultClock{}
+	}
 	if o.Comparer == nil {
 		o.Comparer = DefaultComparer
 	}
+	if o.DelayedWriteRate <= 0 {
+		o.DelayedWriteRate = 16 << 20 // 16 MB/s
+	}
+	o.FIFOCompaction.EnsureDefaults()
 	if o.L0CompactionThreshold <= 0 {
 		o.L0CompactionThreshold = 4
 	}
@@ -327,12 +548,18 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Logger == nil {
 		o.Logger = defaultLogger{}
 	}
+	if o.MaxConcurrentCompactions <= 0 {
+		o.MaxConcurrentCompactions = 1
+	}
 	if o.MaxManifestFileSize == 0 {
 		o.MaxManifestFileSize = 128 << 20 // 128 MB
 	}
 	if o.MaxOpenFiles == 0 {
 		o.MaxOpenFiles = 1000
 	}
+	if o.MaxSubCompactions <= 0 {
+		o.MaxSubCompactions = 1
+	}
 	if o.MemTableSize <= 0 {
 		o.MemTableSize = 4 << 20
 	}
@@ -342,9 +569,16 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Merger == nil {
 		o.Merger = DefaultMerger
 	}
+	o.UniversalCompaction.EnsureDefaults()
 	if o.VFS == nil {
 		o.VFS = vfs.Default
 	}
+	if o.WriteDebtSlowdownThreshold <= 0 {
+		o.WriteDebtSlowdownThreshold = 256 << 20 // 256 MB
+	}
+	if o.WriteDebtLimit <= o.WriteDebtSlowdownThreshold {
+		o.WriteDebtLimit = 4 * o.WriteDebtSlowdownThreshold
+	}
 	return o
 }
 
@@ -370,17 +604,24 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "[Options]\n")
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", o.Cache.MaxSize())
+	fmt.Fprintf(&buf, "  compaction_style=%s\n", o.CompactionStyle)
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
+	fmt.Fprintf(&buf, "  delayed_write_rate=%d\n", o.DelayedWriteRate)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
+	fmt.Fprintf(&buf, "  enable_ttl=%t\n", o.EnableTTL)
 	fmt.Fprintf(&buf, "  l0_compaction_threshold=%d\n", o.L0CompactionThreshold)
 	fmt.Fprintf(&buf, "  l0_slowdown_writes_threshold=%d\n", o.L0SlowdownWritesThreshold)
 	fmt.Fprintf(&buf, "  l0_stop_writes_threshold=%d\n", o.L0StopWritesThreshold)
 	fmt.Fprintf(&buf, "  l1_max_bytes=%d\n", o.L1MaxBytes)
+	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions)
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	fmt.Fprintf(&buf, "  max_sub_compactions=%d\n", o.MaxSubCompactions)
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	fmt.Fprintf(&buf, "  write_debt_limit=%d\n", o.WriteDebtLimit)
+	fmt.Fprintf(&buf, "  write_debt_slowdown_threshold=%d\n", o.WriteDebtSlowdownThreshold)
 
 	for i := range o.Levels {
 		l := &o.Levels[i]
@@ -444,6 +685,11 @@ func (o *Options) Check(s string) error {
 				return fmt.Errorf("pebble: merger name from file %q != meger name from options %q",
 					value, o.Merger.Name)
 			}
+		case "Options.enable_ttl":
+			if value != fmt.Sprint(o.EnableTTL) {
+				return fmt.Errorf("pebble: enable_ttl from file %s != enable_ttl from options %t",
+					value, o.EnableTTL)
+			}
 		}
 	}
 	return nil
@@ -466,10 +712,11 @@ type IterOptions struct {
 	UpperBound []byte
 	// TableFilter can be used to filter the tables that are scanned during
 	// iteration based on the user properties. Return true to scan the table and
-	// false to skip scanning.
-	//
-	// TODO(peter): unimplemented.
-	// TableFilter func(userProps map[string]string) bool
+	// false to skip scanning. The filter is consulted after the table's
+	// properties have been loaded, but before any of its data blocks are
+	// read. Note that skipping a table also skips the range deletion
+	// tombstones it contains.
+	TableFilter func(userProps map[string]string) bool
 
 	// If Prefix is true, the iterator will only be used to iterate over keys
 	// matching that of the key it is first positioned at. If the Comparer was
@@ -502,6 +749,14 @@ func (o *IterOptions) GetUpperBound() []byte {
 	return o.UpperBound
 }
 
+// GetTableFilter returns the TableFilter or nil if the receiver is nil.
+func (o *IterOptions) GetTableFilter() func(userProps map[string]string) bool {
+	if o == nil {
+		return nil
+	}
+	return o.TableFilter
+}
+
 // WriteOptions hold the optional per-query parameters for Set and Delete
 // operations.
 //
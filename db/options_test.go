@@ -42,17 +42,24 @@ func TestOptionsString(t *testing.T) {
 [Options]
   bytes_per_sync=524288
   cache_size=0
+  compaction_style=level
   comparer=leveldb.BytewiseComparator
+  delayed_write_rate=16777216
   disable_wal=false
+  enable_ttl=false
   l0_compaction_threshold=4
   l0_slowdown_writes_threshold=8
   l0_stop_writes_threshold=12
   l1_max_bytes=67108864
+  max_concurrent_compactions=1
   max_manifest_file_size=134217728
   max_open_files=1000
+  max_sub_compactions=1
   mem_table_size=4194304
   mem_table_stop_writes_threshold=2
   merger=pebble.concatenate
+  write_debt_limit=1073741824
+  write_debt_slowdown_threshold=268435456
 
 [Level "0"]
   block_restart_interval=16
@@ -85,6 +92,10 @@ func TestOptionsCheck(t *testing.T) {
 	tmp.Merger = &Merger{Name: "foo"}
 	require.Regexp(t, `merger name from file.*!=.*`, tmp.Check(s))
 
+	tmp = *opts
+	tmp.EnableTTL = true
+	require.Regexp(t, `enable_ttl from file.*!=.*`, tmp.Check(s))
+
 	// RocksDB uses a similar (INI-style) syntax for the OPTIONS file, but
 	// different section names and keys.
 	s = `
@@ -0,0 +1,185 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/petermattis/pebble/internal/rate"
+)
+
+const (
+	// rateLimiterRefillPeriod is the period of time whose worth of writes can be
+	// performed in a single burst.
+	rateLimiterRefillPeriod = 100 * time.Millisecond
+	// rateLimiterTuneInterval is the interval at which an auto-tuned
+	// RateLimiter adjusts its rate.
+	rateLimiterTuneInterval = time.Second
+	// rateLimiterRangeFactor is the ratio of the maximum to the minimum rate of
+	// an auto-tuned RateLimiter.
+	rateLimiterRangeFactor = 20
+)
+
+// IOPriority is the priority of the writes performed by a flush or a
+// compaction. See RateLimiter.
+type IOPriority int
+
+const (
+	// IOPriorityLow is the priority of the writes performed by compactions.
+	IOPriorityLow IOPriority = iota
+	// IOPriorityHigh is the priority of the writes performed by flushes, which
+	// need to keep up with the foreground writes to avoid stalling them.
+	IOPriorityHigh
+)
+
+// RateLimiter limits the rate, in bytes per second, at which flushes and
+// compactions write sstables. It prevents bursts of background writes from
+// saturating the disk and increasing the latency of foreground operations. A
+// RateLimiter may be shared by several DBs in order to limit their combined
+// writes.
+//
+// Writes at IOPriorityHigh take precedence over writes at IOPriorityLow: a low
+// priority write is not started while a high priority write is waiting.
+type RateLimiter struct {
+	limiter  *rate.Limiter
+	burst    int
+	maxRate  int64
+	autoTune bool
+
+	mu struct {
+		sync.Mutex
+		cond sync.Cond
+		// The number of high priority writes which are waiting.
+		highWaiting int
+		// The number of writes, and the number of writes which were delayed,
+		// since tuneStart. Only maintained by an auto-tuned RateLimiter.
+		tuneStart time.Time
+		requests  int
+		delayed   int
+	}
+}
+
+// NewRateLimiter returns a RateLimiter which limits writes to the specified
+// number of bytes per second.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	burst := int(bytesPerSecond * int64(rateLimiterRefillPeriod) / int64(time.Second))
+	if burst < 1 {
+		burst = 1
+	}
+	l := &RateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst),
+		burst:   burst,
+		maxRate: bytesPerSecond,
+	}
+	l.mu.cond.L = &l.mu.Mutex
+	return l
+}
+
+// NewAutoTunedRateLimiter returns a RateLimiter whose rate adapts to the
+// demand for background writes, between 1/20th of and the full
+// maxBytesPerSecond. The rate is raised when most writes are delayed, and
+// lowered when few writes are delayed, so that the background writes are
+// spread out instead of being performed in bursts.
+func NewAutoTunedRateLimiter(maxBytesPerSecond int64) *RateLimiter {
+	l := NewRateLimiter(maxBytesPerSecond)
+	l.autoTune = true
+	return l
+}
+
+// BytesPerSecond returns the current rate limit.
+func (l *RateLimiter) BytesPerSecond() int64 {
+	return int64(l.limiter.Limit())
+}
+
+// Request blocks until n bytes may be written at the specified priority.
+func (l *RateLimiter) Request(n int, pri IOPriority) {
+	for n > 0 {
+		chunk := n
+		if chunk > l.burst {
+			chunk = l.burst
+		}
+		l.request(chunk, pri)
+		n -= chunk
+	}
+}
+
+func (l *RateLimiter) request(n int, pri IOPriority) {
+	l.mu.Lock()
+	if pri == IOPriorityHigh {
+		l.mu.highWaiting++
+	} else {
+		for l.mu.highWaiting > 0 {
+			l.mu.cond.Wait()
+		}
+	}
+	now := time.Now()
+	delay := l.limiter.ReserveN(now, n).DelayFrom(now)
+	if l.autoTune {
+		l.recordLocked(now, delay > 0)
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if pri == IOPriorityHigh {
+		l.mu.Lock()
+		l.mu.highWaiting--
+		if l.mu.highWaiting == 0 {
+			l.mu.cond.Broadcast()
+		}
+		l.mu.Unlock()
+	}
+}
+
+// recordLocked records a write for auto-tuning, and tunes the rate at the end
+// of every tuning interval.
+//
+// l.mu must be held when calling this.
+func (l *RateLimiter) recordLocked(now time.Time, delayed bool) {
+	if l.mu.tuneStart.IsZero() {
+		l.mu.tuneStart = now
+	}
+	l.mu.requests++
+	if delayed {
+		l.mu.delayed++
+	}
+	if now.Sub(l.mu.tuneStart) >= rateLimiterTuneInterval {
+		l.tuneLocked()
+		l.mu.tuneStart = now
+		l.mu.requests = 0
+		l.mu.delayed = 0
+	}
+}
+
+// tuneLocked raises the rate by 5% if at least 90% of the writes in the
+// current tuning interval were delayed, and lowers it by 5% if fewer than 50%
+// were.
+//
+// l.mu must be held when calling this.
+func (l *RateLimiter) tuneLocked() {
+	if l.mu.requests == 0 {
+		return
+	}
+	pct := l.mu.delayed * 100 / l.mu.requests
+	r := l.BytesPerSecond()
+	switch {
+	case pct >= 90:
+		r = r * 105 / 100
+	case pct < 50:
+		r = r * 100 / 105
+	default:
+		return
+	}
+	if r > l.maxRate {
+		r = l.maxRate
+	}
+	if minRate := l.maxRate / rateLimiterRangeFactor; r < minRate {
+		r = minRate
+	}
+	l.limiter.SetLimit(rate.Limit(r))
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// The limiter allows a burst of 10 KB, after which 100 KB can be written
+	// every second.
+	l := NewRateLimiter(100 << 10)
+	start := time.Now()
+	l.Request(30<<10, IOPriorityLow)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the request to be delayed by ~200ms, but it took %s", elapsed)
+	}
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	l := NewRateLimiter(1 << 30)
+
+	// Simulate a waiting high priority write.
+	l.mu.Lock()
+	l.mu.highWaiting++
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.Request(1, IOPriorityLow)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("low priority write was not delayed by a high priority write")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// High priority writes are not delayed by each other.
+	l.Request(1, IOPriorityHigh)
+
+	l.mu.Lock()
+	l.mu.highWaiting--
+	l.mu.cond.Broadcast()
+	l.mu.Unlock()
+	<-done
+}
+
+func TestRateLimiterAutoTune(t *testing.T) {
+	l := NewAutoTunedRateLimiter(1000)
+	tune := func(requests, delayed int) int64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.mu.requests = requests
+		l.mu.delayed = delayed
+		l.tuneLocked()
+		return l.BytesPerSecond()
+	}
+
+	testCases := []struct {
+		requests int
+		delayed  int
+		expected int64
+	}{
+		// The rate starts at the maximum, and cannot be raised further.
+		{10, 10, 1000},
+		// Few writes were delayed, so the rate is lowered.
+		{10, 4, 952},
+		{10, 0, 906},
+		// The rate is unchanged when 50-90% of the writes were delayed.
+		{10, 5, 906},
+		{10, 8, 906},
+		// Most writes were delayed, so the rate is raised.
+		{10, 9, 951},
+		{0, 0, 951},
+	}
+	for _, c := range testCases {
+		if r := tune(c.requests, c.delayed); r != c.expected {
+			t.Fatalf("%d/%d delayed: expected %d, but found %d", c.delayed, c.requests, c.expected, r)
+		}
+	}
+
+	// The rate is not lowered below 1/20th of the maximum.
+	for i := 0; i < 100; i++ {
+		tune(10, 0)
+	}
+	if r := l.BytesPerSecond(); r != 50 {
+		t.Fatalf("expected 50, but found %d", r)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/petermattis/pebble/bloom"
 	"github.com/petermattis/pebble/cache"
 	"github.com/petermattis/pebble/db"
 	"github.com/petermattis/pebble/vfs"
@@ -506,6 +507,240 @@ func TestGetMerge(t *testing.T) {
 	}
 }
 
+func TestGetPrefixFilter(t *testing.T) {
+	comparer := *db.DefaultComparer
+	comparer.Name = "prefix-comparer"
+	comparer.Split = func(a []byte) int {
+		if i := bytes.IndexByte(a, '@'); i >= 0 {
+			return i
+		}
+		return len(a)
+	}
+	d, err := Open("", &db.Options{
+		Comparer: &comparer,
+		Levels:   []db.LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+		VFS:      vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Write the keys to both L0 and a lower level.
+	for _, k := range []string{"a@1", "b@1", "b@2", "d@1"} {
+		if err := d.Set([]byte(k), []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Compact([]byte("a"), []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"c@1", "e@1"} {
+		if err := d.Set([]byte(k), []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a@1", "b@1", "b@2", "c@1", "d@1", "e@1"} {
+		v, err := d.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+		if string(v) != k {
+			t.Fatalf("%s: expected %q, but found %q", k, k, v)
+		}
+	}
+	for _, k := range []string{"a@2", "b@3", "bb@1", "f@1"} {
+		if v, err := d.Get([]byte(k)); err != db.ErrNotFound {
+			t.Fatalf("%s: expected not found, but found %q %v", k, v, err)
+		}
+	}
+}
+
+func TestSingleDelete(t *testing.T) {
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	get := func(key string) string {
+		v, err := d.Get([]byte(key))
+		if err == db.ErrNotFound {
+			return "<not found>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+
+	// Write a key to a lower level, and single delete it from the memtable.
+	if err := d.Set([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set([]byte("b"), []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SingleDelete([]byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("a"); v != "<not found>" {
+		t.Fatalf("expected a to be deleted, but found %q", v)
+	}
+	if v := get("b"); v != "2" {
+		t.Fatalf("expected b=2, but found %q", v)
+	}
+
+	// Compacting the tombstone into the level containing the SET removes
+	// both entries.
+	if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("a"); v != "<not found>" {
+		t.Fatalf("expected a to be deleted, but found %q", v)
+	}
+	m := d.Metrics()
+	if total := m.Total(); total.NumFiles != 1 {
+		t.Fatalf("expected 1 file, but found %d", total.NumFiles)
+	}
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys, " "); got != "b" {
+		t.Fatalf("expected b, but found %q", got)
+	}
+}
+
+func TestDeleteFilesInRange(t *testing.T) {
+	var deleted []uint64
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+		EventListener: &db.EventListener{
+			TableDeleted: func(info db.TableDeleteInfo) {
+				deleted = append(deleted, info.FileNum)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Write each pair of keys to a separate sstable. The sstables containing
+	// a-b and c-d are moved to L1, while the one containing e-f is left in L0.
+	for i, keys := range [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}} {
+		for _, key := range keys {
+			if err := d.Set([]byte(key), []byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if err := d.Compact([]byte(keys[0]), []byte(keys[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	keys := func() string {
+		iter := d.NewIter(nil)
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(keys, " ")
+	}
+	files := func() string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return strings.TrimSpace(d.mu.versions.currentVersion().DebugString())
+	}
+	deleteFilesInRange := func(start, end string) {
+		deleted = nil
+		if err := d.DeleteFilesInRange([]byte(start), []byte(end)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only sstables entirely contained by the range are deleted.
+	before := files()
+	deleteFilesInRange("b", "d")
+	if len(deleted) != 0 || files() != before {
+		t.Fatalf("expected no sstables to be deleted, but found %d\n%s", deleted, files())
+	}
+	deleteFilesInRange("c", "e")
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 sstable to be deleted, but found %d", deleted)
+	}
+	if v := keys(); v != "a b e f" {
+		t.Fatalf("expected a b e f, but found %q", v)
+	}
+
+	// sstables visible to a snapshot are not deleted.
+	snap := d.NewSnapshot()
+	deleteFilesInRange("a", "c")
+	if len(deleted) != 0 {
+		t.Fatalf("expected no sstables to be deleted, but found %d", deleted)
+	}
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deleteFilesInRange("a", "c")
+	if len(deleted) != 1 {
+		t.Fatalf("expected 1 sstable to be deleted, but found %d", deleted)
+	}
+
+	// sstables overlapping the memtable are not deleted.
+	if err := d.Set([]byte("e1"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	deleteFilesInRange("e", "g")
+	if len(deleted) != 0 {
+		t.Fatalf("expected no sstables to be deleted, but found %d", deleted)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	deleteFilesInRange("e", "g")
+	if len(deleted) != 2 {
+		t.Fatalf("expected 2 sstables to be deleted, but found %d", deleted)
+	}
+	if v := keys(); v != "" {
+		t.Fatalf("expected no keys, but found %q", v)
+	}
+}
+
 func TestIterLeak(t *testing.T) {
 	for _, leak := range []bool{true, false} {
 		t.Run(fmt.Sprintf("leak=%t", leak), func(t *testing.T) {
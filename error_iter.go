@@ -12,6 +12,9 @@ type errorIter struct {
 
 var _ internalIterator = (*errorIter)(nil)
 
+// emptyIter is an internalIterator which contains no entries.
+var emptyIter = &errorIter{err: nil}
+
 func newErrorIter(err error) *errorIter {
 	return &errorIter{err: err}
 }
@@ -20,6 +23,10 @@ func (c *errorIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return nil, nil
 }
 
+func (c *errorIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return nil, nil
+}
+
 func (c *errorIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	return nil, nil
 }
@@ -19,6 +19,7 @@ type getIter struct {
 	newIters     tableNewIters
 	snapshot     uint64
 	key          []byte
+	prefix       []byte
 	iter         internalIterator
 	rangeDelIter internalIterator
 	tombstone    rangedel.Tombstone
@@ -40,6 +41,10 @@ func (g *getIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	panic("pebble: SeekGE unimplemented")
 }
 
+func (g *getIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	panic("pebble: SeekPrefixGE unimplemented")
+}
+
 func (g *getIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	panic("pebble: SeekLT unimplemented")
 }
@@ -134,7 +139,7 @@ func (g *getIter) Next() (*db.InternalKey, []byte) {
 					return nil, nil
 				}
 				g.l0 = g.l0[:n-1]
-				g.iterKey, g.iterValue = g.iter.SeekGE(g.key)
+				g.iterKey, g.iterValue = g.iter.SeekPrefixGE(g.prefix, g.key)
 				continue
 			}
 			g.level++
@@ -152,7 +157,7 @@ func (g *getIter) Next() (*db.InternalKey, []byte) {
 		g.levelIter.initRangeDel(&g.rangeDelIter)
 		g.level++
 		g.iter = &g.levelIter
-		g.iterKey, g.iterValue = g.iter.SeekGE(g.key)
+		g.iterKey, g.iterValue = g.iter.SeekPrefixGE(g.prefix, g.key)
 	}
 }
 
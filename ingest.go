@@ -30,6 +30,12 @@ func ingestLoad1(opts *db.Options, path string, fileNum uint64) (*fileMetadata,
 	meta := &fileMetadata{}
 	meta.fileNum = fileNum
 	meta.size = uint64(stat.Size())
+	meta.creationTime = r.Properties.CreationTime
+	if meta.creationTime == 0 {
+		// The table was written without a creation time, so treat it as created
+		// when it is ingested.
+		meta.creationTime = uint64(opts.Clock.Now().Unix())
+	}
 	meta.smallest = db.InternalKey{}
 	meta.largest = db.InternalKey{}
 	smallestSet, largestSet := false, false
@@ -187,7 +193,10 @@ func ingestUpdateSeqNum(
 		m.largestSeqNum = seqNum
 
 		// TODO(peter): Update the global sequence number property. This is only
-		// necessary for compatibility with RocksDB.
+		// necessary for compatibility with RocksDB. The sstable is linked from
+		// the caller's file, so it must be copied rather than rewritten in
+		// place. Until then, Repair treats ingested sstables as older than any
+		// other data.
 	}
 	return nil
 }
@@ -242,6 +251,13 @@ func ingestTargetLevel(cmp db.Compare, v *version, meta *fileMetadata) int {
 // https://github.com/petermattis/pebble/issues/25 for an idea for how to fix
 // this hiccup.
 func (d *DB) Ingest(paths []string) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
+	if d.opts.EnableTTL {
+		return errTTLIngest
+	}
+
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
 	// the file number ordering to be out of alignment with sequence number
@@ -377,14 +393,21 @@ func (d *DB) ingestApply(meta []*fileMetadata) (*versionEdit, error) {
 	current := d.mu.versions.currentVersion()
 	for i := range meta {
 		// Determine the lowest level in the LSM for which the sstable doesn't
-		// overlap any existing files in the level.
+		// overlap any existing files in the level. The FIFO compaction style
+		// keeps all of the sstables in L0.
 		m := meta[i]
-		ve.newFiles[i].level = ingestTargetLevel(d.cmp, current, m)
+		if d.opts.CompactionStyle != db.CompactionStyleFIFO {
+			ve.newFiles[i].level = ingestTargetLevel(d.cmp, current, m)
+		}
 		ve.newFiles[i].meta = *m
 	}
 	if err := d.mu.versions.logAndApply(ve); err != nil {
 		return nil, err
 	}
+	for i := range ve.newFiles {
+		e := &ve.newFiles[i]
+		d.mu.versions.metrics.Levels[e.level].BytesIngested += e.meta.size
+	}
 	d.updateReadStateLocked()
 	return ve, nil
 }
@@ -124,6 +124,7 @@ func TestIngestLoadRand(t *testing.T) {
 				t.Fatal(err)
 			}
 			expected[i].size = meta.Size
+			expected[i].creationTime = meta.CreationTime
 		}()
 	}
 
@@ -32,6 +32,15 @@ type internalIterator interface {
 	// is pointing at a valid entry, and (nil, nil) otherwise.
 	SeekGE(key []byte) (*db.InternalKey, []byte)
 
+	// SeekPrefixGE moves the iterator to the first key/value pair whose key is
+	// greater than or equal to the given key and which has the given prefix,
+	// as returned by Comparer.Split. Iterators which have a filter for the
+	// prefix may use it to avoid the seek when the prefix is not present, in
+	// which case the iterator is exhausted. Otherwise SeekPrefixGE is
+	// equivalent to SeekGE. Returns the key and value if the iterator is
+	// pointing at a valid entry, and (nil, nil) otherwise.
+	SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte)
+
 	// SeekLT moves the iterator to the last key/value pair whose key is less
 	// than the given key. Returns the key and value if the iterator is pointing
 	// at a valid entry, and (nil, nil) otherwise.
@@ -52,6 +52,12 @@ func (i *Iter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return &t.Start, t.End
 }
 
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package.
+func (i *Iter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return i.SeekGE(key)
+}
+
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package.
 func (i *Iter) SeekLT(key []byte) (*db.InternalKey, []byte) {
@@ -40,7 +40,9 @@ func SeekGE(cmp db.Compare, iter iterator, key []byte, snapshot uint64) Tombston
 		// The current tombstones contains or is past the search key, but SeekLT
 		// returns the oldest entry for a key, so backup until we hit the previous
 		// tombstone or an entry which is not visible.
-		for savedKey := iterKey.UserKey; ; {
+		// NB: savedKey is copied as the iterator may reuse the memory backing
+		// iterKey.
+		for savedKey := append([]byte(nil), iterKey.UserKey...); ; {
 			iterKey, iterValue = iter.Prev()
 			if iterKey == nil || cmp(savedKey, iterValue) >= 0 || !iterKey.Visible(snapshot) {
 				iterKey, iterValue = iter.Next()
@@ -168,7 +170,7 @@ func SeekLE(cmp db.Compare, iter iterator, key []byte, snapshot uint64) Tombston
 	// version. Walk backwards through the tombstones to find the newest one that
 	// is visible (i.e. has a sequence number less than the snapshot sequence
 	// number).
-	for savedKey := iterKey.UserKey; ; {
+	for savedKey := append([]byte(nil), iterKey.UserKey...); ; {
 		valid := iterKey.Visible(snapshot)
 		iterKey, iterValue = iter.Prev()
 		if iterKey == nil {
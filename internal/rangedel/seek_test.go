@@ -16,8 +16,11 @@ import (
 	"github.com/petermattis/pebble/internal/datadriven"
 )
 
+// iterAdapter verifies the results of the wrapped Iter. Like the sstable
+// iterators, it reuses the memory backing the returned key.
 type iterAdapter struct {
 	*Iter
+	key db.InternalKey
 }
 
 func (i *iterAdapter) verify(key *db.InternalKey, val []byte) (*db.InternalKey, []byte) {
@@ -25,15 +28,18 @@ func (i *iterAdapter) verify(key *db.InternalKey, val []byte) (*db.InternalKey,
 	if valid != i.Valid() {
 		panic(fmt.Sprintf("inconsistent valid: %t != %t", valid, i.Valid()))
 	}
-	if valid {
-		if db.InternalCompare(bytes.Compare, *key, *i.Key()) != 0 {
-			panic(fmt.Sprintf("inconsistent key: %s != %s", *key, i.Key()))
-		}
-		if !bytes.Equal(val, i.Value()) {
-			panic(fmt.Sprintf("inconsistent value: [% x] != [% x]", val, i.Value()))
-		}
+	if !valid {
+		return nil, nil
+	}
+	if db.InternalCompare(bytes.Compare, *key, *i.Key()) != 0 {
+		panic(fmt.Sprintf("inconsistent key: %s != %s", *key, i.Key()))
+	}
+	if !bytes.Equal(val, i.Value()) {
+		panic(fmt.Sprintf("inconsistent value: [% x] != [% x]", val, i.Value()))
 	}
-	return key, val
+	i.key.UserKey = append(i.key.UserKey[:0], key.UserKey...)
+	i.key.Trailer = key.Trailer
+	return &i.key, val
 }
 
 func (i *iterAdapter) SeekGE(key []byte) (*db.InternalKey, []byte) {
@@ -9,7 +9,8 @@ import (
 )
 
 // Truncate creates a new iterator where every tombstone in the supplied
-// iterator is truncated to be contained within the range [lower, upper).
+// iterator is truncated to be contained within the range [lower, upper). A
+// nil lower or upper bound leaves that side of the range unbounded.
 func Truncate(cmp db.Compare, iter iterator, lower, upper []byte) *Iter {
 	var tombstones []Tombstone
 	for key, value := iter.First(); key != nil; key, value = iter.Next() {
@@ -17,10 +18,10 @@ func Truncate(cmp db.Compare, iter iterator, lower, upper []byte) *Iter {
 			Start: *key,
 			End:   value,
 		}
-		if cmp(t.Start.UserKey, lower) < 0 {
+		if lower != nil && cmp(t.Start.UserKey, lower) < 0 {
 			t.Start.UserKey = lower
 		}
-		if cmp(t.End, upper) > 0 {
+		if upper != nil && cmp(t.End, upper) > 0 {
 			t.End = upper
 		}
 		if cmp(t.Start.UserKey, t.End) < 0 {
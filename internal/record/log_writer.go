@@ -276,6 +276,13 @@ func (w *LogWriter) Sync() error {
 	return nil
 }
 
+// Size returns the current size of the log, including data which has been
+// written but not yet flushed. Must not be called concurrently with
+// WriteRecord.
+func (w *LogWriter) Size() int64 {
+	return w.blockNum*blockSize + int64(atomic.LoadInt32(&w.block.written))
+}
+
 // WriteRecord writes a complete record. Returns the offset just past the end
 // of the record.
 func (w *LogWriter) WriteRecord(p []byte) (int64, error) {
@@ -140,8 +140,20 @@ var (
 
 	// ErrZeroedChunk is returned if a chunk is encountered that is zeroed.
 	ErrZeroedChunk = errors.New("pebble/record: zeroed chunk")
+
+	errInvalidChunkHeader   = errors.New("pebble/record: invalid chunk (header overflows block)")
+	errInvalidChunkLength   = errors.New("pebble/record: invalid chunk (length overflows block)")
+	errInvalidChunkChecksum = errors.New("pebble/record: invalid chunk (checksum mismatch)")
 )
 
+// IsInvalidChunk returns true if the error was returned because an invalid
+// chunk was encountered, such as a chunk whose checksum does not match its
+// contents.
+func IsInvalidChunk(err error) bool {
+	return err == errInvalidChunkHeader || err == errInvalidChunkLength ||
+		err == errInvalidChunkChecksum
+}
+
 // Reader reads records from an underlying io.Reader.
 type Reader struct {
 	// r is the underlying reader.
@@ -157,6 +169,9 @@ type Reader struct {
 	// n is the number of bytes of buf that are valid. Once reading has started,
 	// only the final block can have n < blockSize.
 	n int
+	// blockNum is the index of the block held in buf, or -1 if no block has
+	// been read.
+	blockNum int64
 	// started is whether Next has been called at all.
 	started bool
 	// recovering is true when recovering from corruption.
@@ -174,8 +189,9 @@ type Reader struct {
 // match the specifed logNum.
 func NewReader(r io.Reader, logNum uint64) *Reader {
 	return &Reader{
-		r:      r,
-		logNum: uint32(logNum),
+		r:        r,
+		logNum:   uint32(logNum),
+		blockNum: -1,
 	}
 }
 
@@ -211,7 +227,7 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 			if chunkType >= recyclableFullChunkType && chunkType <= recyclableLastChunkType {
 				headerSize = recyclableHeaderSize
 				if r.end+headerSize > r.n {
-					return errors.New("pebble/record: invalid chunk (header overflows block)")
+					return errInvalidChunkHeader
 				}
 
 				logNum := binary.LittleEndian.Uint32(r.buf[r.end+7 : r.end+11])
@@ -230,14 +246,14 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 					r.recover()
 					continue
 				}
-				return errors.New("pebble/record: invalid chunk (length overflows block)")
+				return errInvalidChunkLength
 			}
 			if checksum != crc.New(r.buf[r.begin-headerSize+6:r.end]).Value() {
 				if r.recovering {
 					r.recover()
 					continue
 				}
-				return errors.New("pebble/record: invalid chunk (checksum mismatch)")
+				return errInvalidChunkChecksum
 			}
 			if wantFirst {
 				if chunkType != fullChunkType && chunkType != firstChunkType {
@@ -259,6 +275,7 @@ func (r *Reader) nextChunk(wantFirst bool) error {
 			return err
 		}
 		r.begin, r.end, r.n = 0, 0, n
+		r.blockNum++
 	}
 }
 
@@ -296,7 +313,7 @@ func (r *Reader) recover() {
 	r.seq++
 }
 
-// seekRecord seeks in the underlying io.Reader such that calling r.Next
+// SeekRecord seeks in the underlying io.Reader such that calling r.Next
 // returns the record whose first chunk header starts at the provided offset.
 // Its behavior is undefined if the argument given is not such an offset, as
 // the bytes at that offset may coincidentally appear to be a valid header.
@@ -304,17 +321,17 @@ func (r *Reader) recover() {
 // It returns ErrNotAnIOSeeker if the underlying io.Reader does not implement
 // io.Seeker.
 //
-// seekRecord will fail and return an error if the Reader previously
+// SeekRecord will fail and return an error if the Reader previously
 // encountered an error, including io.EOF. Such errors can be cleared by
-// calling Recover. Calling seekRecord after Recover will make calling Next
+// calling Recover. Calling SeekRecord after Recover will make calling Next
 // return the record at the given offset, instead of the record at the next
-// good 32KiB block as Recover normally would. Calling seekRecord before
+// good 32KiB block as Recover normally would. Calling SeekRecord before
 // Recover has no effect on Recover's semantics other than changing the
 // starting point for determining the next good 32KiB block.
 //
 // The offset is always relative to the start of the underlying io.Reader, so
 // negative values will result in an error as per io.Seeker.
-func (r *Reader) seekRecord(offset int64) error {
+func (r *Reader) SeekRecord(offset int64) error {
 	r.seq++
 	if r.err != nil {
 		return r.err
@@ -333,6 +350,7 @@ func (r *Reader) seekRecord(offset int64) error {
 
 	// Clear the state of the internal reader.
 	r.begin, r.end, r.n = 0, 0, 0
+	r.blockNum = offset/blockSize - 1
 	r.started, r.recovering, r.last = false, false, false
 	if r.err = r.nextChunk(false); r.err != nil {
 		return r.err
@@ -345,6 +363,18 @@ func (r *Reader) seekRecord(offset int64) error {
 	return nil
 }
 
+// Offset returns the offset in the underlying io.Reader just past the last
+// chunk that was read. Once the record most recently returned by Next has been
+// read in its entirety, this is the offset at which the next record starts,
+// and which can be passed to SeekRecord in order to resume reading from a new
+// Reader.
+func (r *Reader) Offset() int64 {
+	if r.blockNum < 0 {
+		return 0
+	}
+	return r.blockNum*blockSize + int64(r.end)
+}
+
 type singleReader struct {
 	r   *Reader
 	seq int
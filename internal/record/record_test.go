@@ -653,7 +653,7 @@ func TestSeekRecord(t *testing.T) {
 	r := NewReader(bytes.NewReader(recs.buf), 0 /* logNum */)
 	// Seek to a valid block offset, but within a multiblock record. This should cause the next call to
 	// Next after SeekRecord to return the next valid FIRST/FULL chunk of the subsequent record.
-	err = r.seekRecord(blockSize)
+	err = r.SeekRecord(blockSize)
 	if err != nil {
 		t.Fatalf("SeekRecord: %v", err)
 	}
@@ -668,7 +668,7 @@ func TestSeekRecord(t *testing.T) {
 
 	// Seek 3 bytes into the second block, which is still in the middle of the first record, but not
 	// at a valid chunk boundary. Should result in an error upon calling r.Next.
-	err = r.seekRecord(blockSize + 3)
+	err = r.SeekRecord(blockSize + 3)
 	if err != nil {
 		t.Fatalf("SeekRecord: %v", err)
 	}
@@ -678,7 +678,7 @@ func TestSeekRecord(t *testing.T) {
 	r.recover()
 
 	// Seek to the fifth block and verify all records can be read as appropriate.
-	err = r.seekRecord(blockSize * 4)
+	err = r.SeekRecord(blockSize * 4)
 	if err != nil {
 		t.Fatalf("SeekRecord: %v", err)
 	}
@@ -699,14 +699,14 @@ func TestSeekRecord(t *testing.T) {
 	check(2)
 
 	// Seek back to the fourth block, and read all subsequent records and verify them.
-	err = r.seekRecord(blockSize * 3)
+	err = r.SeekRecord(blockSize * 3)
 	if err != nil {
 		t.Fatalf("SeekRecord: %v", err)
 	}
 	check(1)
 
 	// Now seek past the end of the file and verify it causes an error.
-	err = r.seekRecord(1 << 20)
+	err = r.SeekRecord(1 << 20)
 	if err == nil {
 		t.Fatalf("Seek past the end of a file didn't cause an error")
 	}
@@ -716,7 +716,7 @@ func TestSeekRecord(t *testing.T) {
 	r.recover() // Verify recovery works.
 
 	// Validate the current records are returned after seeking to a valid offset.
-	err = r.seekRecord(blockSize * 4)
+	err = r.SeekRecord(blockSize * 4)
 	if err != nil {
 		t.Fatalf("SeekRecord: %v", err)
 	}
@@ -748,6 +748,60 @@ func TestLastRecordOffset(t *testing.T) {
 	}
 }
 
+func TestReaderOffset(t *testing.T) {
+	recs, err := makeTestRecords(
+		blockSize*3,
+		3*(blockSize-legacyHeaderSize)-2*blockSize-2*legacyHeaderSize,
+		blockSize-legacyHeaderSize,
+		blockSize/2,
+	)
+	if err != nil {
+		t.Fatalf("makeTestRecords: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(recs.buf), 0 /* logNum */)
+	if off := r.Offset(); off != 0 {
+		t.Fatalf("Offset: got %d, want 0", off)
+	}
+	for i := range recs.records {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if _, err := ioutil.ReadAll(rec); err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		want := int64(len(recs.buf))
+		if i+1 < len(recs.offsets) {
+			want = recs.offsets[i+1]
+		}
+		off := r.Offset()
+		if off != want {
+			t.Fatalf("record #%d: got offset %d, want %d", i, off, want)
+		}
+
+		// A new Reader resumes reading at the offset.
+		r2 := NewReader(bytes.NewReader(recs.buf), 0 /* logNum */)
+		if err := r2.SeekRecord(off); err != nil && err != io.EOF {
+			t.Fatalf("SeekRecord: %v", err)
+		}
+		rec, err = r2.Next()
+		if i+1 == len(recs.records) {
+			if err != io.EOF {
+				t.Fatalf("Next: got %v, want EOF", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		rData, _ := ioutil.ReadAll(rec)
+		if !bytes.Equal(rData, recs.records[i+1]) {
+			t.Fatalf("record #%d: unexpected data after seeking to %d", i+1, off)
+		}
+	}
+}
+
 func TestNoLastRecordOffset(t *testing.T) {
 	buf := new(bytes.Buffer)
 	w := NewWriter(buf)
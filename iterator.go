@@ -5,11 +5,14 @@
 package pebble
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/petermattis/pebble/db"
 )
 
+var errReversePrefixIteration = errors.New("pebble: unsupported reverse prefix iteration")
+
 type iterPos int8
 
 const (
@@ -18,6 +21,23 @@ const (
 	iterPosPrev iterPos = -1
 )
 
+// iterResume describes where Refresh resumes iteration once an iterator has
+// run off either end.
+type iterResume int8
+
+const (
+	// The iterator has not been positioned, and remains unpositioned.
+	resumeNone iterResume = iota
+	resumeFirst
+	resumeLast
+	// Resume at the first key greater than or equal to resumeKey.
+	resumeSeekGE
+	// Resume at the first key greater than resumeKey.
+	resumeSeekGT
+	// Resume at the last key less than resumeKey.
+	resumeSeekLT
+)
+
 // Iterator iterates over a DB's key/value pairs in key order.
 //
 // An iterator must be closed after use, but it is not necessary to read an
@@ -35,19 +55,34 @@ type Iterator struct {
 	cmp       db.Compare
 	equal     db.Equal
 	merge     db.Merge
+	split     db.Split
 	iter      internalIterator
 	readState *readState
+	db        *DB
+	batch     *Batch
+	snapshot  *Snapshot
 	err       error
 	key       []byte
 	keyBuf    []byte
 	value     []byte
 	valueBuf  []byte
 	valueBuf2 []byte
+	prefix    []byte
 	valid     bool
 	iterKey   *db.InternalKey
 	iterValue []byte
 	pos       iterPos
 	alloc     *iterAlloc
+	// In TTL mode, values are stored with an expiration time, and values which
+	// expired before ttlNow are hidden.
+	ttl    bool
+	ttlNow uint64
+	// Next and Prev move the current key to lastKeyBuf so that, if the iterator
+	// runs off the end, resume and resumeKey can record where Refresh resumes
+	// iteration.
+	lastKeyBuf []byte
+	resume     iterResume
+	resumeKey  []byte
 }
 
 func (i *Iterator) findNextEntry() bool {
@@ -56,8 +91,13 @@ func (i *Iterator) findNextEntry() bool {
 
 	for i.iterKey != nil {
 		key := *i.iterKey
+		if i.prefix != nil && !i.hasPrefix(key.UserKey) {
+			// We've stepped past the prefix being sought.
+			return false
+		}
+
 		switch key.Kind() {
-		case db.InternalKeyKindDelete:
+		case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 			i.nextUserKey()
 			continue
 
@@ -68,9 +108,20 @@ func (i *Iterator) findNextEntry() bool {
 			continue
 
 		case db.InternalKeyKindSet:
+			value := i.iterValue
+			if i.ttl {
+				var live bool
+				if value, live = i.ttlValue(value); !live {
+					if i.err != nil {
+						return false
+					}
+					i.nextUserKey()
+					continue
+				}
+			}
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
-			i.value = i.iterValue
+			i.value = value
 			i.valid = true
 			return true
 
@@ -86,6 +137,22 @@ func (i *Iterator) findNextEntry() bool {
 	return false
 }
 
+// hasPrefix returns true if the prefix of key, as determined by the split
+// function, is equal to the prefix being sought by SeekPrefixGE.
+func (i *Iterator) hasPrefix(key []byte) bool {
+	if i.split != nil {
+		key = key[:i.split(key)]
+	}
+	return i.equal(i.prefix, key)
+}
+
+// setResume records where Refresh resumes iteration when the iterator is not
+// valid after being positioned.
+func (i *Iterator) setResume(resume iterResume, key []byte) {
+	i.resume = resume
+	i.resumeKey = append(i.resumeKey[:0], key...)
+}
+
 func (i *Iterator) nextUserKey() {
 	if i.iterKey != nil {
 		done := i.iterKey.SeqNum() == 0
@@ -120,7 +187,7 @@ func (i *Iterator) findPrevEntry() bool {
 		}
 
 		switch key.Kind() {
-		case db.InternalKeyKindDelete:
+		case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 			i.value = nil
 			i.valid = false
 			i.iterKey, i.iterValue = i.iter.Prev()
@@ -133,9 +200,23 @@ func (i *Iterator) findPrevEntry() bool {
 			continue
 
 		case db.InternalKeyKindSet:
+			value := i.iterValue
+			if i.ttl {
+				var live bool
+				if value, live = i.ttlValue(value); !live {
+					if i.err != nil {
+						return false
+					}
+					// An expired value is treated as a deletion.
+					i.value = nil
+					i.valid = false
+					i.iterKey, i.iterValue = i.iter.Prev()
+					continue
+				}
+			}
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
-			i.value = i.iterValue
+			i.value = value
 			i.valid = true
 			i.iterKey, i.iterValue = i.iter.Prev()
 			continue
@@ -214,7 +295,7 @@ func (i *Iterator) mergeNext(key db.InternalKey) bool {
 			return true
 		}
 		switch key.Kind() {
-		case db.InternalKeyKindDelete:
+		case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 			// We've hit a deletion tombstone. Return everything up to this
 			// point.
 			return true
@@ -251,12 +332,56 @@ func (i *Iterator) SeekGE(key []byte) bool {
 		return false
 	}
 
+	i.prefix = nil
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
 		key = lowerBound
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekGE(key)
-	return i.findNextEntry()
+	if !i.findNextEntry() {
+		i.setResume(resumeSeekGE, key)
+		return false
+	}
+	return true
+}
+
+// SeekPrefixGE moves the iterator to the first key/value pair whose key is
+// greater than or equal to the given key and which has the same prefix as the
+// key, as determined by Comparer.Split (or the entire key if Split is
+// nil). Returns true if the iterator is pointing at a valid entry and false
+// otherwise.
+//
+// SeekPrefixGE places the iterator in prefix iteration mode: subsequent calls
+// to Next only return keys with the sought prefix, and the iterator becomes
+// invalid once the prefix is exhausted. Prefix iteration allows the use of
+// prefix bloom filters to skip sstables which do not contain the
+// prefix. Reverse iteration (Prev) is not supported in prefix iteration
+// mode. A call to SeekGE, SeekLT, First or Last ends prefix iteration mode.
+func (i *Iterator) SeekPrefixGE(key []byte) bool {
+	if i.err != nil {
+		return false
+	}
+
+	prefixLen := len(key)
+	if i.split != nil {
+		prefixLen = i.split(key)
+	}
+	i.prefix = append(i.prefix[:0], key[:prefixLen]...)
+	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil && i.cmp(key, lowerBound) < 0 {
+		if !i.hasPrefix(lowerBound) {
+			i.err = errors.New("pebble: SeekPrefixGE supplied with key outside of lower bound")
+			i.valid = false
+			return false
+		}
+		key = lowerBound
+	}
+
+	i.iterKey, i.iterValue = i.iter.SeekPrefixGE(i.prefix, key)
+	if !i.findNextEntry() {
+		i.setResume(resumeSeekGE, key)
+		return false
+	}
+	return true
 }
 
 // SeekLT moves the iterator to the last key/value pair whose key is less than
@@ -267,12 +392,17 @@ func (i *Iterator) SeekLT(key []byte) bool {
 		return false
 	}
 
+	i.prefix = nil
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) >= 0 {
 		key = upperBound
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekLT(key)
-	return i.findPrevEntry()
+	if !i.findPrevEntry() {
+		i.setResume(resumeSeekLT, key)
+		return false
+	}
+	return true
 }
 
 // First moves the iterator the the first key/value pair. Returns true if the
@@ -282,12 +412,17 @@ func (i *Iterator) First() bool {
 		return false
 	}
 
+	i.prefix = nil
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil {
 		i.iterKey, i.iterValue = i.iter.SeekGE(lowerBound)
 	} else {
 		i.iterKey, i.iterValue = i.iter.First()
 	}
-	return i.findNextEntry()
+	if !i.findNextEntry() {
+		i.setResume(resumeFirst, nil)
+		return false
+	}
+	return true
 }
 
 // Last moves the iterator the the last key/value pair. Returns true if the
@@ -297,12 +432,17 @@ func (i *Iterator) Last() bool {
 		return false
 	}
 
+	i.prefix = nil
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil {
 		i.iterKey, i.iterValue = i.iter.SeekLT(upperBound)
 	} else {
 		i.iterKey, i.iterValue = i.iter.Last()
 	}
-	return i.findPrevEntry()
+	if !i.findPrevEntry() {
+		i.setResume(resumeLast, nil)
+		return false
+	}
+	return true
 }
 
 // Next moves the iterator to the next key/value pair. Returns true if the
@@ -311,6 +451,10 @@ func (i *Iterator) Next() bool {
 	if i.err != nil {
 		return false
 	}
+	valid := i.valid
+	if valid {
+		i.keyBuf, i.lastKeyBuf = i.lastKeyBuf, i.keyBuf
+	}
 	switch i.pos {
 	case iterPosCur:
 		i.nextUserKey()
@@ -327,7 +471,13 @@ func (i *Iterator) Next() bool {
 		i.nextUserKey()
 	case iterPosNext:
 	}
-	return i.findNextEntry()
+	if !i.findNextEntry() {
+		if valid {
+			i.setResume(resumeSeekGT, i.lastKeyBuf)
+		}
+		return false
+	}
+	return true
 }
 
 // Prev moves the iterator to the previous key/value pair. Returns true if the
@@ -336,6 +486,15 @@ func (i *Iterator) Prev() bool {
 	if i.err != nil {
 		return false
 	}
+	if i.prefix != nil {
+		i.err = errReversePrefixIteration
+		i.valid = false
+		return false
+	}
+	valid := i.valid
+	if valid {
+		i.keyBuf, i.lastKeyBuf = i.lastKeyBuf, i.keyBuf
+	}
 	switch i.pos {
 	case iterPosCur:
 		i.prevUserKey()
@@ -352,7 +511,13 @@ func (i *Iterator) Prev() bool {
 		i.prevUserKey()
 	case iterPosPrev:
 	}
-	return i.findPrevEntry()
+	if !i.findPrevEntry() {
+		if valid {
+			i.setResume(resumeSeekLT, i.lastKeyBuf)
+		}
+		return false
+	}
+	return true
 }
 
 // Key returns the key of the current key/value pair, or nil if done. The
@@ -380,6 +545,86 @@ func (i *Iterator) Error() error {
 	return i.err
 }
 
+// Refresh updates the iterator to observe the current state of the DB, making
+// visible the writes committed after the iterator was created or last
+// refreshed. If no memtable has been flushed and no compaction has completed
+// in the meantime, the iterator retains its sstable iterators and only
+// recreates its memtable iterators. Otherwise, it is rebuilt from the current
+// memtables and sstables. An iterator created from a snapshot continues to
+// observe the snapshot, and an iterator created from an indexed batch
+// continues to observe the batch.
+//
+// Refresh preserves the position of the iterator. If the iterator is
+// positioned at a key, it remains positioned at that key, or at the next key
+// if that key has since been deleted, regardless of the direction of
+// iteration. If the iterator has run off the end, it resumes where it left
+// off: after the last key it was positioned at when iterating forward, before
+// it when iterating backward, or where it was last sought. This allows a
+// consumer to read to the end, refresh, and continue with the keys written
+// since. An iterator which has never been positioned remains unpositioned.
+func (i *Iterator) Refresh() error {
+	if i.err != nil {
+		return i.err
+	}
+	if i.db == nil {
+		return errors.New("pebble: iterator cannot be refreshed")
+	}
+
+	var key []byte
+	valid := i.valid
+	if valid {
+		key = append(key, i.key...)
+	} else {
+		key = append(key, i.resumeKey...)
+	}
+	prefix := i.prefix
+
+	i.valid = false
+	i.key = nil
+	i.value = nil
+	i.iterKey = nil
+	i.iterValue = nil
+	i.pos = iterPosCur
+	i.db.refreshIterInternal(i)
+	if i.err != nil {
+		return i.err
+	}
+
+	if valid {
+		if prefix != nil {
+			i.iterKey, i.iterValue = i.iter.SeekPrefixGE(prefix, key)
+		} else {
+			i.iterKey, i.iterValue = i.iter.SeekGE(key)
+		}
+		i.findNextEntry()
+		return i.err
+	}
+
+	switch i.resume {
+	case resumeFirst:
+		i.First()
+	case resumeLast:
+		i.Last()
+	case resumeSeekGE, resumeSeekGT:
+		resume := i.resume
+		if prefix != nil {
+			i.SeekPrefixGE(key)
+		} else {
+			i.SeekGE(key)
+		}
+		if resume == resumeSeekGT {
+			if !i.valid {
+				i.resume = resumeSeekGT
+			} else if i.equal(i.key, key) {
+				i.Next()
+			}
+		}
+	case resumeSeekLT:
+		i.SeekLT(key)
+	}
+	return i.err
+}
+
 // Close closes the iterator and returns any accumulated error. Exhausting
 // all the key/value pairs in a table is not considered to be an error.
 // It is valid to call Close multiple times. Other methods should not be
@@ -389,8 +634,10 @@ func (i *Iterator) Close() error {
 		i.readState.unref()
 		i.readState = nil
 	}
-	if err := i.iter.Close(); err != nil && i.err != nil {
-		i.err = err
+	if i.iter != nil {
+		if err := i.iter.Close(); err != nil && i.err != nil {
+			i.err = err
+		}
 	}
 	err := i.err
 	if alloc := i.alloc; alloc != nil {
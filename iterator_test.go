@@ -13,8 +13,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/petermattis/pebble/bloom"
 	"github.com/petermattis/pebble/db"
 	"github.com/petermattis/pebble/internal/datadriven"
+	"github.com/petermattis/pebble/vfs"
 	"golang.org/x/exp/rand"
 )
 
@@ -77,6 +79,10 @@ func (f *fakeIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return nil, nil
 }
 
+func (f *fakeIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return f.SeekGE(key)
+}
+
 func (f *fakeIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	f.valid = false
 	for f.index = len(f.keys) - 1; f.index >= 0; f.index-- {
@@ -288,6 +294,15 @@ func testIterator(
 	}
 }
 
+// testSplit splits keys of the form "<prefix>@<suffix>" at the "@". Keys
+// without an "@" are their own prefix.
+func testSplit(a []byte) int {
+	if i := bytes.IndexByte(a, '@'); i >= 0 {
+		return i
+	}
+	return len(a)
+}
+
 func TestIterator(t *testing.T) {
 	var keys []db.InternalKey
 	var vals [][]byte
@@ -308,6 +323,7 @@ func TestIterator(t *testing.T) {
 			cmp:   cmp,
 			equal: equal,
 			merge: db.DefaultMerger.Merge,
+			split: testSplit,
 			iter:  iter,
 		}
 	}
@@ -358,6 +374,277 @@ func TestIterator(t *testing.T) {
 	})
 }
 
+func TestIteratorSeekPrefixGE(t *testing.T) {
+	comparer := *db.DefaultComparer
+	comparer.Name = "prefix-comparer"
+	comparer.Split = testSplit
+	d, err := Open("", &db.Options{
+		Comparer: &comparer,
+		Levels:   []db.LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+		VFS:      vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	set := func(keys ...string) {
+		for _, k := range keys {
+			if err := d.Set([]byte(k), []byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Spread the versions of each prefix across the memtable, L0 and a lower
+	// level.
+	set("a@1", "b@1", "b@2", "d@1", "e@1")
+	if err := d.Compact([]byte("a"), []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	set("b@3", "c@1", "e@2")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	set("b@4", "f@1")
+	if err := d.Delete([]byte("b@2"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"a", "a@1"},
+		{"b", "b@1 b@3 b@4"},
+		{"b@2", "b@3 b@4"},
+		{"bb", ""},
+		{"c", "c@1"},
+		{"d@2", ""},
+		{"e", "e@1 e@2"},
+		{"f", "f@1"},
+		{"g", ""},
+	}
+	iter := d.NewIter(nil)
+	defer iter.Close()
+	for _, c := range testCases {
+		var keys []string
+		for valid := iter.SeekPrefixGE([]byte(c.key)); valid; valid = iter.Next() {
+			if string(iter.Key()) != string(iter.Value()) {
+				t.Fatalf("%s: unexpected value %q for key %q", c.key, iter.Value(), iter.Key())
+			}
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(keys, " "); got != c.expected {
+			t.Fatalf("%s: expected %q, but found %q", c.key, c.expected, got)
+		}
+	}
+}
+
+func TestIteratorTableFilter(t *testing.T) {
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	set := func(keys ...string) {
+		for _, k := range keys {
+			if err := d.Set([]byte(k), []byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Create one table in a lower level, one table in L0 and leave some keys
+	// in the memtable.
+	set("a", "b")
+	if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	set("c", "d")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	set("e")
+
+	for _, skip := range []bool{false, true} {
+		var calls int
+		iter := d.NewIter(&db.IterOptions{
+			TableFilter: func(userProps map[string]string) bool {
+				calls++
+				return !skip
+			},
+		})
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		expected := "a b c d e"
+		if skip {
+			expected = "e"
+		}
+		if got := strings.Join(keys, " "); got != expected {
+			t.Fatalf("skip=%t: expected %q, but found %q", skip, expected, got)
+		}
+		if calls != 2 {
+			t.Fatalf("skip=%t: expected 2 calls to the table filter, but found %d", skip, calls)
+		}
+	}
+}
+
+func TestIteratorRefresh(t *testing.T) {
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	set := func(keys ...string) {
+		for _, k := range keys {
+			if err := d.Set([]byte(k), []byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	scan := func(iter *Iterator) string {
+		var keys []string
+		for valid := iter.Valid(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(keys, " ")
+	}
+	refresh := func(iter *Iterator) {
+		if err := iter.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set("a", "c")
+	iter := d.NewIter(nil)
+	defer iter.Close()
+	snap := d.NewSnapshot()
+	defer snap.Close()
+	snapIter := snap.NewIter(nil)
+	defer snapIter.Close()
+
+	// Writes committed after the iterator was created are only visible after
+	// a refresh, which preserves the position of the iterator.
+	if !iter.First() {
+		t.Fatalf("expected iterator to be valid")
+	}
+	set("b", "d")
+	refresh(iter)
+	if v := string(iter.Key()); v != "a" {
+		t.Fatalf("expected a, but found %q", v)
+	}
+	if v := scan(iter); v != "a b c d" {
+		t.Fatalf("expected a b c d, but found %q", v)
+	}
+
+	// An exhausted iterator resumes after the last key it returned. Refreshing
+	// picks up writes that have been flushed and compacted in the meantime.
+	if err := d.Compact([]byte("a"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	set("e")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	set("f")
+	refresh(iter)
+	if v := scan(iter); v != "e f" {
+		t.Fatalf("expected e f, but found %q", v)
+	}
+
+	// An iterator which runs off the start resumes before the last key it
+	// returned, and one whose seek found nothing resumes at the sought key.
+	iter.First()
+	if iter.Prev() {
+		t.Fatalf("expected iterator to be exhausted")
+	}
+	set("0")
+	refresh(iter)
+	if v := string(iter.Key()); v != "0" {
+		t.Fatalf("expected 0, but found %q", v)
+	}
+	if iter.SeekGE([]byte("x")) {
+		t.Fatalf("expected iterator to be exhausted")
+	}
+	set("x")
+	refresh(iter)
+	if v := string(iter.Key()); v != "x" {
+		t.Fatalf("expected x, but found %q", v)
+	}
+
+	// An unpositioned iterator remains unpositioned.
+	unpositioned := d.NewIter(nil)
+	defer unpositioned.Close()
+	refresh(unpositioned)
+	if unpositioned.Valid() {
+		t.Fatalf("expected iterator to be unpositioned")
+	}
+
+	// A deleted key repositions the iterator at the next key.
+	iter.SeekGE([]byte("b"))
+	if err := d.Delete([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	refresh(iter)
+	if v := string(iter.Key()); v != "c" {
+		t.Fatalf("expected c, but found %q", v)
+	}
+
+	// Without an intervening flush or compaction, a refresh retains the
+	// sstable iterators, and observes new keys and range tombstones in the
+	// memtable.
+	m := iter.iter.(*mergingIter)
+	sstIter := m.iters[len(m.iters)-1]
+	set("g")
+	if err := d.DeleteRange([]byte("e"), []byte("f"), nil); err != nil {
+		t.Fatal(err)
+	}
+	refresh(iter)
+	if m.iters[len(m.iters)-1] != sstIter {
+		t.Fatalf("expected sstable iterators to be retained")
+	}
+	if v := scan(iter); v != "c d f g x" {
+		t.Fatalf("expected c d f g x, but found %q", v)
+	}
+
+	// An iterator created from a snapshot continues to observe the snapshot.
+	refresh(snapIter)
+	snapIter.First()
+	if v := scan(snapIter); v != "a c" {
+		t.Fatalf("expected a c, but found %q", v)
+	}
+}
+
 func BenchmarkIteratorSeekGE(b *testing.B) {
 	m, keys := buildMemTable(b)
 	iter := &Iterator{
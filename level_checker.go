@@ -0,0 +1,232 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/petermattis/pebble/db"
+)
+
+// CheckLevels verifies the invariants of the LSM tree of the current version
+// of the DB. In particular, it checks that:
+//
+//   - the L0 sstables are ordered by sequence number and the sstables in each
+//     of the other levels are sorted and non-overlapping;
+//   - the smallest and largest keys and sequence numbers of each sstable match
+//     its contents;
+//   - the range tombstones of each sstable are truncated to its bounds;
+//   - the sequence numbers of the entries for a user key decrease from the
+//     newer levels to the older levels;
+//   - the checksum of every block of every sstable verifies.
+//
+// CheckLevels reads every sstable in the current version, and can be called
+// while the DB is in use.
+func (d *DB) CheckLevels() error {
+	readState := d.loadReadState()
+	defer readState.unref()
+	return checkLevels(readState.current, d.cmp, d.newIters,
+		d.tableCache.validateBlockChecksums)
+}
+
+func checkLevels(
+	v *version,
+	cmp db.Compare,
+	newIters tableNewIters,
+	validateBlockChecksums func(meta *fileMetadata) error,
+) error {
+	if err := v.checkOrdering(cmp); err != nil {
+		return err
+	}
+	for level := range v.files {
+		for i := range v.files[level] {
+			f := &v.files[level][i]
+			if err := validateBlockChecksums(f); err != nil {
+				return fmt.Errorf("pebble: L%d table %06d: %v", level, f.fileNum, err)
+			}
+			// The sstables of L0 may overlap, so only the sstables of the other
+			// levels have neighbours which can hold the rest of a range tombstone.
+			var prev, next *fileMetadata
+			if level > 0 && i > 0 {
+				prev = &v.files[level][i-1]
+			}
+			if level > 0 && i+1 < len(v.files[level]) {
+				next = &v.files[level][i+1]
+			}
+			if err := checkTable(f, prev, next, cmp, newIters); err != nil {
+				return fmt.Errorf("pebble: L%d table %06d: %v", level, f.fileNum, err)
+			}
+		}
+	}
+	return checkSeqNumOrdering(v, cmp, newIters)
+}
+
+// checkTable verifies that the metadata of an sstable matches its contents.
+// Every point key must lie within the bounds of the sstable, and every range
+// tombstone must be truncated to them, unless the bound is shared with the
+// neighbouring sstable prev or next of the same level. The bounds must be
+// tight: the smallest and largest user keys of the contents are those of the
+// bounds.
+func checkTable(
+	f, prev, next *fileMetadata, cmp db.Compare, newIters tableNewIters,
+) (retErr error) {
+	if db.InternalCompare(cmp, f.smallest, f.largest) > 0 {
+		return fmt.Errorf("inconsistent bounds: %s, %s", f.smallest, f.largest)
+	}
+
+	iter, rangeDelIter, err := newIters(f, nil /* iter options */)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		retErr = firstError(retErr, iter.Close())
+		if rangeDelIter != nil {
+			retErr = firstError(retErr, rangeDelIter.Close())
+		}
+	}()
+
+	var (
+		smallest, largest             []byte
+		smallestSeqNum, largestSeqNum uint64
+		empty, emptyKeys              = true, true
+	)
+	updateKeys := func(start, end []byte) {
+		if emptyKeys || cmp(start, smallest) < 0 {
+			smallest = append(smallest[:0], start...)
+		}
+		if emptyKeys || cmp(end, largest) > 0 {
+			largest = append(largest[:0], end...)
+		}
+		emptyKeys = false
+	}
+	updateSeqNums := func(seqNum uint64) {
+		if empty || smallestSeqNum > seqNum {
+			smallestSeqNum = seqNum
+		}
+		if empty || largestSeqNum < seqNum {
+			largestSeqNum = seqNum
+		}
+		empty = false
+	}
+
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		if db.InternalCompare(cmp, *key, f.smallest) < 0 ||
+			db.InternalCompare(cmp, *key, f.largest) > 0 {
+			return fmt.Errorf("key %s lies outside of the bounds %s-%s",
+				key, f.smallest, f.largest)
+		}
+		updateKeys(key.UserKey, key.UserKey)
+		updateSeqNums(key.SeqNum())
+	}
+	if rangeDelIter != nil {
+		// A compaction which splits a range tombstone between two sstables does
+		// not rewrite the tombstone. Instead both sstables share a bound at the
+		// user key of the split point, and readers truncate the tombstone to
+		// the bounds of its sstable. A tombstone extending past any other bound
+		// would apply to keys outside of the sstable.
+		smallestIsSplit := prev != nil && cmp(prev.largest.UserKey, f.smallest.UserKey) == 0
+		largestIsSplit := next != nil && cmp(next.smallest.UserKey, f.largest.UserKey) == 0
+		for key, end := rangeDelIter.First(); key != nil; key, end = rangeDelIter.Next() {
+			if cmp(key.UserKey, end) >= 0 {
+				return fmt.Errorf("range tombstone %s-%s is empty", key, end)
+			}
+			updateSeqNums(key.SeqNum())
+			start := key.UserKey
+			if cmp(start, f.smallest.UserKey) < 0 {
+				if !smallestIsSplit {
+					return fmt.Errorf("range tombstone %s-%s is not truncated to the bounds %s-%s",
+						key, end, f.smallest, f.largest)
+				}
+				start = f.smallest.UserKey
+			}
+			if cmp(end, f.largest.UserKey) > 0 {
+				if !largestIsSplit {
+					return fmt.Errorf("range tombstone %s-%s is not truncated to the bounds %s-%s",
+						key, end, f.smallest, f.largest)
+				}
+				end = f.largest.UserKey
+			}
+			if cmp(start, end) < 0 {
+				updateKeys(start, end)
+			}
+		}
+	}
+	if emptyKeys {
+		return fmt.Errorf("table is empty")
+	}
+
+	if cmp(smallest, f.smallest.UserKey) != 0 || cmp(largest, f.largest.UserKey) != 0 {
+		return fmt.Errorf("bounds %s-%s do not match the contents %s-%s",
+			f.smallest, f.largest, smallest, largest)
+	}
+	if smallestSeqNum != f.smallestSeqNum || largestSeqNum != f.largestSeqNum {
+		return fmt.Errorf("sequence numbers %d-%d do not match the contents %d-%d",
+			f.smallestSeqNum, f.largestSeqNum, smallestSeqNum, largestSeqNum)
+	}
+	return nil
+}
+
+// checkSeqNumOrdering verifies that the entries for a user key have larger
+// sequence numbers in the newer levels. Each L0 sstable is a level of its own.
+// Only sequence numbers which were zeroed can be shared between levels.
+func checkSeqNumOrdering(v *version, cmp db.Compare, newIters tableNewIters) (retErr error) {
+	// The levels are ordered from newest to oldest.
+	var iters []internalIterator
+	var names []string
+	for i := len(v.files[0]) - 1; i >= 0; i-- {
+		f := v.files[0][i : i+1]
+		iters = append(iters, newLevelIter(nil, cmp, newIters, f))
+		names = append(names, fmt.Sprintf("L0 table %06d", f[0].fileNum))
+	}
+	for level := 1; level < len(v.files); level++ {
+		if len(v.files[level]) == 0 {
+			continue
+		}
+		iters = append(iters, newLevelIter(nil, cmp, newIters, v.files[level]))
+		names = append(names, fmt.Sprintf("L%d", level))
+	}
+	defer func() {
+		for _, iter := range iters {
+			retErr = firstError(retErr, iter.Close())
+		}
+	}()
+
+	keys := make([]*db.InternalKey, len(iters))
+	for i, iter := range iters {
+		keys[i], _ = iter.First()
+	}
+
+	var prev db.InternalKey
+	prevIndex := -1
+	for {
+		// Find the smallest key among the levels.
+		index := -1
+		for i, key := range keys {
+			if key != nil && (index == -1 || db.InternalCompare(cmp, *key, *keys[index]) < 0) {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil
+		}
+
+		key := keys[index]
+		if prevIndex != -1 && prevIndex != index && cmp(prev.UserKey, key.UserKey) == 0 {
+			if index < prevIndex {
+				return fmt.Errorf("pebble: key %s in %s is not newer than key %s in %s",
+					key, names[index], prev, names[prevIndex])
+			}
+			if key.SeqNum() == prev.SeqNum() && key.SeqNum() != 0 {
+				return fmt.Errorf("pebble: key %s in %s is not newer than key %s in %s",
+					prev, names[prevIndex], key, names[index])
+			}
+		}
+		prev.UserKey = append(prev.UserKey[:0], key.UserKey...)
+		prev.Trailer = key.Trailer
+		prevIndex = index
+
+		keys[index], _ = iters[index].Next()
+	}
+}
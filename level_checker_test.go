@@ -0,0 +1,160 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/sstable"
+	"github.com/petermattis/pebble/vfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestCheckLevels(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &db.Options{
+		VFS:          mem,
+		MemTableSize: 64 << 10,
+		Levels: []db.LevelOptions{{
+			TargetFileSize: 8 << 10,
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, mem.MkdirAll("ext", 0755))
+
+	ingest := func(keys ...string) {
+		f, err := mem.Create("ext/0")
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, nil, db.LevelOptions{})
+		for _, k := range keys {
+			key := db.MakeInternalKey([]byte(k), 0, db.InternalKeyKindSet)
+			require.NoError(t, w.Add(key, []byte("ingested")))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{"ext/0"}))
+	}
+
+	// The contents of an ingested sstable are assigned a global sequence
+	// number when they are read.
+	ingest("0100", "0200", "0300")
+	require.NoError(t, d.CheckLevels())
+
+	rng := rand.New(rand.NewSource(uint64(0)))
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 200; j++ {
+			key := []byte(fmt.Sprintf("%04d", rng.Intn(1000)))
+			switch rng.Intn(20) {
+			case 0:
+				require.NoError(t, d.Delete(key, nil))
+			case 1:
+				end := []byte(fmt.Sprintf("%04d", rng.Intn(1000)))
+				if string(end) > string(key) {
+					require.NoError(t, d.DeleteRange(key, end, nil))
+				}
+			default:
+				value := make([]byte, 100)
+				for k := range value {
+					value[k] = byte('a' + rng.Intn(26))
+				}
+				require.NoError(t, d.Set(key, value, nil))
+			}
+		}
+		if rng.Intn(2) == 0 {
+			require.NoError(t, d.Flush())
+		}
+		require.NoError(t, d.CheckLevels())
+	}
+
+	require.NoError(t, d.Close())
+}
+
+func TestCheckLevelsCorruption(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &db.Options{
+		VFS: mem,
+	})
+	require.NoError(t, err)
+
+	// L1: a#0 c#0
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c")))
+	// L0: [b,d)#2 a#3
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("d"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.CheckLevels())
+
+	check := func(modify func(files [numLevels][]fileMetadata) [numLevels][]fileMetadata) error {
+		d.mu.Lock()
+		current := d.mu.versions.currentVersion()
+		d.mu.Unlock()
+		var files [numLevels][]fileMetadata
+		for level := range files {
+			files[level] = append([]fileMetadata(nil), current.files[level]...)
+		}
+		v := &version{files: modify(files)}
+		return checkLevels(v, d.cmp, d.newIters, d.tableCache.validateBlockChecksums)
+	}
+	expectErr := func(err error, expected string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error containing %q, but found %v", expected, err)
+		}
+	}
+
+	// The bounds of a table do not match its contents.
+	err = check(func(files [numLevels][]fileMetadata) [numLevels][]fileMetadata {
+		files[1][0].largest = db.MakeInternalKey([]byte("d"), 0, db.InternalKeyKindSet)
+		return files
+	})
+	expectErr(err, "do not match the contents")
+
+	// A range tombstone extends past the bounds of its table.
+	err = check(func(files [numLevels][]fileMetadata) [numLevels][]fileMetadata {
+		files[0][0].largest = db.MakeRangeDeleteSentinelKey([]byte("c"))
+		return files
+	})
+	expectErr(err, "is not truncated")
+
+	// The sequence numbers of a table do not match its contents.
+	err = check(func(files [numLevels][]fileMetadata) [numLevels][]fileMetadata {
+		files[0][1].largestSeqNum++
+		return files
+	})
+	expectErr(err, "sequence numbers")
+
+	// The newer version of "a" is in an older level.
+	err = check(func(files [numLevels][]fileMetadata) [numLevels][]fileMetadata {
+		files[0], files[1] = files[1], files[0][1:]
+		return files
+	})
+	expectErr(err, "is not newer than")
+
+	// Corrupt the first byte of the L1 table.
+	fileNum := d.mu.versions.currentVersion().files[1][0].fileNum
+	filename := dbFilename("", fileTypeTable, fileNum)
+	f, err := mem.Open(filename)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[0] ^= 0xff
+	f, err = mem.Create(filename)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	d.tableCache.evict(fileNum)
+	expectErr(d.CheckLevels(), "checksum mismatch")
+
+	require.NoError(t, d.Close())
+}
@@ -170,12 +170,14 @@ func (l *levelIter) loadFile(index, dir int) bool {
 		}
 
 		var opts *db.IterOptions
-		if lowerBound != nil || upperBound != nil {
+		tableFilter := l.opts.GetTableFilter()
+		if lowerBound != nil || upperBound != nil || tableFilter != nil {
 			if l.tableOpts == nil {
 				l.tableOpts = &db.IterOptions{}
 			}
 			l.tableOpts.LowerBound = lowerBound
 			l.tableOpts.UpperBound = upperBound
+			l.tableOpts.TableFilter = tableFilter
 			opts = l.tableOpts
 		}
 
@@ -206,6 +208,35 @@ func (l *levelIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return l.skipEmptyFileForward()
 }
 
+func (l *levelIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	// NB: the top-level Iterator has already adjusted key based on
+	// IterOptions.LowerBound.
+	if !l.loadFile(l.findFileGE(key), 1) {
+		return nil, nil
+	}
+	if key, val := l.iter.SeekPrefixGE(prefix, key); key != nil {
+		return key, val
+	}
+	// When SeekPrefixGE returns nil we have not necessarily reached the end of
+	// the sstable, but we know that no key with the prefix exists at or after
+	// key in the sstable. The sstable's largest key is >= key, and keys sharing
+	// a prefix are contiguous, so the subsequent sstables in the level cannot
+	// contain the prefix either and there is no need to load them. If the
+	// largest key is a range deletion boundary, return it so that the
+	// tombstones in the sstable remain visible.
+	if l.rangeDelIter != nil {
+		if f := &l.files[l.index]; f.largest.Kind() == db.InternalKeyKindRangeDelete {
+			l.boundary = &f.largest
+			if l.err = l.iter.Close(); l.err != nil {
+				return nil, nil
+			}
+			l.iter = nil
+			return l.boundary, nil
+		}
+	}
+	return nil, nil
+}
+
 func (l *levelIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
 	// NB: the top-level Iterator has already adjusted key based on
 	// IterOptions.UpperBound.
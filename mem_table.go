@@ -54,6 +54,9 @@ type memTable struct {
 	flushedCh   chan struct{}
 	tombstones  rangeTombstoneCache
 	logNum      uint64
+	// logSize is the number of bytes written to the memtable's WAL. Only set
+	// once the memtable has become immutable.
+	logSize uint64
 }
 
 // newMemTable returns a new MemTable.
@@ -99,6 +102,10 @@ func (m *memTable) logNumber() uint64 {
 	return m.logNum
 }
 
+func (m *memTable) totalBytes() uint64 {
+	return uint64(m.skl.Size())
+}
+
 // Get gets the value for the given key. It returns ErrNotFound if the DB does
 // not contain the key.
 func (m *memTable) get(key []byte) (value []byte, err error) {
@@ -110,7 +117,8 @@ func (m *memTable) get(key []byte) (value []byte, err error) {
 	if !m.equal(key, ikey.UserKey) {
 		return nil, db.ErrNotFound
 	}
-	if ikey.Kind() == db.InternalKeyKindDelete {
+	switch ikey.Kind() {
+	case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 		return nil, db.ErrNotFound
 	}
 	return val, nil
@@ -154,7 +162,9 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 		case db.InternalKeyKindRangeDelete:
 			err = m.rangeDelSkl.Add(ikey, value)
 			tombstoneCount++
-		case db.InternalKeyKindLogData:
+		case db.InternalKeyKindLogData, db.InternalKeyKindBeginPrepareXID,
+			db.InternalKeyKindEndPrepareXID, db.InternalKeyKindCommitXID,
+			db.InternalKeyKindRollbackXID:
 		default:
 			err = ins.Add(&m.skl, ikey, value)
 		}
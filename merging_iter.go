@@ -394,7 +394,7 @@ func (m *mergingIter) isNextEntryDeleted(item *mergingIterItem) bool {
 		}
 		if tombstone.Contains(m.heap.cmp, item.key.UserKey) {
 			if level < item.index {
-				m.seekGE(tombstone.End, item.index)
+				m.seekGE(tombstone.End, item.index, nil /* prefix */)
 				return true
 			}
 			if tombstone.Deletes(item.key.SeqNum()) {
@@ -488,7 +488,7 @@ func (m *mergingIter) findPrevEntry() (*db.InternalKey, []byte) {
 	return nil, nil
 }
 
-func (m *mergingIter) seekGE(key []byte, level int) {
+func (m *mergingIter) seekGE(key []byte, level int, prefix []byte) {
 	// When seeking, we can use tombstones to adjust the key we seek to on each
 	// level. Consider the series of range tombstones:
 	//
@@ -512,7 +512,11 @@ func (m *mergingIter) seekGE(key []byte, level int) {
 
 	for ; level < len(m.iters); level++ {
 		iter := m.iters[level]
-		iter.SeekGE(key)
+		if prefix != nil {
+			iter.SeekPrefixGE(prefix, key)
+		} else {
+			iter.SeekGE(key)
+		}
 
 		if m.rangeDelIters != nil {
 			if rangeDelIter := m.rangeDelIters[level]; rangeDelIter != nil {
@@ -535,7 +539,12 @@ func (m *mergingIter) seekGE(key []byte, level int) {
 }
 
 func (m *mergingIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
-	m.seekGE(key, 0 /* start level */)
+	m.seekGE(key, 0 /* start level */, nil /* prefix */)
+	return m.findNextEntry()
+}
+
+func (m *mergingIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	m.seekGE(key, 0 /* start level */, prefix)
 	return m.findNextEntry()
 }
 
@@ -0,0 +1,223 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/petermattis/pebble/cache"
+)
+
+// LevelMetrics holds per-level metrics such as the number of files and total
+// size of the files, and compaction related metrics.
+type LevelMetrics struct {
+	// The total number of files in the level.
+	NumFiles int64
+	// The total size in bytes of the files in the level.
+	Size uint64
+	// The level's compaction score.
+	Score float64
+	// The number of incoming bytes from other levels read during
+	// compactions. This excludes bytes moved and bytes ingested. For L0 this is
+	// the bytes written to the WAL.
+	BytesIn uint64
+	// The number of bytes ingested.
+	BytesIngested uint64
+	// The number of bytes moved into the level by a "move" compaction.
+	BytesMoved uint64
+	// The number of bytes read for compactions into the level. This includes
+	// bytes read from other levels (BytesIn), as well as bytes read for the
+	// level.
+	BytesRead uint64
+	// The number of bytes written to the level by flushes and compactions.
+	BytesWritten uint64
+}
+
+// Add updates the counter metrics for the level.
+func (m *LevelMetrics) Add(u *LevelMetrics) {
+	m.NumFiles += u.NumFiles
+	m.Size += u.Size
+	m.BytesIn += u.BytesIn
+	m.BytesIngested += u.BytesIngested
+	m.BytesMoved += u.BytesMoved
+	m.BytesRead += u.BytesRead
+	m.BytesWritten += u.BytesWritten
+}
+
+// WriteAmp computes the write amplification for compactions at this
+// level. Computed as BytesWritten / BytesIn.
+func (m *LevelMetrics) WriteAmp() float64 {
+	if m.BytesIn == 0 {
+		return 0
+	}
+	return float64(m.BytesWritten) / float64(m.BytesIn)
+}
+
+// Metrics holds metrics for various subsystems of the DB such as the block
+// cache, compactions, flushes, the LSM levels, memtables and the WAL.
+type Metrics struct {
+	BlockCache cache.Metrics
+
+	Compact struct {
+		// The total number of compactions.
+		Count int64
+		// The total time spent in compactions.
+		Duration time.Duration
+	}
+
+	Flush struct {
+		// The total number of flushes.
+		Count int64
+		// The total time spent in flushes.
+		Duration time.Duration
+	}
+
+	Levels [numLevels]LevelMetrics
+
+	MemTable struct {
+		// The number of bytes allocated by memtables and large (flushable)
+		// batches.
+		Size uint64
+		// The count of memtables.
+		Count int64
+	}
+
+	TableCache cache.Metrics
+
+	WAL struct {
+		// Number of live WAL files.
+		Files int64
+		// Size of the live data in the WAL files. Note that with WAL file
+		// recycling this is less than the actual on-disk size of the WAL files.
+		Size uint64
+		// Number of bytes written to the WAL.
+		BytesIn uint64
+	}
+}
+
+// Total returns the sum of the per-level metrics and WAL metrics.
+func (m *Metrics) Total() LevelMetrics {
+	var total LevelMetrics
+	for level := 0; level < numLevels; level++ {
+		l := &m.Levels[level]
+		total.Add(l)
+	}
+	// Compute total bytes-in as the bytes written to the WAL + bytes ingested.
+	total.BytesIn = m.WAL.BytesIn + total.BytesIngested
+	// Add the total bytes-in to the total bytes-written. This is to account for
+	// the bytes written to the log and bytes written externally and then
+	// ingested.
+	total.BytesWritten += total.BytesIn
+	return total
+}
+
+// String pretty-prints the metrics, showing a line for the WAL, a line
+// per-level, a total, and lines for flushes, compactions, memtables and the
+// caches:
+//
+//	level__files____size___score______in__ingest____move____read___write___w-amp
+//	  WAL      1   1.1 K       -   1.1 K       -       -       -   1.1 K       -
+//	    0      1   797 B    0.25   1.1 K     0 B     0 B     0 B   797 B     0.7
+//	    1      0     0 B    0.00     0 B     0 B     0 B     0 B     0 B     0.0
+//	  ...
+//	total      1   797 B       -   1.1 K     0 B     0 B     0 B   1.9 K     1.7
+//	  flush      1   1.2ms
+//	compact      0      0s
+//	 memtbl      1   256 K
+//	 bcache      4   1.1 K   42.0%  (score == hit-rate)
+//	 tcache      1     0 B   50.0%  (score == hit-rate)
+func (m *Metrics) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "level__files____size___score______in__ingest____move____read___write___w-amp\n")
+	fmt.Fprintf(&buf, "  WAL %6d %7s       - %7s       -       -       - %7s       -\n",
+		m.WAL.Files, humanize(m.WAL.Size), humanize(m.WAL.BytesIn), humanize(m.WAL.BytesIn))
+	for level := 0; level < numLevels; level++ {
+		l := &m.Levels[level]
+		fmt.Fprintf(&buf, "%5d %6d %7s %7.2f %7s %7s %7s %7s %7s %7.1f\n",
+			level, l.NumFiles, humanize(l.Size), l.Score, humanize(l.BytesIn),
+			humanize(l.BytesIngested), humanize(l.BytesMoved), humanize(l.BytesRead),
+			humanize(l.BytesWritten), l.WriteAmp())
+	}
+	total := m.Total()
+	fmt.Fprintf(&buf, "total %6d %7s       - %7s %7s %7s %7s %7s %7.1f\n",
+		total.NumFiles, humanize(total.Size), humanize(total.BytesIn),
+		humanize(total.BytesIngested), humanize(total.BytesMoved),
+		humanize(total.BytesRead), humanize(total.BytesWritten), total.WriteAmp())
+	fmt.Fprintf(&buf, "  flush %6d %7s\n", m.Flush.Count, m.Flush.Duration)
+	fmt.Fprintf(&buf, "compact %6d %7s\n", m.Compact.Count, m.Compact.Duration)
+	fmt.Fprintf(&buf, " memtbl %6d %7s\n", m.MemTable.Count, humanize(m.MemTable.Size))
+	fmt.Fprintf(&buf, " bcache %6d %7s %6.1f%%  (score == hit-rate)\n",
+		m.BlockCache.Count, humanize(uint64(m.BlockCache.Size)),
+		hitRate(m.BlockCache.Hits, m.BlockCache.Misses))
+	fmt.Fprintf(&buf, " tcache %6d %7s %6.1f%%  (score == hit-rate)\n",
+		m.TableCache.Count, humanize(uint64(m.TableCache.Size)),
+		hitRate(m.TableCache.Hits, m.TableCache.Misses))
+	return buf.String()
+}
+
+func hitRate(hits, misses int64) float64 {
+	sum := hits + misses
+	if sum == 0 {
+		return 0
+	}
+	return 100 * float64(hits) / float64(sum)
+}
+
+// humanize returns a human readable version of a byte count, using the
+// largest unit (B, K, M, G, T) for which the value is at least 1.
+func humanize(n uint64) string {
+	const units = "BKMGT"
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %c", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %c", v, units[i])
+}
+
+// Metrics returns metrics about the database.
+func (d *DB) Metrics() *Metrics {
+	metrics := &Metrics{}
+
+	d.mu.Lock()
+	*metrics = d.mu.versions.metrics
+	current := d.mu.versions.currentVersion()
+	picker := d.mu.versions.picker
+	for level := 0; level < numLevels; level++ {
+		l := &metrics.Levels[level]
+		l.NumFiles = int64(len(current.files[level]))
+		l.Size = totalSize(current.files[level])
+		if picker != nil {
+			l.Score = picker.scores[level]
+		}
+	}
+	metrics.MemTable.Count = int64(len(d.mu.mem.queue))
+	for _, mem := range d.mu.mem.queue {
+		metrics.MemTable.Size += mem.totalBytes()
+		if m, ok := mem.(*memTable); ok && m != d.mu.mem.mutable {
+			metrics.WAL.Size += m.logSize
+		}
+	}
+	for _, fileNum := range d.mu.log.queue {
+		if fileNum >= d.mu.versions.logNumber {
+			metrics.WAL.Files++
+		}
+	}
+	d.mu.Unlock()
+
+	metrics.WAL.Size += atomic.LoadUint64(&d.atomic.logSize)
+	metrics.WAL.BytesIn = atomic.LoadUint64(&d.atomic.logBytesIn)
+	metrics.Levels[0].BytesIn = metrics.WAL.BytesIn
+	metrics.BlockCache = d.opts.Cache.Metrics()
+	metrics.TableCache = d.tableCache.metrics()
+	return metrics
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/petermattis/pebble/cache"
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestMetrics(t *testing.T) {
+	d, err := Open("", &db.Options{
+		Cache: cache.New(1 << 20),
+		VFS:   vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%03d", i))
+		if err := d.Set(key, key, db.NoSync); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := d.Metrics()
+	if m.WAL.Files != 1 || m.WAL.BytesIn == 0 || m.WAL.Size < m.WAL.BytesIn {
+		t.Fatalf("unexpected WAL metrics: %+v", m.WAL)
+	}
+	if m.MemTable.Count != 1 || m.MemTable.Size == 0 {
+		t.Fatalf("unexpected memtable metrics: %+v", m.MemTable)
+	}
+	if m.Flush.Count != 0 || m.Levels[0].NumFiles != 0 {
+		t.Fatalf("unexpected flush metrics: %+v", m.Flush)
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m = d.Metrics()
+	if m.Flush.Count != 1 {
+		t.Fatalf("expected 1 flush, but found %d", m.Flush.Count)
+	}
+	l0 := m.Levels[0]
+	if l0.NumFiles != 1 || l0.Size == 0 || l0.BytesWritten != l0.Size ||
+		l0.BytesIn != m.WAL.BytesIn || l0.Score <= 0 {
+		t.Fatalf("unexpected L0 metrics: %+v", l0)
+	}
+
+	if err := d.Compact([]byte("000"), []byte("099")); err != nil {
+		t.Fatal(err)
+	}
+	m = d.Metrics()
+	if m.Compact.Count == 0 {
+		t.Fatalf("expected compactions, but found none")
+	}
+	if m.Levels[0].NumFiles != 0 {
+		t.Fatalf("expected L0 to be empty, but found %d files", m.Levels[0].NumFiles)
+	}
+	total := m.Total()
+	if total.NumFiles != 1 || total.BytesMoved+total.BytesRead == 0 {
+		t.Fatalf("unexpected total metrics: %+v", total)
+	}
+
+	// Read a key twice. The first read misses in the caches and the second
+	// read hits.
+	for i := 0; i < 2; i++ {
+		if _, err := d.Get([]byte("050")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m = d.Metrics()
+	if m.BlockCache.Hits == 0 || m.BlockCache.Misses == 0 || m.BlockCache.Count == 0 {
+		t.Fatalf("unexpected block cache metrics: %+v", m.BlockCache)
+	}
+	if m.TableCache.Hits == 0 || m.TableCache.Misses == 0 || m.TableCache.Count != 1 {
+		t.Fatalf("unexpected table cache metrics: %+v", m.TableCache)
+	}
+
+	s := m.String()
+	for _, prefix := range []string{"level__files", "  WAL", "    0", "    6", "total", "  flush",
+		"compact", " memtbl", " bcache", " tcache"} {
+		if !strings.Contains(s, "\n"+prefix) && !strings.HasPrefix(s, prefix) {
+			t.Fatalf("expected %q in metrics output:\n%s", prefix, s)
+		}
+	}
+}
@@ -19,12 +19,20 @@ import (
 	"github.com/petermattis/pebble/vfs"
 )
 
-func createDB(dirname string, opts *db.Options) (retErr error) {
+func createDB(dirname string, opts *db.Options) error {
 	const manifestFileNum = 1
 	ve := versionEdit{
 		comparatorName: opts.Comparer.Name,
 		nextFileNumber: manifestFileNum + 1,
 	}
+	return writeManifest(dirname, opts, manifestFileNum, &ve)
+}
+
+// writeManifest writes a new manifest containing the specified version edit,
+// and makes it the current manifest.
+func writeManifest(
+	dirname string, opts *db.Options, manifestFileNum uint64, ve *versionEdit,
+) (retErr error) {
 	manifestFilename := dbFilename(dirname, fileTypeManifest, manifestFileNum)
 	f, err := opts.VFS.Create(manifestFilename)
 	if err != nil {
@@ -50,18 +58,22 @@ func createDB(dirname string, opts *db.Options) (retErr error) {
 	if err != nil {
 		return err
 	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
 	return setCurrentFile(dirname, opts.VFS, manifestFileNum)
 }
 
-// Open opens a LevelDB whose files live in the given directory.
-func Open(dirname string, opts *db.Options) (*DB, error) {
-	opts = opts.EnsureDefaults()
+// newDB returns a DB whose in-memory state is initialized, but which has not
+// loaded its version set.
+func newDB(dirname string, opts *db.Options) *DB {
 	d := &DB{
 		dirname:        dirname,
 		opts:           opts,
 		cmp:            opts.Comparer.Compare,
 		equal:          opts.Comparer.Equal,
 		merge:          opts.Merger.Merge,
+		split:          opts.Comparer.Split,
 		abbreviatedKey: opts.Comparer.AbbreviatedKey,
 		logRecycler:    logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
 	}
@@ -88,12 +100,28 @@ func Open(dirname string, opts *db.Options) (*DB, error) {
 	d.mu.cleaner.cond.L = &d.mu.Mutex
 	d.mu.compact.cond.L = &d.mu.Mutex
 	d.mu.compact.pendingOutputs = make(map[uint64]struct{})
+	d.mu.pacer = newWritePacer(d.opts)
 	d.mu.snapshots.init()
+	d.mu.prepared = make(map[string]*preparedBatch)
 	d.largeBatchThreshold = (d.opts.MemTableSize - int(d.mu.mem.mutable.emptySize)) / 2
+	return d
+}
+
+// Open opens a LevelDB whose files live in the given directory.
+func Open(dirname string, opts *db.Options) (*DB, error) {
+	opts = opts.EnsureDefaults()
+	d := newDB(dirname, opts)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	if opts.ReadOnly {
+		if err := d.openReadOnlyLocked(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
 	// Lock the database directory.
 	err := opts.VFS.MkdirAll(dirname, 0755)
 	if err != nil {
@@ -126,48 +154,10 @@ func Open(dirname string, opts *db.Options) (*DB, error) {
 		return nil, err
 	}
 
-	ls, err := opts.VFS.List(dirname)
-	if err != nil {
-		return nil, err
-	}
-
-	// Replay any newer log files than the ones named in the manifest.
-	type fileNumAndName struct {
-		num  uint64
-		name string
-	}
-	var logFiles []fileNumAndName
-	for _, filename := range ls {
-		ft, fn, ok := parseDBFilename(filename)
-		if !ok {
-			continue
-		}
-		switch ft {
-		case fileTypeLog:
-			if fn >= d.mu.versions.logNumber || fn == d.mu.versions.prevLogNumber {
-				logFiles = append(logFiles, fileNumAndName{fn, filename})
-			}
-		case fileTypeOptions:
-			if err := checkOptions(opts, filepath.Join(dirname, filename)); err != nil {
-				return nil, err
-			}
-		}
-	}
-	sort.Slice(logFiles, func(i, j int) bool {
-		return logFiles[i].num < logFiles[j].num
-	})
 	var ve versionEdit
-	for _, lf := range logFiles {
-		maxSeqNum, err := d.replayWAL(&ve, opts.VFS, filepath.Join(dirname, lf.name), lf.num)
-		if err != nil {
-			return nil, err
-		}
-		d.mu.versions.markFileNumUsed(lf.num)
-		if d.mu.versions.logSeqNum < maxSeqNum {
-			d.mu.versions.logSeqNum = maxSeqNum
-		}
+	if err := d.replayWALsLocked(&ve); err != nil {
+		return nil, err
 	}
-	d.mu.versions.visibleSeqNum = d.mu.versions.logSeqNum
 
 	// Create an empty .log file.
 	ve.logNumber = d.mu.versions.nextFileNum()
@@ -182,6 +172,12 @@ func Open(dirname string, opts *db.Options) (*DB, error) {
 	})
 	d.mu.log.LogWriter = record.NewLogWriter(logFile, ve.logNumber)
 
+	// Rewrite any prepared batches recovered from the replayed WALs to the new
+	// WAL as the replayed WALs are about to become obsolete.
+	if err := d.logPreparedLocked(d.mu.log.LogWriter); err != nil {
+		return nil, err
+	}
+
 	// Write a new manifest to disk.
 	if err := d.mu.versions.logAndApply(&ve); err != nil {
 		return nil, err
@@ -203,6 +199,11 @@ func Open(dirname string, opts *db.Options) (*DB, error) {
 	d.mu.nextJobID++
 	d.scanObsoleteFiles()
 	d.deleteObsoleteFiles(jobID)
+	if d.opts.CompactionStyle == db.CompactionStyleFIFO {
+		if err := d.loadTableCreationTimes(); err != nil {
+			return nil, err
+		}
+	}
 	d.maybeScheduleFlush()
 	d.maybeScheduleCompaction()
 
@@ -210,7 +211,114 @@ func Open(dirname string, opts *db.Options) (*DB, error) {
 	return d, nil
 }
 
-// replayWAL replays the edits in the specified log file.
+// openReadOnlyLocked opens the DB without modifying any of its files. The
+// database directory is not locked, and the WAL is replayed into memtables
+// which are never flushed.
+//
+// d.mu must be held when calling this.
+func (d *DB) openReadOnlyLocked() error {
+	opts := d.opts
+	if _, err := opts.VFS.Stat(dbFilename(d.dirname, fileTypeCurrent, 0)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("pebble: database %q does not exist", d.dirname)
+		}
+		return fmt.Errorf("pebble: database %q: %v", d.dirname, err)
+	}
+	if opts.ErrorIfDBExists {
+		return fmt.Errorf("pebble: database %q already exists", d.dirname)
+	}
+
+	if err := d.mu.versions.load(d.dirname, opts, &d.mu.Mutex); err != nil {
+		return err
+	}
+	if err := d.replayWALsLocked(nil /* ve */); err != nil {
+		return err
+	}
+	d.updateReadStateLocked()
+	if opts.CompactionStyle == db.CompactionStyleFIFO {
+		if err := d.loadTableCreationTimes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayWALsLocked checks the options files in the database directory against
+// the options the DB was opened with, and replays the log files which are
+// newer than the ones named in the manifest. See replayWAL.
+//
+// d.mu must be held when calling this.
+func (d *DB) replayWALsLocked(ve *versionEdit) error {
+	opts := d.opts
+	ls, err := opts.VFS.List(d.dirname)
+	if err != nil {
+		return err
+	}
+
+	type fileNumAndName struct {
+		num  uint64
+		name string
+	}
+	var logFiles []fileNumAndName
+	for _, filename := range ls {
+		ft, fn, ok := parseDBFilename(filename)
+		if !ok {
+			continue
+		}
+		switch ft {
+		case fileTypeLog:
+			if fn >= d.mu.versions.logNumber || fn == d.mu.versions.prevLogNumber {
+				logFiles = append(logFiles, fileNumAndName{fn, filename})
+			}
+		case fileTypeOptions:
+			if err := checkOptions(opts, filepath.Join(d.dirname, filename)); err != nil {
+				return err
+			}
+		}
+	}
+	sort.Slice(logFiles, func(i, j int) bool {
+		return logFiles[i].num < logFiles[j].num
+	})
+	for i, lf := range logFiles {
+		last := i == len(logFiles)-1
+		maxSeqNum, err := d.replayWAL(ve, opts.VFS, filepath.Join(d.dirname, lf.name), lf.num, last)
+		if err != nil {
+			return err
+		}
+		d.mu.versions.markFileNumUsed(lf.num)
+		if d.mu.versions.logSeqNum < maxSeqNum {
+			d.mu.versions.logSeqNum = maxSeqNum
+		}
+	}
+	d.mu.versions.visibleSeqNum = d.mu.versions.logSeqNum
+	return nil
+}
+
+// loadTableCreationTimes loads the creation times of the L0 tables from their
+// properties, as the creation times are not persisted in the manifest.
+//
+// d.mu must be held when calling this.
+func (d *DB) loadTableCreationTimes() error {
+	files := d.mu.versions.currentVersion().files[0]
+	for i := range files {
+		f := &files[i]
+		if f.creationTime != 0 {
+			continue
+		}
+		props, err := d.tableCache.properties(f)
+		if err != nil {
+			return err
+		}
+		f.creationTime = props.CreationTime
+	}
+	return nil
+}
+
+// replayWAL replays the edits in the specified log file. The replayed edits
+// are flushed to a new L0 table which is added to ve. In read-only mode, ve is
+// nil and the memtables holding the replayed edits are instead added to the
+// queue of immutable memtables, where they are retained until the DB is
+// closed.
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
@@ -219,6 +327,7 @@ func (d *DB) replayWAL(
 	fs vfs.FS,
 	filename string,
 	logNum uint64,
+	last bool,
 ) (maxSeqNum uint64, err error) {
 	file, err := fs.Open(filename)
 	if err != nil {
@@ -244,18 +353,24 @@ func (d *DB) replayWAL(
 			if err == io.EOF || err == record.ErrZeroedChunk {
 				break
 			}
+			// A DB opened read-only may be in use by another process, which can be
+			// in the middle of writing the last record of the newest WAL. Such a
+			// torn record is treated like EOF, as a secondary does.
+			if d.opts.ReadOnly && last && (isEndOfRecords(err) || record.IsInvalidChunk(err)) {
+				break
+			}
 			return 0, err
 		}
 
-		if buf.Len() < batchHeaderLen {
-			return 0, fmt.Errorf("pebble: corrupt log file %q", filename)
-		}
-
 		// TODO(peter): If the batch is too large to fit in the memtable, flush the
 		// existing memtable and write the batch as a separate L0 table.
 		b = Batch{}
-		b.storage.data = buf.Bytes()
-		b.refreshMemTableSize()
+		if ok, err := d.decodeWALBatch(&b, buf.Bytes(), filename); err != nil {
+			return 0, err
+		} else if !ok {
+			buf.Reset()
+			continue
+		}
 		seqNum := b.seqNum()
 		maxSeqNum = seqNum + uint64(b.count())
 
@@ -263,16 +378,22 @@ func (d *DB) replayWAL(
 			mem = newMemTable(d.opts)
 		}
 
-		for {
-			err := mem.prepare(&b)
-			if err == arenaskl.ErrArenaFull {
-				// TODO(peter): write the memtable to disk.
-				panic(err)
-			}
-			if err != nil {
-				return 0, err
-			}
-			break
+		err = mem.prepare(&b)
+		if err == arenaskl.ErrArenaFull && d.opts.ReadOnly && !mem.empty() {
+			d.retainReplayedMemTableLocked(mem)
+			mem = newMemTable(d.opts)
+			err = mem.prepare(&b)
+		}
+		if err == arenaskl.ErrArenaFull && mem.empty() {
+			return 0, fmt.Errorf("pebble: batch #%d in WAL %q does not fit in a memtable of %d bytes",
+				seqNum, filename, d.opts.MemTableSize)
+		}
+		if err == arenaskl.ErrArenaFull {
+			// TODO(peter): write the memtable to disk.
+			panic(err)
+		}
+		if err != nil {
+			return 0, err
 		}
 
 		if err := mem.apply(&b, seqNum); err != nil {
@@ -283,8 +404,10 @@ func (d *DB) replayWAL(
 		buf.Reset()
 	}
 
-	if mem != nil && !mem.empty() {
-		meta, err := d.writeLevel0Table(fs, mem.newIter(nil),
+	if mem != nil && !mem.empty() && d.opts.ReadOnly {
+		d.retainReplayedMemTableLocked(mem)
+	} else if mem != nil && !mem.empty() {
+		meta, _, err := d.writeLevel0Table(fs, mem.newIter(nil),
 			true /* allowRangeTombstoneElision */)
 		if err != nil {
 			return 0, err
@@ -299,6 +422,50 @@ func (d *DB) replayWAL(
 	return maxSeqNum, nil
 }
 
+// decodeWALBatch decodes a batch read from the specified log file into b. It
+// returns false if the batch is a prepared batch, which is not applied to the
+// memtable until it is committed, but is instead added to the set of prepared
+// batches.
+//
+// d.mu must be held when calling this.
+func (d *DB) decodeWALBatch(b *Batch, data []byte, filename string) (bool, error) {
+	if len(data) < batchHeaderLen {
+		return false, fmt.Errorf("pebble: corrupt log file %q", filename)
+	}
+	b.storage.data = data
+	if err := b.verify(); err != nil {
+		return false, fmt.Errorf("pebble: corrupt log file %q: %v", filename, err)
+	}
+
+	// Committing or rolling back a prepared batch removes it from the set of
+	// prepared batches.
+	iter := b.iter()
+	if kind, _, _, _ := iter.next(); kind == db.InternalKeyKindBeginPrepareXID {
+		p, err := decodePreparedBatch(b.storage.data)
+		if err != nil {
+			return false, fmt.Errorf("pebble: corrupt log file %q: %v", filename, err)
+		}
+		d.mu.prepared[string(p.xid)] = p
+		return false, nil
+	}
+	if xid, ok := b.decidedXID(); ok {
+		delete(d.mu.prepared, string(xid))
+	}
+
+	b.refreshMemTableSize()
+	return true, nil
+}
+
+// retainReplayedMemTableLocked adds a memtable holding replayed edits to the
+// queue of immutable memtables, just before the mutable memtable.
+//
+// d.mu must be held when calling this.
+func (d *DB) retainReplayedMemTableLocked(mem *memTable) {
+	queue := d.mu.mem.queue
+	n := len(queue) - 1
+	d.mu.mem.queue = append(queue[:n:n], mem, queue[n])
+}
+
 func checkOptions(opts *db.Options, path string) error {
 	f, err := opts.VFS.Open(path)
 	if err != nil {
@@ -5,6 +5,8 @@
 package pebble
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -15,6 +17,7 @@ import (
 	"testing"
 
 	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/record"
 	"github.com/petermattis/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -213,3 +216,195 @@ func TestOpenOptionsCheck(t *testing.T) {
 	_, err = Open("", opts)
 	require.Regexp(t, `merger name from file.*!=.*`, err)
 }
+
+func TestOpenReadOnly(t *testing.T) {
+	mem := vfs.NewMem()
+
+	_, err := Open("", &db.Options{VFS: mem, ReadOnly: true})
+	require.Regexp(t, `does not exist`, err)
+
+	d0, err := Open("", &db.Options{VFS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d0.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d0.Flush())
+	// Write enough data to the WAL to need several of the small memtables used
+	// below.
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("b%04d", i))
+		require.NoError(t, d0.Set(key, bytes.Repeat([]byte("x"), 100), nil))
+	}
+	require.NoError(t, d0.Delete([]byte("a"), nil))
+
+	listFiles := func() []string {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		sort.Strings(ls)
+		return ls
+	}
+	before := listFiles()
+
+	// The DB is opened read-only while it is still open for writing.
+	d1, err := Open("", &db.Options{
+		VFS:          mem,
+		MemTableSize: 32 << 10,
+		ReadOnly:     true,
+	})
+	require.NoError(t, err)
+	if n := len(d1.mu.mem.queue); n <= 2 {
+		t.Fatalf("expected the WAL to be replayed into several memtables, got %d", n)
+	}
+
+	if _, err := d1.Get([]byte("a")); err != db.ErrNotFound {
+		t.Fatalf("expected %v, got %v", db.ErrNotFound, err)
+	}
+	for _, i := range []int{0, 500, 999} {
+		key := []byte(fmt.Sprintf("b%04d", i))
+		v, err := d1.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, 100, len(v))
+	}
+	iter := d1.NewIter(nil)
+	var count int
+	for iter.First(); iter.Valid(); iter.Next() {
+		count++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1000, count)
+
+	ops := map[string]func() error{
+		"set":        func() error { return d1.Set([]byte("c"), nil, nil) },
+		"apply":      func() error { return d1.Apply(d1.NewBatch(), nil) },
+		"ingest":     func() error { return d1.Ingest([]string{"ext"}) },
+		"compact":    func() error { return d1.Compact([]byte("a"), []byte("z")) },
+		"flush":      func() error { return d1.Flush() },
+		"delete":     func() error { return d1.DeleteFilesInRange([]byte("a"), []byte("z")) },
+		"checkpoint": func() error { return d1.Checkpoint("checkpoint") },
+	}
+	for name, op := range ops {
+		if err := op(); err != db.ErrReadOnly {
+			t.Fatalf("%s: expected %v, got %v", name, db.ErrReadOnly, err)
+		}
+	}
+	require.NoError(t, d1.Close())
+	require.Equal(t, before, listFiles())
+	require.NoError(t, d0.Close())
+}
+
+func TestOpenReadOnlyTornWAL(t *testing.T) {
+	mem := vfs.NewMem()
+	d0, err := Open("", &db.Options{VFS: mem})
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, d0.Set([]byte(key), []byte(key), nil))
+	}
+	require.NoError(t, d0.Close())
+
+	var walName string
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, filename := range ls {
+		if ft, _, ok := parseDBFilename(filename); ok && ft == fileTypeLog {
+			walName = filename
+		}
+	}
+	f, err := mem.Open(walName)
+	require.NoError(t, err)
+	wal, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	writeWAL := func(data []byte) {
+		f, err := mem.Create(walName)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	openAndGet := func(readOnly bool) ([]string, error) {
+		d, err := Open("", &db.Options{VFS: mem, ReadOnly: readOnly})
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		var keys []string
+		iter := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys, iter.Close()
+	}
+
+	// The last record of the WAL is torn, either cut short or followed by a
+	// chunk that was partially written. The batches before it are replayed by a
+	// read-only open, while a read-write open fails.
+	testCases := []struct {
+		data     []byte
+		expected []string
+	}{
+		{wal[:len(wal)-1], []string{"a", "b"}},
+		{append(append([]byte(nil), wal...), 0, 0, 0, 0, 50, 0, 1, 'x'), []string{"a", "b", "c"}},
+	}
+	for _, c := range testCases {
+		writeWAL(c.data)
+		keys, err := openAndGet(true /* readOnly */)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, keys)
+	}
+	writeWAL(wal[:len(wal)-1])
+	_, err = openAndGet(false /* readOnly */)
+	require.Error(t, err)
+}
+
+func TestOpenBatchLargerThanMemTable(t *testing.T) {
+	mem := vfs.NewMem()
+	d0, err := Open("", &db.Options{VFS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d0.Set([]byte("a"), bytes.Repeat([]byte("x"), 64<<10), nil))
+	require.NoError(t, d0.Close())
+
+	// A batch which does not fit in an empty memtable cannot be replayed.
+	for _, readOnly := range []bool{false, true} {
+		_, err = Open("", &db.Options{
+			VFS:          mem,
+			MemTableSize: 32 << 10,
+			ReadOnly:     readOnly,
+		})
+		require.Regexp(t, `does not fit in a memtable`, err)
+	}
+}
+
+func TestOpenColumnFamilyRecord(t *testing.T) {
+	mem := vfs.NewMem()
+	d0, err := Open("", &db.Options{VFS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d0.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d0.Close())
+
+	var walName string
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, filename := range ls {
+		if ft, _, ok := parseDBFilename(filename); ok && ft == fileTypeLog {
+			walName = filename
+		}
+	}
+
+	// Replace the WAL with one holding a batch which contains a RocksDB column
+	// family value record (kind 5). Replaying it must fail rather than apply
+	// the record to the default column family.
+	var b Batch
+	b.Set([]byte("a"), []byte("1"), nil)
+	b.storage.data = append(b.storage.data, 5, 1, 1, 'b', 1, '2')
+	b.setCount(b.count() + 1)
+	b.setSeqNum(1)
+	f, err := mem.Create(walName)
+	require.NoError(t, err)
+	w := record.NewWriter(f)
+	_, err = w.WriteRecord(b.storage.data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	_, err = Open("", &db.Options{VFS: mem})
+	require.Error(t, err)
+}
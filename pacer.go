@@ -0,0 +1,97 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/rate"
+)
+
+// writePacerRefillPeriod is the period of time whose worth of user writes can
+// be performed in a single burst while writes are paced.
+const writePacerRefillPeriod = 100 * time.Millisecond
+
+// writePressure returns how far flushes and compactions are falling behind
+// user writes, from 0, when user writes do not need to be paced, to 1, when
+// user writes are paced at the minimum rate. It is the larger of the pressure
+// from the flush and compaction debt, which grows from
+// Options.WriteDebtSlowdownThreshold to Options.WriteDebtLimit, and the
+// pressure from the number of L0 files, which grows from
+// Options.L0SlowdownWritesThreshold to Options.L0StopWritesThreshold.
+func writePressure(opts *db.Options, debt uint64, l0Files int) float64 {
+	pressure := func(v, slowdown, limit float64) float64 {
+		switch {
+		case v <= slowdown:
+			return 0
+		case v >= limit:
+			return 1
+		}
+		return (v - slowdown) / (limit - slowdown)
+	}
+	p := pressure(float64(debt),
+		float64(opts.WriteDebtSlowdownThreshold), float64(opts.WriteDebtLimit))
+	if l0 := pressure(float64(l0Files),
+		float64(opts.L0SlowdownWritesThreshold), float64(opts.L0StopWritesThreshold)); p < l0 {
+		p = l0
+	}
+	return p
+}
+
+// writePacer paces user writes when flushes and compactions fall behind.
+// Instead of stalling writes once a fixed threshold is crossed, the rate at
+// which user writes are allowed decreases smoothly, from
+// Options.DelayedWriteRate to 1% of it, as the write pressure grows. See
+// writePressure.
+type writePacer struct {
+	opts  *db.Options
+	burst int
+	// limiter is nil while user writes are not paced. A new limiter, which
+	// allows an initial burst, is created whenever pacing starts.
+	limiter *rate.Limiter
+}
+
+func newWritePacer(opts *db.Options) *writePacer {
+	burst := int(opts.DelayedWriteRate * int64(writePacerRefillPeriod) / int64(time.Second))
+	if burst < 1 {
+		burst = 1
+	}
+	return &writePacer{
+		opts:  opts,
+		burst: burst,
+	}
+}
+
+// delay returns the time to wait before writing n bytes, given the current
+// write pressure.
+func (p *writePacer) delay(now time.Time, n int, pressure float64) time.Duration {
+	if pressure <= 0 {
+		p.limiter = nil
+		return 0
+	}
+
+	maxRate := float64(p.opts.DelayedWriteRate)
+	r := maxRate * (1 - pressure)
+	if minRate := maxRate / 100; r < minRate {
+		r = minRate
+	}
+	if p.limiter == nil {
+		p.limiter = rate.NewLimiter(rate.Limit(r), p.burst)
+	} else {
+		p.limiter.SetLimitAt(now, rate.Limit(r))
+	}
+
+	var delay time.Duration
+	for n > 0 {
+		chunk := n
+		if chunk > p.burst {
+			chunk = p.burst
+		}
+		delay = p.limiter.ReserveN(now, chunk).DelayFrom(now)
+		n -= chunk
+	}
+	return delay
+}
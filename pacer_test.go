@@ -0,0 +1,124 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/petermattis/pebble/db"
+)
+
+func TestWritePressure(t *testing.T) {
+	opts := (&db.Options{
+		L0SlowdownWritesThreshold:  8,
+		L0StopWritesThreshold:      12,
+		WriteDebtSlowdownThreshold: 100,
+		WriteDebtLimit:             500,
+	}).EnsureDefaults()
+
+	testCases := []struct {
+		debt     uint64
+		l0Files  int
+		expected float64
+	}{
+		{0, 0, 0},
+		{100, 8, 0},
+		{200, 0, 0.25},
+		{300, 0, 0.5},
+		{500, 0, 1},
+		{1000, 0, 1},
+		{0, 9, 0.25},
+		{0, 12, 1},
+		{0, 20, 1},
+		// The larger of the two pressures is used.
+		{200, 10, 0.5},
+		{400, 10, 0.75},
+	}
+	for _, c := range testCases {
+		if p := writePressure(opts, c.debt, c.l0Files); p != c.expected {
+			t.Fatalf("debt=%d l0=%d: expected %.2f, but found %.2f", c.debt, c.l0Files, c.expected, p)
+		}
+	}
+}
+
+func TestWritePressureUniversal(t *testing.T) {
+	opts := (&db.Options{
+		CompactionStyle:            db.CompactionStyleUniversal,
+		L0CompactionThreshold:      4,
+		L0SlowdownWritesThreshold:  20,
+		L0StopWritesThreshold:      30,
+		WriteDebtSlowdownThreshold: 1000,
+		WriteDebtLimit:             2000,
+	}).EnsureDefaults()
+
+	testCases := []struct {
+		l0       []uint64
+		bottom   uint64
+		expected float64
+	}{
+		// A large L0 is not compacted while there are fewer sorted runs than
+		// L0CompactionThreshold, and the size amplification is within its limit.
+		{[]uint64{50000}, 100000, 0},
+		{[]uint64{50000, 50000}, 100000, 0},
+		// The two newest sorted runs are merged to bring the number of sorted
+		// runs below L0CompactionThreshold.
+		{[]uint64{50000, 100, 100}, 100000, 0},
+		{[]uint64{500, 500, 500, 500, 500}, 100000, 0.5},
+		// All of the sorted runs are merged to bring the size amplification
+		// within its limit.
+		{[]uint64{600, 600, 600}, 600, 1},
+	}
+	for _, c := range testCases {
+		vers := &version{}
+		for _, size := range c.l0 {
+			vers.files[0] = append(vers.files[0], fileMetadata{size: size})
+		}
+		vers.files[numLevels-1] = []fileMetadata{{size: c.bottom}}
+		p := newCompactionPicker(vers, opts)
+		if v := writePressure(opts, p.compactionDebt, len(vers.files[0])); v != c.expected {
+			t.Errorf("%v %d: expected %.2f, but found %.2f (debt %d)",
+				c.l0, c.bottom, c.expected, v, p.compactionDebt)
+		}
+	}
+}
+
+func TestWritePacer(t *testing.T) {
+	// While writes are paced, a burst of 100 bytes is allowed, after which at
+	// most 1000 bytes per second are allowed.
+	opts := (&db.Options{
+		DelayedWriteRate: 1000,
+	}).EnsureDefaults()
+	p := newWritePacer(opts)
+	now := time.Unix(1000, 0)
+
+	testCases := []struct {
+		n        int
+		pressure float64
+		expected time.Duration
+	}{
+		// Writes are not paced without pressure.
+		{1000, 0, 0},
+		{1000, 0, 0},
+		// The burst allows the first 100 bytes.
+		{100, 0.5, 0},
+		// At half pressure, the rate is 500 bytes per second.
+		{100, 0.5, 200 * time.Millisecond},
+		{100, 0.5, 400 * time.Millisecond},
+		// The rate does not fall below 1% of DelayedWriteRate. The tokens owed
+		// for the previous writes are repaid at the new rate.
+		{10, 1, 21 * time.Second},
+		// Writes which are larger than the burst are reserved in pieces.
+		{300, 0, 0},
+		{100, 0.5, 0},
+		{300, 0.5, 600 * time.Millisecond},
+	}
+	for i, c := range testCases {
+		if d := p.delay(now, c.n, c.pressure); d != c.expected {
+			t.Fatalf("%d: n=%d pressure=%.2f: expected %s, but found %s",
+				i, c.n, c.pressure, c.expected, d)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/record"
+)
+
+// preparedBatch is a batch which has been prepared, but not yet committed or
+// rolled back.
+type preparedBatch struct {
+	xid []byte
+	// The operations in the batch.
+	ops *Batch
+	// True while the batch is being committed or rolled back.
+	deciding bool
+}
+
+// record returns the WAL record for the prepared batch: the batch operations
+// bracketed by BeginPrepareXID and EndPrepareXID markers.
+func (p *preparedBatch) record() []byte {
+	var b Batch
+	_ = b.addXIDMarker(db.InternalKeyKindBeginPrepareXID, nil)
+	_ = b.Apply(p.ops, nil)
+	_ = b.addXIDMarker(db.InternalKeyKindEndPrepareXID, p.xid)
+	return b.storage.data
+}
+
+// decodePreparedBatch decodes a WAL record written for a prepared batch. The
+// returned batch does not retain any references to data.
+func decodePreparedBatch(data []byte) (*preparedBatch, error) {
+	b := Batch{storage: batchStorage{data: data}}
+	r := b.iter()
+	if kind, _, _, ok := r.next(); !ok || kind != db.InternalKeyKindBeginPrepareXID {
+		return nil, errors.New("pebble: prepared batch does not begin with a prepare marker")
+	}
+	start := len(data) - len(r)
+	for {
+		end := len(data) - len(r)
+		kind, ukey, _, ok := r.next()
+		if !ok {
+			return nil, errors.New("pebble: prepared batch does not end with a prepare marker")
+		}
+		if kind != db.InternalKeyKindEndPrepareXID {
+			continue
+		}
+		if len(r) != 0 {
+			return nil, errors.New("pebble: prepared batch contains records after the prepare marker")
+		}
+		p := &preparedBatch{
+			xid: append([]byte(nil), ukey...),
+			ops: &Batch{},
+		}
+		if end > start {
+			p.ops.init(batchHeaderLen + end - start)
+			p.ops.storage.data = append(p.ops.storage.data, data[start:end]...)
+			p.ops.setCount(b.count() - 2)
+		}
+		return p, nil
+	}
+}
+
+// decidedXID returns the XID of the prepared batch which is committed or
+// rolled back by the batch, if any.
+func (b *Batch) decidedXID() ([]byte, bool) {
+	if len(b.storage.data) <= batchHeaderLen {
+		return nil, false
+	}
+	r := b.iter()
+	kind, xid, _, ok := r.next()
+	if !ok {
+		return nil, false
+	}
+	switch kind {
+	case db.InternalKeyKindCommitXID, db.InternalKeyKindRollbackXID:
+		return xid, true
+	}
+	return nil, false
+}
+
+// Prepare writes the batch to the WAL as a prepared batch identified by xid,
+// performing the first phase of a two-phase commit. The operations in a
+// prepared batch are not visible to reads until the batch is committed via
+// CommitPrepared, and are discarded if the batch is rolled back via
+// RollbackPrepared. If WriteOptions.Sync is true, Prepare guarantees that the
+// prepared batch is persisted to disk before returning.
+//
+// Prepared batches which have been neither committed nor rolled back survive a
+// restart of the DB. Use PreparedXIDs to retrieve them after opening the DB.
+//
+// It is safe to modify the contents of the arguments after Prepare returns.
+func (d *DB) Prepare(xid []byte, batch *Batch, opts *db.WriteOptions) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
+	if len(xid) == 0 {
+		return errors.New("pebble: empty XID")
+	}
+	if d.opts.DisableWAL {
+		return errors.New("pebble: WAL disabled")
+	}
+	if d.opts.EnableTTL && batch.ttlUnencoded {
+		return errTTLBatch
+	}
+
+	p := &preparedBatch{
+		xid: append([]byte(nil), xid...),
+		ops: &Batch{},
+	}
+	if err := p.ops.Apply(batch, nil); err != nil {
+		return err
+	}
+	err := d.logPrepared(p, func() error {
+		if _, ok := d.mu.prepared[string(xid)]; ok {
+			return fmt.Errorf("pebble: XID %q is already prepared", xid)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.GetSync() {
+		return d.commitSync()
+	}
+	return nil
+}
+
+// logPrepared writes the record for the prepared batch to the WAL and adds
+// the batch to the set of prepared batches. The record is written from within
+// the commit pipeline so that it is ordered with respect to other writes. It
+// is allocated a sequence number, but doesn't use it. If check is non-nil, it
+// is invoked with d.mu held before the record is written, and the record is
+// not written if it returns an error.
+func (d *DB) logPrepared(p *preparedBatch, check func() error) error {
+	data := p.record()
+	var err error
+	prepare := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if check != nil {
+			if err = check(); err != nil {
+				return
+			}
+		}
+		for d.mu.mem.switching {
+			d.mu.mem.cond.Wait()
+		}
+		var size int64
+		size, err = d.mu.log.WriteRecord(data)
+		if err != nil {
+			return
+		}
+		atomic.AddUint64(&d.atomic.logBytesIn, uint64(len(data)))
+		atomic.StoreUint64(&d.atomic.logSize, uint64(size))
+		d.mu.prepared[string(p.xid)] = p
+	}
+	apply := func(seqNum uint64) {}
+	d.commit.AllocateSeqNum(prepare, apply)
+	return err
+}
+
+// CommitPrepared commits the prepared batch identified by xid, making its
+// operations visible to reads. CommitPrepared returns an error if there is no
+// such prepared batch.
+//
+// It is safe to modify the contents of the arguments after CommitPrepared
+// returns.
+func (d *DB) CommitPrepared(xid []byte, opts *db.WriteOptions) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
+	p, err := d.decidePrepared(xid)
+	if err != nil {
+		return err
+	}
+
+	// The commit record contains the operations in the prepared batch, so that
+	// replaying it does not depend on finding the WAL record for the prepared
+	// batch.
+	b := newBatch(d)
+	defer b.release()
+	_ = b.addXIDMarker(db.InternalKeyKindCommitXID, xid)
+	_ = b.Apply(p.ops, nil)
+	return d.finishPrepared(p, d.Apply(b, opts))
+}
+
+// RollbackPrepared discards the prepared batch identified by xid.
+// RollbackPrepared returns an error if there is no such prepared batch.
+//
+// It is safe to modify the contents of the arguments after RollbackPrepared
+// returns.
+func (d *DB) RollbackPrepared(xid []byte, opts *db.WriteOptions) error {
+	if d.opts.ReadOnly {
+		return db.ErrReadOnly
+	}
+	p, err := d.decidePrepared(xid)
+	if err != nil {
+		return err
+	}
+
+	b := newBatch(d)
+	defer b.release()
+	_ = b.addXIDMarker(db.InternalKeyKindRollbackXID, xid)
+	return d.finishPrepared(p, d.Apply(b, opts))
+}
+
+// PreparedXIDs returns the XIDs of the batches which have been prepared, but
+// neither committed nor rolled back, in sorted order. This includes prepared
+// batches which were recovered from the WAL when the DB was opened.
+func (d *DB) PreparedXIDs() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	xids := make([][]byte, 0, len(d.mu.prepared))
+	for _, p := range d.mu.prepared {
+		xids = append(xids, append([]byte(nil), p.xid...))
+	}
+	sort.Slice(xids, func(i, j int) bool {
+		return bytes.Compare(xids[i], xids[j]) < 0
+	})
+	return xids
+}
+
+// decidePrepared looks up the prepared batch identified by xid and marks it as
+// being decided.
+func (d *DB) decidePrepared(xid []byte) (*preparedBatch, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.mu.prepared[string(xid)]
+	if !ok {
+		return nil, fmt.Errorf("pebble: XID %q is not prepared", xid)
+	}
+	if p.deciding {
+		return nil, fmt.Errorf("pebble: XID %q is already being committed or rolled back", xid)
+	}
+	p.deciding = true
+	return p, nil
+}
+
+// forgetDecidedLocked removes the prepared batch which is committed or rolled
+// back by b, if any. It is invoked from within the commit pipeline when b is
+// about to be written to the WAL, so that a WAL rotation never rewrites the
+// record of a prepared batch after the record deciding it. Otherwise, once
+// the older WAL was deleted, the batch would be prepared again after a
+// restart. d.mu must be held when calling this.
+func (d *DB) forgetDecidedLocked(b *Batch) {
+	xid, ok := b.decidedXID()
+	if !ok {
+		return
+	}
+	if p, ok := d.mu.prepared[string(xid)]; ok && p.deciding {
+		delete(d.mu.prepared, string(xid))
+	}
+}
+
+// finishPrepared completes the commit or rollback of a prepared batch. The
+// prepared batch was removed by forgetDecidedLocked once the record deciding
+// it was written. If err is non-nil, the batch remains prepared.
+func (d *DB) finishPrepared(p *preparedBatch, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	p.deciding = false
+	d.mu.Unlock()
+
+	// A WAL rotation does not rewrite the record of a batch which is being
+	// decided, so write it again lest it be lost once the older WALs are
+	// deleted.
+	if !d.opts.DisableWAL {
+		_ = d.logPrepared(p, nil)
+	}
+	return err
+}
+
+// logPreparedLocked writes the records for all of the prepared batches to a
+// new WAL and syncs it. This allows older WALs to be deleted once their
+// memtables have been flushed without losing the prepared batches, which are
+// only present in the WAL. The batches being committed or rolled back are
+// skipped: the record deciding them follows, or if committing or rolling back
+// fails, finishPrepared writes their record again. d.mu must be held when
+// calling this.
+func (d *DB) logPreparedLocked(w *record.LogWriter) error {
+	xids := make([]string, 0, len(d.mu.prepared))
+	for xid, p := range d.mu.prepared {
+		if !p.deciding {
+			xids = append(xids, xid)
+		}
+	}
+	if len(xids) == 0 {
+		return nil
+	}
+	sort.Strings(xids)
+	for _, xid := range xids {
+		if _, err := w.WriteRecord(d.mu.prepared[xid].record()); err != nil {
+			return err
+		}
+	}
+	return w.Sync()
+}
@@ -0,0 +1,228 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestPrepare(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(key string) string {
+		v, err := d.Get([]byte(key))
+		if err == db.ErrNotFound {
+			return "<not found>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+	prepared := func() string {
+		var xids []string
+		for _, xid := range d.PreparedXIDs() {
+			xids = append(xids, string(xid))
+		}
+		return strings.Join(xids, " ")
+	}
+	prepare := func(xid string, keys ...string) {
+		b := d.NewBatch()
+		defer b.Close()
+		for _, key := range keys {
+			if err := b.Set([]byte(key), []byte(xid), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Prepare([]byte(xid), db.Sync); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prepare("x1", "a", "b")
+	prepare("x2", "c")
+	prepare("x3", "d")
+	if err := d.Prepare([]byte("x1"), d.NewBatch(), nil); err == nil {
+		t.Fatalf("expected error preparing x1 twice")
+	}
+	if v := prepared(); v != "x1 x2 x3" {
+		t.Fatalf("expected x1 x2 x3 to be prepared, but found %q", v)
+	}
+
+	// Prepared batches are not visible until they are committed.
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if v := get(key); v != "<not found>" {
+			t.Fatalf("expected %s to not be found, but found %q", key, v)
+		}
+	}
+	if err := d.CommitPrepared([]byte("x2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("c"); v != "x2" {
+		t.Fatalf("expected c=x2, but found %q", v)
+	}
+	if err := d.CommitPrepared([]byte("x2"), nil); err == nil {
+		t.Fatalf("expected error committing x2 twice")
+	}
+	if err := d.RollbackPrepared([]byte("x3"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := get("d"); v != "<not found>" {
+		t.Fatalf("expected d to not be found, but found %q", v)
+	}
+
+	// Flushing switches to a new WAL, after which the WAL containing the
+	// prepared batches is deleted.
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		d, err = Open("", &db.Options{
+			VFS: mem,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := prepared(); v != "x1" {
+			t.Fatalf("%d: expected x1 to be prepared, but found %q", i, v)
+		}
+		if v := fmt.Sprintf("%s %s %s %s", get("a"), get("b"), get("c"), get("d")); v != "<not found> <not found> x2 <not found>" {
+			t.Fatalf("%d: unexpected values %q", i, v)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err = Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CommitPrepared([]byte("x1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := prepared(); v != "" {
+		t.Fatalf("expected no prepared batches, but found %q", v)
+	}
+	if v := get("a") + " " + get("b"); v != "x1 x1" {
+		t.Fatalf("expected a=x1 b=x1, but found %q", v)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareRotateWhileDeciding(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepare := func(xid string) *preparedBatch {
+		b := d.NewBatch()
+		defer b.Close()
+		if err := b.Set([]byte(xid), []byte(xid), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Prepare([]byte(xid), db.Sync); err != nil {
+			t.Fatal(err)
+		}
+		p, err := d.decidePrepared([]byte(xid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// Rotate the WAL after the record committing x1 has been written, but
+	// before CommitPrepared has finished. The rotation must not rewrite the
+	// record preparing x1 after the record committing it.
+	p1 := prepare("x1")
+	b := newBatch(d)
+	_ = b.addXIDMarker(db.InternalKeyKindCommitXID, p1.xid)
+	_ = b.Apply(p1.ops, nil)
+	if err := d.Apply(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.release()
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.finishPrepared(p1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xids := d.PreparedXIDs(); len(xids) != 0 {
+		t.Fatalf("expected no prepared batches, but found %q", xids)
+	}
+
+	// Rotate the WAL while x2 is being rolled back, which then fails. The
+	// record preparing x2 must survive the deletion of the older WALs.
+	p2 := prepare("x2")
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.finishPrepared(p2, fmt.Errorf("injected")); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = Open("", &db.Options{
+		VFS: mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xids := d.PreparedXIDs(); len(xids) != 1 || string(xids[0]) != "x2" {
+		t.Fatalf("expected x2 to be prepared, but found %q", xids)
+	}
+	if v, err := d.Get([]byte("x1")); err != nil || string(v) != "x1" {
+		t.Fatalf("expected x1=x1, but found %q %v", v, err)
+	}
+	if err := d.CommitPrepared([]byte("x1"), nil); err == nil {
+		t.Fatalf("expected error committing x1 twice")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
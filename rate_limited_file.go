@@ -0,0 +1,49 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+// rateLimitedFile is a writable file whose writes reserve bytes from a
+// RateLimiter at a fixed priority.
+type rateLimitedFile struct {
+	vfs.File
+	limiter *db.RateLimiter
+	pri     db.IOPriority
+}
+
+// newRateLimitedFile wraps a writable file so that its writes are limited by
+// the specified RateLimiter. If the limiter is nil, the original file is
+// returned.
+func newRateLimitedFile(f vfs.File, limiter *db.RateLimiter, pri db.IOPriority) vfs.File {
+	if limiter == nil {
+		return f
+	}
+	return &rateLimitedFile{
+		File:    f,
+		limiter: limiter,
+		pri:     pri,
+	}
+}
+
+func (f *rateLimitedFile) Write(p []byte) (int, error) {
+	f.limiter.Request(len(p), f.pri)
+	return f.File.Write(p)
+}
+
+// Fd returns the file descriptor of the underlying file, if it has one, so
+// that vfs.NewSyncingFile can still sync the file incrementally.
+func (f *rateLimitedFile) Fd() uintptr {
+	type fd interface {
+		Fd() uintptr
+	}
+	if d, ok := f.File.(fd); ok {
+		return d.Fd()
+	}
+	return 0
+}
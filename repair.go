@@ -0,0 +1,336 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/arenaskl"
+	"github.com/petermattis/pebble/internal/record"
+	"github.com/petermattis/pebble/sstable"
+)
+
+// repairLostDir is the subdirectory of the database directory into which
+// Repair moves the sstables it is unable to read.
+const repairLostDir = "lost"
+
+// Repair rebuilds the MANIFEST of the DB whose files live in the given
+// directory, for instance when the CURRENT file or the MANIFEST is missing or
+// corrupt. Repair must not be called while the DB is open.
+//
+// Every sstable is read in order to recover its key range and its range of
+// sequence numbers. The sstables which cannot be read are moved to the "lost"
+// subdirectory. The contents of the WAL files are written to new sstables,
+// stopping at the first corrupt record of each WAL. The sstables are then
+// assigned to levels (see repairLevels), and a new MANIFEST describing them is
+// written. Batches which were prepared but neither committed nor rolled back
+// are discarded.
+//
+// The sequence number assigned to an ingested sstable is only recorded in the
+// MANIFEST. Until they are compacted, ingested sstables are repaired as if
+// their contents were older than any other data.
+func Repair(dirname string, opts *db.Options) error {
+	opts = opts.EnsureDefaults()
+	fs := opts.VFS
+
+	fileLock, err := fs.Lock(dbFilename(dirname, fileTypeLock, 0))
+	if err != nil {
+		return err
+	}
+	defer fileLock.Close()
+
+	d := newDB(dirname, opts)
+	defer d.commit.Close()
+	defer d.tableCache.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.versions.init(dirname, opts, &d.mu.Mutex)
+
+	ls, err := fs.List(dirname)
+	if err != nil {
+		return err
+	}
+	var tableNums, logNums []uint64
+	for _, filename := range ls {
+		ft, fn, ok := parseDBFilename(filename)
+		if !ok {
+			continue
+		}
+		d.mu.versions.markFileNumUsed(fn)
+		switch ft {
+		case fileTypeTable:
+			tableNums = append(tableNums, fn)
+		case fileTypeLog:
+			logNums = append(logNums, fn)
+		case fileTypeOptions:
+			if err := checkOptions(opts, filepath.Join(dirname, filename)); err != nil {
+				return err
+			}
+		}
+	}
+	sort.Slice(logNums, func(i, j int) bool {
+		return logNums[i] < logNums[j]
+	})
+
+	var tables []fileMetadata
+	for _, fileNum := range tableNums {
+		meta, err := repairTable(dirname, fileNum, opts)
+		if err != nil {
+			opts.Logger.Infof("pebble: repair: unable to read table %06d: %v", fileNum, err)
+			if err := repairMoveToLost(dirname, fileNum, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		tables = append(tables, meta)
+	}
+	// The batches whose sequence numbers are all smaller than the largest
+	// sequence number of the sstables have already been flushed. This is the
+	// case of every batch in the WAL files kept for recycling. The sequence
+	// numbers of the sstables which were zeroed, or which were ingested and
+	// whose sequence number was only recorded in the MANIFEST, are ignored.
+	var flushedSeqNum uint64
+	for i := range tables {
+		if largest := tables[i].largestSeqNum; largest > 0 && flushedSeqNum <= largest {
+			flushedSeqNum = largest + 1
+		}
+	}
+	for _, logNum := range logNums {
+		metas, err := d.repairWAL(dbFilename(dirname, fileTypeLog, logNum), logNum, flushedSeqNum)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, metas...)
+	}
+	if n := len(d.mu.prepared); n > 0 {
+		opts.Logger.Infof("pebble: repair: discarding %d prepared batches", n)
+	}
+
+	ve := versionEdit{
+		comparatorName: opts.Comparer.Name,
+		// The contents of the WAL files have been written to sstables.
+		logNumber: d.mu.versions.nextFileNum(),
+	}
+	if ve.newFiles, err = repairLevels(d.cmp, tables); err != nil {
+		return err
+	}
+	for i := range tables {
+		if ve.lastSequence <= tables[i].largestSeqNum {
+			ve.lastSequence = tables[i].largestSeqNum + 1
+		}
+	}
+	manifestFileNum := d.mu.versions.nextFileNum()
+	ve.nextFileNumber = d.mu.versions.nextFileNumber
+
+	// Check that the levels form a valid version before replacing the current
+	// manifest.
+	var bve bulkVersionEdit
+	bve.accumulate(&ve)
+	if _, err := bve.apply(opts, nil, d.cmp); err != nil {
+		return err
+	}
+	return writeManifest(dirname, opts, manifestFileNum, &ve)
+}
+
+// repairTable reads an sstable in order to recover its metadata.
+func repairTable(dirname string, fileNum uint64, opts *db.Options) (fileMetadata, error) {
+	meta := fileMetadata{fileNum: fileNum}
+	f, err := opts.VFS.Open(dbFilename(dirname, fileTypeTable, fileNum))
+	if err != nil {
+		return meta, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return meta, err
+	}
+	meta.size = uint64(stat.Size())
+
+	r := sstable.NewReader(f, fileNum, opts)
+	defer r.Close()
+
+	empty := true
+	update := func(smallest, largest db.InternalKey, seqNum uint64) {
+		if empty || db.InternalCompare(opts.Comparer.Compare, smallest, meta.smallest) < 0 {
+			meta.smallest = smallest.Clone()
+		}
+		if empty || db.InternalCompare(opts.Comparer.Compare, largest, meta.largest) > 0 {
+			meta.largest = largest.Clone()
+		}
+		if empty || meta.smallestSeqNum > seqNum {
+			meta.smallestSeqNum = seqNum
+		}
+		if empty || meta.largestSeqNum < seqNum {
+			meta.largestSeqNum = seqNum
+		}
+		empty = false
+	}
+
+	iter := r.NewIter(nil /* lower */, nil /* upper */)
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		update(*key, *key, key.SeqNum())
+	}
+	if err := iter.Close(); err != nil {
+		return meta, err
+	}
+	if rangeDelIter := r.NewRangeDelIter(); rangeDelIter != nil {
+		for key, value := rangeDelIter.First(); key != nil; key, value = rangeDelIter.Next() {
+			update(*key, db.MakeRangeDeleteSentinelKey(value), key.SeqNum())
+		}
+		if err := rangeDelIter.Close(); err != nil {
+			return meta, err
+		}
+	}
+	if empty {
+		return meta, fmt.Errorf("pebble: table %06d is empty", fileNum)
+	}
+	meta.creationTime = r.Properties.CreationTime
+	return meta, nil
+}
+
+// repairMoveToLost moves an sstable which cannot be read out of the way, so
+// that it is neither referenced by the new manifest, nor deleted as obsolete
+// once the DB is opened.
+func repairMoveToLost(dirname string, fileNum uint64, opts *db.Options) error {
+	lostDir := filepath.Join(dirname, repairLostDir)
+	if err := opts.VFS.MkdirAll(lostDir, 0755); err != nil {
+		return err
+	}
+	return opts.VFS.Rename(dbFilename(dirname, fileTypeTable, fileNum),
+		dbFilename(lostDir, fileTypeTable, fileNum))
+}
+
+// repairWAL writes the contents of a WAL file to new sstables, skipping the
+// batches whose sequence numbers are all smaller than flushedSeqNum. Reading
+// the WAL stops at the first corrupt record, as the following records cannot
+// be applied without breaking the atomicity of the batches.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) repairWAL(
+	filename string, logNum uint64, flushedSeqNum uint64,
+) ([]fileMetadata, error) {
+	file, err := d.opts.VFS.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		metas []fileMetadata
+		b     Batch
+		buf   bytes.Buffer
+		mem   = newMemTable(d.opts)
+		rr    = record.NewReader(file, logNum)
+	)
+	flush := func() error {
+		if mem.empty() {
+			return nil
+		}
+		// Range tombstones cannot be elided, and sequence numbers cannot be
+		// zeroed, as the sstables being repaired are not in the current version.
+		iter := mem.newIter(nil)
+		if rangeDelIter := mem.newRangeDelIter(nil); rangeDelIter != nil {
+			iter = newMergingIter(d.cmp, iter, rangeDelIter)
+		}
+		meta, _, err := d.writeLevel0Table(d.opts.VFS, iter,
+			false /* allowRangeTombstoneElision */)
+		if err != nil {
+			return err
+		}
+		delete(d.mu.compact.pendingOutputs, meta.fileNum)
+		metas = append(metas, meta)
+		mem = newMemTable(d.opts)
+		return nil
+	}
+
+	for {
+		r, err := rr.Next()
+		if err == nil {
+			_, err = io.Copy(&buf, r)
+		}
+		if err != nil {
+			if err != io.EOF && err != record.ErrZeroedChunk {
+				d.opts.Logger.Infof("pebble: repair: %s: ignoring the rest of the log: %v", filename, err)
+			}
+			break
+		}
+
+		b = Batch{}
+		ok, err := d.decodeWALBatch(&b, buf.Bytes(), filename)
+		if err != nil {
+			d.opts.Logger.Infof("pebble: repair: %v: ignoring the rest of the log", err)
+			break
+		}
+		if ok && b.seqNum()+uint64(b.count()) > flushedSeqNum {
+			err := mem.prepare(&b)
+			if err == arenaskl.ErrArenaFull && !mem.empty() {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				err = mem.prepare(&b)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := mem.apply(&b, b.seqNum()); err != nil {
+				return nil, err
+			}
+			mem.unref()
+		}
+		buf.Reset()
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+// repairLevels assigns the sstables recovered by Repair to levels. The
+// sstables are placed from the newest to the oldest, as ordered by their
+// largest sequence numbers, each one in the highest level possible: below
+// every overlapping sstable placed before it, and in L0 only if it is older
+// than all the L0 sstables, as L0 sstables must be in increasing sequence
+// number order. This preserves the order of the versions of a key as long as
+// its newer versions are in sstables with larger sequence numbers, which is
+// the case for the sstables flushed from the memtables and the WALs.
+func repairLevels(cmp db.Compare, tables []fileMetadata) ([]newFileEntry, error) {
+	sort.Sort(sort.Reverse(bySeqNum(tables)))
+
+	var levels [numLevels][]*fileMetadata
+	entries := make([]newFileEntry, 0, len(tables))
+	for i := range tables {
+		t := &tables[i]
+		level := 0
+		for l := 1; l < numLevels; l++ {
+			for _, f := range levels[l] {
+				if cmp(t.smallest.UserKey, f.largest.UserKey) <= 0 &&
+					cmp(f.smallest.UserKey, t.largest.UserKey) <= 0 {
+					level = l + 1
+					break
+				}
+			}
+		}
+		if level == 0 {
+			for _, f := range levels[0] {
+				if f.smallestSeqNum <= t.smallestSeqNum || f.largestSeqNum <= t.largestSeqNum {
+					level = 1
+					break
+				}
+			}
+		}
+		if level >= numLevels {
+			return nil, fmt.Errorf("pebble: repair: too many overlapping tables to place table %06d", t.fileNum)
+		}
+		levels[level] = append(levels[level], t)
+		entries = append(entries, newFileEntry{level: level, meta: *t})
+	}
+	return entries, nil
+}
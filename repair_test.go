@@ -0,0 +1,134 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestRepair(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &db.Options{
+		VFS:          mem,
+		MemTableSize: 64 << 10,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(uint64(0)))
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("%04d", rng.Intn(1000)))
+			switch rng.Intn(10) {
+			case 0:
+				require.NoError(t, d.Delete(key, nil))
+			case 1:
+				end := []byte(fmt.Sprintf("%04d", rng.Intn(1000)))
+				if string(end) > string(key) {
+					require.NoError(t, d.DeleteRange(key, end, nil))
+				}
+			default:
+				require.NoError(t, d.Set(key, []byte(fmt.Sprintf("%d-%d", i, j)), nil))
+			}
+		}
+		switch {
+		case i == 8 || i == 14:
+			require.NoError(t, d.Compact([]byte("0000"), []byte("9999")))
+		case rng.Intn(3) == 0:
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	contents := func(d *DB) string {
+		var buf strings.Builder
+		iter := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s\n", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+	expected := contents(d)
+	require.NoError(t, d.Close())
+
+	// Lose the CURRENT file and the MANIFEST.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, filename := range ls {
+		if ft, _, ok := parseDBFilename(filename); ok &&
+			(ft == fileTypeCurrent || ft == fileTypeManifest) {
+			require.NoError(t, mem.Remove(filename))
+		}
+	}
+	// Add an sstable which cannot be read.
+	f, err := mem.Create("999999.sst")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("not an sstable"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, Repair("", opts))
+	if _, err := mem.Stat("lost/999999.sst"); err != nil {
+		t.Fatalf("expected the unreadable sstable to be moved: %v", err)
+	}
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, expected, contents(d))
+	require.NoError(t, d.Set([]byte("new"), []byte("value"), nil))
+	require.NoError(t, d.Close())
+
+	// Repairing a healthy DB does not change its contents.
+	require.NoError(t, Repair("", opts))
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, expected+"new:value\n", contents(d))
+	require.NoError(t, d.Close())
+}
+
+func TestRepairLevels(t *testing.T) {
+	table := func(fileNum uint64, smallest, largest string, smallestSeqNum, largestSeqNum uint64) fileMetadata {
+		return fileMetadata{
+			fileNum:        fileNum,
+			smallest:       db.MakeInternalKey([]byte(smallest), largestSeqNum, db.InternalKeyKindSet),
+			largest:        db.MakeInternalKey([]byte(largest), smallestSeqNum, db.InternalKeyKindSet),
+			smallestSeqNum: smallestSeqNum,
+			largestSeqNum:  largestSeqNum,
+		}
+	}
+	tables := []fileMetadata{
+		// Flushed sstables.
+		table(10, "a", "z", 30, 40),
+		table(11, "c", "e", 41, 50),
+		// An sstable which overlaps the flushed sstables and is older.
+		table(5, "b", "d", 1, 35),
+		// sstables with zeroed sequence numbers, which cannot all be placed in
+		// L0.
+		table(3, "a", "b", 0, 0),
+		table(4, "c", "d", 0, 0),
+		table(6, "x", "y", 0, 0),
+	}
+	entries, err := repairLevels(db.DefaultComparer.Compare, tables)
+	require.NoError(t, err)
+
+	var buf strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%06d: L%d\n", e.meta.fileNum, e.level)
+	}
+	require.Equal(t, `000011: L0
+000010: L0
+000005: L0
+000006: L0
+000004: L1
+000003: L1
+`, buf.String())
+}
@@ -0,0 +1,411 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/internal/arenaskl"
+	"github.com/petermattis/pebble/internal/record"
+)
+
+// secondaryCatchUpAttempts is the number of times a catch-up is attempted
+// when a file disappears because the primary deleted it while it was read.
+const secondaryCatchUpAttempts = 10
+
+// secondaryState is the state of a DB opened with OpenSecondary: the position
+// reached in the primary's manifest and WAL files.
+type secondaryState struct {
+	// The manifest being tailed, and the offset just past the last version edit
+	// read from it.
+	manifestFileNum uint64
+	manifestOffset  int64
+	// The WAL files whose contents have not been flushed to sstables, in
+	// increasing file number order.
+	logs []*secondaryLog
+}
+
+// secondaryLog is a WAL file tailed by a secondary.
+type secondaryLog struct {
+	fileNum uint64
+	// The offset just past the last batch replayed from the WAL, and the
+	// sequence number following that batch.
+	offset int64
+	seqNum uint64
+	// The memtables holding the replayed batches. Batches are replayed into the
+	// last memtable until it is full.
+	mems []*memTable
+}
+
+// OpenSecondary opens a secondary instance of the DB whose files live in
+// primaryDir, and which is owned by another process, the primary. A secondary
+// is read-only (see Options.ReadOnly). It reads the primary's MANIFEST and WAL
+// files, without modifying them, in order to serve reads from the state of the
+// primary as of the time it was opened or of the last call to TryCatchUp.
+//
+// Any number of secondaries can be opened on the same primary, but each
+// requires its own secondaryDir, which is locked for the lifetime of the
+// secondary.
+func OpenSecondary(primaryDir, secondaryDir string, opts *db.Options) (*DB, error) {
+	o := *opts.EnsureDefaults()
+	o.ReadOnly = true
+	opts = &o
+	d := newDB(primaryDir, opts)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := opts.VFS.MkdirAll(secondaryDir, 0755); err != nil {
+		return nil, err
+	}
+	fileLock, err := opts.VFS.Lock(dbFilename(secondaryDir, fileTypeLock, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if fileLock != nil {
+			fileLock.Close()
+		}
+	}()
+
+	if _, err := opts.VFS.Stat(dbFilename(primaryDir, fileTypeCurrent, 0)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("pebble: database %q does not exist", primaryDir)
+		}
+		return nil, fmt.Errorf("pebble: database %q: %v", primaryDir, err)
+	}
+
+	ls, err := opts.VFS.List(primaryDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range ls {
+		if ft, _, ok := parseDBFilename(filename); ok && ft == fileTypeOptions {
+			err := checkOptions(opts, filepath.Join(primaryDir, filename))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	d.mu.versions.init(primaryDir, opts, &d.mu.Mutex)
+	d.mu.secondary = &secondaryState{}
+	if err := d.tryCatchUpLocked(); err != nil {
+		return nil, err
+	}
+
+	d.fileLock, fileLock = fileLock, nil
+	return d, nil
+}
+
+// TryCatchUp brings a DB opened with OpenSecondary up to date with the
+// primary, by reading the version edits appended to the primary's MANIFEST,
+// and the batches appended to its WAL files, since the previous catch-up.
+// Reads observe the new state once TryCatchUp returns.
+//
+// TryCatchUp tolerates the primary deleting the files it reads. After an
+// error, reads keep observing the state of the previous catch-up, and the
+// catch-up can be retried, for instance when the error is due to a record the
+// primary was writing. The WAL batches replayed before the error are retained
+// in memory, though not visible, so that a retry does not replay them again.
+func (d *DB) TryCatchUp() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mu.secondary == nil {
+		return errors.New("pebble: not a secondary instance")
+	}
+	if d.mu.closed {
+		return errors.New("pebble: closed")
+	}
+	return d.tryCatchUpLocked()
+}
+
+// tryCatchUpLocked catches up with the primary, starting over whenever a file
+// disappears because the primary deleted it. A deleted MANIFEST has been
+// replaced by a new one, and a deleted WAL or sstable has been flushed or
+// compacted, so that a new attempt sees the newer state of the primary.
+//
+// d.mu must be held when calling this. It is held for the duration of the
+// catch-up.
+func (d *DB) tryCatchUpLocked() error {
+	for attempt := 1; ; attempt++ {
+		err := d.catchUpLocked()
+		if err == nil || !os.IsNotExist(err) || attempt == secondaryCatchUpAttempts {
+			return err
+		}
+	}
+}
+
+// catchUpLocked performs a single catch-up attempt. See TryCatchUp.
+//
+// d.mu must be held when calling this.
+func (d *DB) catchUpLocked() error {
+	s := d.mu.secondary
+	vs := &d.mu.versions
+
+	name, err := readCurrentFile(vs.fs, vs.dirname)
+	if err != nil {
+		return err
+	}
+	ft, manifestFileNum, ok := parseDBFilename(string(name))
+	if !ok || ft != fileTypeManifest {
+		return fmt.Errorf("pebble: CURRENT file for DB %q is malformed", vs.dirname)
+	}
+
+	// Read the version edits appended to the manifest since the previous
+	// catch-up. A new manifest, which the primary creates when the previous one
+	// grows too large, starts with a snapshot of the current version, and is
+	// read from the start.
+	var (
+		base           *version
+		offset         int64
+		logNumber      = vs.logNumber
+		prevLogNumber  = vs.prevLogNumber
+		nextFileNumber = vs.nextFileNumber
+		lastSequence   = atomic.LoadUint64(&vs.logSeqNum)
+		bve            bulkVersionEdit
+		edits          int
+	)
+	if manifestFileNum == s.manifestFileNum {
+		base = vs.currentVersion()
+		offset = s.manifestOffset
+	}
+	manifest, err := vs.fs.Open(dbFilename(vs.dirname, fileTypeManifest, manifestFileNum))
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	rr := record.NewReader(io.NewSectionReader(manifest, 0, math.MaxInt64), 0 /* logNum */)
+	if offset > 0 {
+		// An error, including io.EOF when no edit has been appended, is returned
+		// again by rr.Next.
+		_ = rr.SeekRecord(offset)
+	}
+	for {
+		r, err := rr.Next()
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			if isEndOfRecords(err) {
+				break
+			}
+			return err
+		}
+		var ve versionEdit
+		if err := ve.decode(bytes.NewReader(data)); err != nil {
+			return err
+		}
+		if ve.comparatorName != "" && ve.comparatorName != vs.cmpName {
+			return fmt.Errorf("pebble: manifest file %q for DB %q: "+
+				"comparer name from file %q != comparer name from db.Options %q",
+				name, vs.dirname, ve.comparatorName, vs.cmpName)
+		}
+		bve.accumulate(&ve)
+		if ve.logNumber != 0 {
+			logNumber = ve.logNumber
+		}
+		if ve.prevLogNumber != 0 {
+			prevLogNumber = ve.prevLogNumber
+		}
+		if ve.nextFileNumber != 0 {
+			nextFileNumber = ve.nextFileNumber
+		}
+		if ve.lastSequence > lastSequence {
+			lastSequence = ve.lastSequence
+		}
+		offset = rr.Offset()
+		edits++
+	}
+
+	// The sstables added since the previous catch-up may already have been
+	// compacted and deleted by the primary, in which case the version edits
+	// deleting them can now be read.
+	for level := range bve.added {
+		for i := range bve.added[level] {
+			fileNum := bve.added[level][i].fileNum
+			if bve.deleted[level][fileNum] {
+				continue
+			}
+			if _, err := vs.fs.Stat(dbFilename(vs.dirname, fileTypeTable, fileNum)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Replay the batches appended to the WAL files whose contents have not been
+	// flushed. The WAL files which have been flushed are dropped along with
+	// their memtables.
+	logs := make([]*secondaryLog, 0, len(s.logs))
+	for _, l := range s.logs {
+		if l.fileNum >= logNumber || l.fileNum == prevLogNumber {
+			logs = append(logs, l)
+		}
+	}
+	ls, err := vs.fs.List(vs.dirname)
+	if err != nil {
+		return err
+	}
+	for _, filename := range ls {
+		ft, fn, ok := parseDBFilename(filename)
+		if !ok || ft != fileTypeLog || (fn < logNumber && fn != prevLogNumber) {
+			continue
+		}
+		found := false
+		for _, l := range logs {
+			if l.fileNum == fn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			logs = append(logs, &secondaryLog{fileNum: fn})
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].fileNum < logs[j].fileNum
+	})
+	s.logs = logs
+	for _, l := range logs {
+		if err := d.tailWAL(l); err != nil {
+			return err
+		}
+		if lastSequence < l.seqNum {
+			lastSequence = l.seqNum
+		}
+	}
+
+	// Install the new state.
+	if base == nil || edits > 0 {
+		v, err := bve.apply(d.opts, base, vs.cmp)
+		if err != nil {
+			return err
+		}
+		vs.append(v)
+	}
+	vs.logNumber = logNumber
+	vs.prevLogNumber = prevLogNumber
+	vs.nextFileNumber = nextFileNumber
+	s.manifestFileNum = manifestFileNum
+	s.manifestOffset = offset
+
+	queue := make([]flushable, 0, len(logs)+1)
+	for _, l := range logs {
+		for _, mem := range l.mems {
+			queue = append(queue, mem)
+		}
+	}
+	d.mu.mem.queue = append(queue, d.mu.mem.mutable)
+	d.updateReadStateLocked()
+	atomic.StoreUint64(&vs.logSeqNum, lastSequence)
+	atomic.StoreUint64(&vs.visibleSeqNum, lastSequence)
+
+	// The sstables which are no longer referenced are closed, though the primary
+	// is responsible for deleting them.
+	for _, fileNum := range vs.obsoleteTables {
+		d.tableCache.evict(fileNum)
+	}
+	vs.obsoleteTables = nil
+	return nil
+}
+
+// tailWAL replays the batches appended to a WAL file since it was last
+// tailed. The offset reached in the WAL, and the sequence number following the
+// last replayed batch, are recorded as each batch is replayed, so that a batch
+// is never replayed twice.
+//
+// d.mu must be held when calling this.
+func (d *DB) tailWAL(l *secondaryLog) error {
+	filename := dbFilename(d.dirname, fileTypeLog, l.fileNum)
+	file, err := d.opts.VFS.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		b   Batch
+		buf bytes.Buffer
+		rr  = record.NewReader(io.NewSectionReader(file, 0, math.MaxInt64), l.fileNum)
+	)
+	if l.offset > 0 {
+		// An error, including io.EOF when no batch has been appended, is returned
+		// again by rr.Next.
+		_ = rr.SeekRecord(l.offset)
+	}
+	for {
+		r, err := rr.Next()
+		if err == nil {
+			_, err = io.Copy(&buf, r)
+		}
+		if err != nil {
+			if isEndOfRecords(err) {
+				break
+			}
+			return err
+		}
+
+		b = Batch{}
+		if ok, err := d.decodeWALBatch(&b, buf.Bytes(), filename); err != nil {
+			return err
+		} else if ok {
+			seqNum := b.seqNum()
+			if err := l.apply(&b, seqNum, d.opts); err != nil {
+				return err
+			}
+			l.seqNum = seqNum + uint64(b.count())
+		}
+		l.offset = rr.Offset()
+		buf.Reset()
+	}
+	return nil
+}
+
+// apply applies a batch replayed from the WAL to the last memtable, starting
+// a new memtable when it is full.
+func (l *secondaryLog) apply(b *Batch, seqNum uint64, opts *db.Options) error {
+	if len(l.mems) == 0 {
+		l.newMemTable(opts)
+	}
+	mem := l.mems[len(l.mems)-1]
+	err := mem.prepare(b)
+	if err == arenaskl.ErrArenaFull && !mem.empty() {
+		mem = l.newMemTable(opts)
+		err = mem.prepare(b)
+	}
+	if err != nil {
+		return err
+	}
+	if err := mem.apply(b, seqNum); err != nil {
+		return err
+	}
+	mem.unref()
+	return nil
+}
+
+func (l *secondaryLog) newMemTable(opts *db.Options) *memTable {
+	mem := newMemTable(opts)
+	mem.logNum = l.fileNum
+	l.mems = append(l.mems, mem)
+	return mem
+}
+
+// isEndOfRecords returns whether an error returned while reading a MANIFEST
+// or WAL file marks the end of the records written so far. It is common to
+// encounter a zeroed chunk due to WAL preallocation.
+func isEndOfRecords(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == record.ErrZeroedChunk
+}
@@ -34,7 +34,7 @@ func (s *Snapshot) Get(key []byte) ([]byte, error) {
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
 func (s *Snapshot) NewIter(o *db.IterOptions) *Iterator {
-	return s.db.newIterInternal(nil /* batchIter */, nil /* batchRangeDelIter */, s, o)
+	return s.db.newIterInternal(nil /* batch */, s, o)
 }
 
 // Close closes the snapshot, releasing its resources. Close must be
@@ -44,6 +44,9 @@ func (s *Snapshot) NewIter(o *db.IterOptions) *Iterator {
 func (s *Snapshot) Close() error {
 	s.db.mu.Lock()
 	s.db.mu.snapshots.remove(s)
+	// Releasing the snapshot may allow the sstables covered by a range
+	// tombstone to be deleted.
+	s.db.maybeScheduleCompaction()
 	s.db.mu.Unlock()
 	return nil
 }
@@ -354,6 +354,12 @@ func (i *blockIter) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return nil, nil
 }
 
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package.
+func (i *blockIter) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	return i.SeekGE(key)
+}
+
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package.
 func (i *blockIter) SeekLT(key []byte) (*db.InternalKey, []byte) {
@@ -645,4 +651,5 @@ func (i *blockIter) invalidateLower() {
 // invalidate the iterator, positioning it after the last entry.
 func (i *blockIter) invalidateUpper() {
 	i.offset = i.restarts
+	i.nextOffset = i.restarts
 }
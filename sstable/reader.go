@@ -206,6 +206,32 @@ func (i *Iterator) SeekGE(key []byte) (*db.InternalKey, []byte) {
 	return ikey, val
 }
 
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package. If the table has a filter which indicates that the prefix
+// is not present in the table, the iterator is exhausted without reading any
+// data blocks. Note that SeekPrefixGE only checks the upper bound. It is up
+// to the caller to ensure that key is greater than or equal to the lower
+// bound.
+func (i *Iterator) SeekPrefixGE(prefix, key []byte) (*db.InternalKey, []byte) {
+	if i.err != nil {
+		return nil, nil
+	}
+
+	if r := i.reader; r.tableFilter != nil {
+		data, err := r.readFilter()
+		if err != nil {
+			i.err = err
+			return nil, nil
+		}
+		if !r.tableFilter.mayContain(data, prefix) {
+			i.index.invalidateUpper()
+			i.data.invalidateUpper()
+			return nil, nil
+		}
+	}
+	return i.SeekGE(key)
+}
+
 // SeekLT implements internalIterator.SeekLT, as documented in the pebble
 // package. Note that SeekLT only checks the lower bound. It is up to the
 // caller to ensure that key is less than the upper bound.
@@ -433,6 +459,7 @@ type Reader struct {
 	file        vfs.File
 	fileNum     uint64
 	err         error
+	metaindexBH blockHandle
 	index       weakCachedBlock
 	filter      weakCachedBlock
 	rangeDel    weakCachedBlock
@@ -473,26 +500,14 @@ func (r *Reader) get(key []byte) (value []byte, err error) {
 		return nil, r.err
 	}
 
-	if r.tableFilter != nil {
-		data, err := r.readFilter()
-		if err != nil {
-			return nil, err
-		}
-		var lookupKey []byte
-		if r.split != nil {
-			lookupKey = key[:r.split(key)]
-		} else {
-			lookupKey = key
-		}
-		if !r.tableFilter.mayContain(data, lookupKey) {
-			return nil, db.ErrNotFound
-		}
+	prefix := key
+	if r.split != nil {
+		prefix = key[:r.split(key)]
 	}
 
 	i := iterPool.Get().(*Iterator)
 	if err := i.Init(r, nil, nil); err == nil {
-		i.index.SeekGE(key)
-		i.seekBlock(key)
+		i.SeekPrefixGE(prefix, key)
 	}
 
 	if !i.Valid() || r.compare(key, i.Key().UserKey) != 0 {
@@ -535,6 +550,69 @@ func (r *Reader) NewRangeDelIter() *blockIter {
 	return i
 }
 
+// ValidateBlockChecksums verifies the checksums of every block of the table,
+// reading the blocks from the file rather than from the cache.
+func (r *Reader) ValidateBlockChecksums() error {
+	if r.err != nil {
+		return r.err
+	}
+	handles := []blockHandle{r.metaindexBH, r.index.bh}
+
+	// The metaindex block refers to the properties, filter and range-del
+	// blocks, including the filter blocks which are ignored by the reader.
+	b, err := r.readRawBlock(r.metaindexBH)
+	if err != nil {
+		return err
+	}
+	b, err = decompressBlock(b[r.metaindexBH.length], b[:r.metaindexBH.length])
+	if err != nil {
+		return err
+	}
+	metaIter, err := newRawBlockIter(bytes.Compare, b)
+	if err != nil {
+		return err
+	}
+	for valid := metaIter.First(); valid; valid = metaIter.Next() {
+		bh, n := decodeBlockHandle(metaIter.Value())
+		if n == 0 {
+			return errors.New("pebble/table: invalid table (bad metaindex entry)")
+		}
+		handles = append(handles, bh)
+	}
+	if err := metaIter.Close(); err != nil {
+		return err
+	}
+
+	index, err := r.readIndex()
+	if err != nil {
+		return err
+	}
+	iter, err := newBlockIter(r.compare, index)
+	if err != nil {
+		return err
+	}
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		bh, n := decodeBlockHandle(value)
+		if n == 0 || n != len(value) {
+			return errors.New("pebble/table: corrupt index entry")
+		}
+		handles = append(handles, bh)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	for _, bh := range handles {
+		if bh.length == 0 {
+			// The block is not present.
+			continue
+		}
+		if _, err := r.readRawBlock(bh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *Reader) readIndex() (block, error) {
 	return r.readWeakCachedBlock(&r.index)
 }
@@ -604,29 +682,43 @@ func (r *Reader) readBlock(bh blockHandle) (block, cache.WeakHandle, error) {
 		return b, nil, nil
 	}
 
+	b, err := r.readRawBlock(bh)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err = decompressBlock(b[bh.length], b[:bh.length])
+	if err != nil {
+		return nil, nil, err
+	}
+	h := r.cache.Set(r.fileNum, bh.offset, b)
+	return b, h, nil
+}
+
+// decompressBlock decompresses the contents of a block according to the
+// compression type stored in the block trailer.
+func decompressBlock(blockType byte, b []byte) ([]byte, error) {
+	switch blockType {
+	case noCompressionBlockType:
+		return b, nil
+	case snappyCompressionBlockType:
+		return snappy.Decode(nil, b)
+	}
+	return nil, fmt.Errorf("pebble/table: unknown block compression: %d", blockType)
+}
+
+// readRawBlock reads a block and its trailer from disk, and verifies the
+// checksum of the block.
+func (r *Reader) readRawBlock(bh blockHandle) ([]byte, error) {
 	b := make([]byte, bh.length+blockTrailerLen)
 	if _, err := r.file.ReadAt(b, int64(bh.offset)); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	checksum0 := binary.LittleEndian.Uint32(b[bh.length+1:])
 	checksum1 := crc.New(b[:bh.length+1]).Value()
 	if checksum0 != checksum1 {
-		return nil, nil, errors.New("pebble/table: invalid table (checksum mismatch)")
+		return nil, errors.New("pebble/table: invalid table (checksum mismatch)")
 	}
-	switch b[bh.length] {
-	case noCompressionBlockType:
-		b = b[:bh.length]
-		h := r.cache.Set(r.fileNum, bh.offset, b)
-		return b, h, nil
-	case snappyCompressionBlockType:
-		b, err := snappy.Decode(nil, b[:bh.length])
-		if err != nil {
-			return nil, nil, err
-		}
-		h := r.cache.Set(r.fileNum, bh.offset, b)
-		return b, h, nil
-	}
-	return nil, nil, fmt.Errorf("pebble/table: unknown block compression: %d", b[bh.length])
+	return b, nil
 }
 
 func (r *Reader) readMetaindex(metaindexBH blockHandle, o *db.Options) error {
@@ -651,6 +743,7 @@ func (r *Reader) readMetaindex(metaindexBH blockHandle, o *db.Options) error {
 		return err
 	}
 
+	r.metaindexBH = metaindexBH
 	if bh, ok := meta[metaPropertiesName]; ok {
 		b, _, err = r.readBlock(bh)
 		if err != nil {
@@ -699,9 +792,30 @@ func (r *Reader) readMetaindex(metaindexBH blockHandle, o *db.Options) error {
 			break
 		}
 	}
+
+	if r.tableFilter != nil && !r.filterCompatible(o) {
+		// The filter was built on keys that do not match the prefixes we would
+		// use to probe it. Rather than risk false negatives, ignore the filter.
+		r.tableFilter = nil
+		r.filter.bh = blockHandle{}
+	}
 	return nil
 }
 
+// filterCompatible returns true if the table filter was constructed from the
+// same prefixes that the reader will probe it with. If the comparer has a
+// Split function, the filter must have been built from prefixes extracted by
+// the same comparer. Otherwise, the filter must have been built from whole
+// keys.
+func (r *Reader) filterCompatible(o *db.Options) bool {
+	if r.split != nil {
+		return r.Properties.PrefixFiltering &&
+			r.Properties.PrefixExtractorName == o.Comparer.Name
+	}
+	// Tables written without the filtering properties used whole key filters.
+	return r.Properties.WholeKeyFiltering || !r.Properties.PrefixFiltering
+}
+
 // NewReader returns a new table reader for the file. Closing the reader will
 // close the file.
 func NewReader(f vfs.File, fileNum uint64, o *db.Options) *Reader {
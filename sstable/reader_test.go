@@ -233,6 +233,118 @@ func runTestReader(t *testing.T, o db.Options) {
 	})
 }
 
+// recordingFilterPolicy wraps a FilterPolicy and records the keys the filter
+// is probed with.
+type recordingFilterPolicy struct {
+	db.FilterPolicy
+	probes []string
+}
+
+func (p *recordingFilterPolicy) MayContain(ftype db.FilterType, filter, key []byte) bool {
+	p.probes = append(p.probes, string(key))
+	return p.FilterPolicy.MayContain(ftype, filter, key)
+}
+
+func TestReaderPrefixFilter(t *testing.T) {
+	// mvccComparer splits keys of the form "<prefix>@<version>" at the "@".
+	mvccComparer := *db.DefaultComparer
+	mvccComparer.Name = "mvcc-comparer"
+	mvccComparer.Split = func(a []byte) int {
+		if i := bytes.IndexByte(a, '@'); i >= 0 {
+			return i
+		}
+		return len(a)
+	}
+
+	mem := vfs.NewMem()
+	f0, err := mem.Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f0, &db.Options{Comparer: &mvccComparer}, db.LevelOptions{
+		FilterPolicy: bloom.FilterPolicy(100),
+	})
+	for _, k := range []string{"a@1", "a@2", "c@3", "e@1"} {
+		if err := w.Add(db.MakeInternalKey([]byte(k), 0, db.InternalKeyKindSet), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(comparer *db.Comparer) (*Reader, *recordingFilterPolicy) {
+		f, err := mem.Open("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp := &recordingFilterPolicy{FilterPolicy: bloom.FilterPolicy(100)}
+		return NewReader(f, 0, &db.Options{
+			Comparer: comparer,
+			Levels:   []db.LevelOptions{{FilterPolicy: fp}},
+		}), fp
+	}
+
+	r, fp := open(&mvccComparer)
+	if !r.Properties.PrefixFiltering || r.Properties.WholeKeyFiltering ||
+		r.Properties.PrefixExtractorName != mvccComparer.Name {
+		t.Fatalf("unexpected filter properties: %+v", r.Properties)
+	}
+	testCases := []struct {
+		prefix, key string
+		expected    string
+	}{
+		{"a", "a@0", "a@1"},
+		{"a", "a@2", "a@2"},
+		{"b", "b@5", ""},
+		{"c", "c", "c@3"},
+		{"d", "d@1", ""},
+	}
+	for _, c := range testCases {
+		i := r.NewIter(nil, nil)
+		var got string
+		if key, _ := i.SeekPrefixGE([]byte(c.prefix), []byte(c.key)); key != nil {
+			got = string(key.UserKey)
+		}
+		if err := i.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.expected {
+			t.Fatalf("%s: expected %q, but found %q", c.key, c.expected, got)
+		}
+	}
+	if _, err := r.get([]byte("d@9")); err != db.ErrNotFound {
+		t.Fatalf("expected not found, but found %v", err)
+	}
+	if expected := []string{"a", "a", "b", "c", "d", "d"}; fmt.Sprint(fp.probes) != fmt.Sprint(expected) {
+		t.Fatalf("expected probes %q, but found %q", expected, fp.probes)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader whose comparer does not match the prefix extractor used to
+	// build the filter must not consult the filter.
+	otherComparer := mvccComparer
+	otherComparer.Name = "other-comparer"
+	for _, comparer := range []*db.Comparer{&otherComparer, db.DefaultComparer} {
+		r, fp := open(comparer)
+		i := r.NewIter(nil, nil)
+		if key, _ := i.SeekPrefixGE([]byte("b@5"), []byte("b@5")); key == nil || string(key.UserKey) != "c@3" {
+			t.Fatalf("%s: expected c@3, but found %v", comparer.Name, key)
+		}
+		if err := i.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(fp.probes) != 0 {
+			t.Fatalf("%s: expected filter to be ignored, but found probes %q", comparer.Name, fp.probes)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func buildBenchmarkTable(b *testing.B, blockSize, restartInterval int) (*Reader, [][]byte) {
 	mem := vfs.NewMem()
 	f0, err := mem.Create("bench")
@@ -358,3 +470,77 @@ func BenchmarkTableIterPrev(b *testing.B) {
 			})
 	}
 }
+
+func TestReaderValidateBlockChecksums(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f, nil, db.LevelOptions{
+		BlockSize:    64,
+		FilterPolicy: bloom.FilterPolicy(10),
+	})
+	for i := 0; i < 50; i++ {
+		key := db.MakeInternalKey([]byte(fmt.Sprintf("%04d", i)), 0, db.InternalKeyKindSet)
+		if err := w.Add(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rangeDel := db.MakeInternalKey([]byte("0010"), 0, db.InternalKeyKindRangeDelete)
+	if err := w.Add(rangeDel, []byte("0020")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = mem.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(fileNum uint64, data []byte) *Reader {
+		f, err := mem.Create("corrupt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if f, err = mem.Open("corrupt"); err != nil {
+			t.Fatal(err)
+		}
+		return NewReader(f, fileNum, nil)
+	}
+
+	r := open(0, data)
+	if err := r.ValidateBlockChecksums(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupting any byte which precedes the footer is detected, either when
+	// the table is opened or when the block checksums are validated.
+	corrupt := make([]byte, len(data))
+	for i := 0; i < len(data)-rocksDBFooterLen; i++ {
+		copy(corrupt, data)
+		corrupt[i] ^= 0xff
+		r := open(uint64(i+1), corrupt)
+		if err := r.ValidateBlockChecksums(); err == nil {
+			t.Fatalf("expected corruption at offset %d to be detected", i)
+		}
+		r.Close()
+	}
+}
@@ -312,6 +312,14 @@ var (
 	tmpFileCount  int
 )
 
+// epochClock always returns the Unix epoch, which matches the creation time
+// recorded in the pre-made tables.
+type epochClock struct{}
+
+func (epochClock) Now() time.Time {
+	return time.Unix(0, 0)
+}
+
 func build(
 	compression db.Compression,
 	fp db.FilterPolicy,
@@ -336,6 +344,7 @@ func build(
 	defer f0.Close()
 	tmpFileCount++
 	w := NewWriter(f0, &db.Options{
+		Clock: epochClock{},
 		Merger: &db.Merger{
 			Name: "nullptr",
 		},
@@ -28,6 +28,9 @@ type WriterMetadata struct {
 	LargestRange   db.InternalKey
 	SmallestSeqNum uint64
 	LargestSeqNum  uint64
+	// CreationTime is the time the table was created, in seconds since the
+	// Unix epoch.
+	CreationTime uint64
 }
 
 func (m *WriterMetadata) updateSeqNum(seqNum uint64) {
@@ -143,6 +146,16 @@ func (w *Writer) Delete(key []byte) error {
 	return w.addPoint(db.MakeInternalKey(key, 0, db.InternalKeyKindDelete), nil)
 }
 
+// SingleDelete single deletes the value for the given key. The sequence
+// number is set to 0. Intended for use to externally construct an sstable
+// before ingestion into a DB.
+func (w *Writer) SingleDelete(key []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.addPoint(db.MakeInternalKey(key, 0, db.InternalKeyKindSingleDelete), nil)
+}
+
 // DeleteRange deletes all of the keys (and values) in the range [start,end)
 // (inclusive on start, exclusive on end). The sequence number is set to
 // 0. Intended for use to externally construct an sstable before ingestion into
@@ -205,7 +218,8 @@ func (w *Writer) addPoint(key db.InternalKey, value []byte) error {
 		w.meta.SmallestPoint = key.Clone()
 	}
 	w.props.NumEntries++
-	if key.Kind() == db.InternalKeyKindDelete {
+	switch key.Kind() {
+	case db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
 		w.props.NumDeletions++
 	}
 	w.props.RawKeySize += uint64(key.Size())
@@ -588,6 +602,8 @@ func NewWriter(f vfs.File, o *db.Options, lo db.LevelOptions) *Writer {
 
 	w.props.ColumnFamilyID = math.MaxInt32
 	w.props.ComparatorName = o.Comparer.Name
+	w.props.CreationTime = uint64(o.Clock.Now().Unix())
+	w.meta.CreationTime = w.props.CreationTime
 	w.props.CompressionName = lo.Compression.String()
 	w.props.MergeOperatorName = o.Merger.Name
 	w.props.PropertyCollectorNames = "[]"
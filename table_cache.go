@@ -12,6 +12,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/petermattis/pebble/cache"
 	"github.com/petermattis/pebble/db"
 	"github.com/petermattis/pebble/sstable"
 	"github.com/petermattis/pebble/vfs"
@@ -31,6 +32,8 @@ type tableCache struct {
 		iters     map[*sstable.Iterator][]byte
 		dummy     tableCacheNode
 		releasing int
+		hits      int64
+		misses    int64
 	}
 }
 
@@ -69,6 +72,14 @@ func (c *tableCache) newIters(
 	}
 	n.result <- x
 
+	if tableFilter := opts.GetTableFilter(); tableFilter != nil &&
+		!tableFilter(x.reader.Properties.UserProperties) {
+		// The table was rejected by the filter. Release our reference to the
+		// table without reading any of its data blocks.
+		c.unrefNode(n)
+		return emptyIter, nil, nil
+	}
+
 	iter := x.reader.NewIter(opts.GetLowerBound(), opts.GetUpperBound())
 	atomic.AddInt32(&c.mu.iterCount, 1)
 	if raceEnabled {
@@ -101,6 +112,43 @@ func (c *tableCache) newIters(
 	return iter, nil, nil
 }
 
+// properties returns the properties of the table with the given metadata.
+func (c *tableCache) properties(meta *fileMetadata) (*sstable.Properties, error) {
+	n := c.findNode(meta)
+	x := <-n.result
+	if x.err != nil {
+		if !c.unrefNode(n) {
+			// Try loading the table again; the error may be transient.
+			go n.load(c)
+		}
+		return nil, x.err
+	}
+	n.result <- x
+
+	props := x.reader.Properties
+	c.unrefNode(n)
+	return &props, nil
+}
+
+// validateBlockChecksums verifies the checksums of every block of the table
+// with the given metadata.
+func (c *tableCache) validateBlockChecksums(meta *fileMetadata) error {
+	n := c.findNode(meta)
+	x := <-n.result
+	if x.err != nil {
+		if !c.unrefNode(n) {
+			// Try loading the table again; the error may be transient.
+			go n.load(c)
+		}
+		return x.err
+	}
+	n.result <- x
+
+	err := x.reader.ValidateBlockChecksums()
+	c.unrefNode(n)
+	return err
+}
+
 // releaseNode releases a node from the tableCache.
 //
 // c.mu must be held when calling this.
@@ -124,6 +172,7 @@ func (c *tableCache) findNode(meta *fileMetadata) *tableCacheNode {
 
 	n := c.mu.nodes[meta.fileNum]
 	if n == nil {
+		c.mu.misses++
 		n = &tableCacheNode{
 			meta:     meta,
 			refCount: 1,
@@ -136,6 +185,7 @@ func (c *tableCache) findNode(meta *fileMetadata) *tableCacheNode {
 		}
 		go n.load(c)
 	} else {
+		c.mu.hits++
 		// Remove n from the doubly-linked list.
 		n.next.prev = n.prev
 		n.prev.next = n.next
@@ -169,6 +219,16 @@ func (c *tableCache) unrefNode(n *tableCacheNode) bool {
 	return res
 }
 
+func (c *tableCache) metrics() cache.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cache.Metrics{
+		Count:  int64(len(c.mu.nodes)),
+		Hits:   c.mu.hits,
+		Misses: c.mu.misses,
+	}
+}
+
 func (c *tableCache) evict(fileNum uint64) {
 	c.mu.Lock()
 	if n := c.mu.nodes[fileNum]; n != nil {
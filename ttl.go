@@ -0,0 +1,128 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/petermattis/pebble/db"
+)
+
+var (
+	errTTLDisabled = errors.New("pebble: TTL mode is not enabled")
+	errTTLMerge    = errors.New("pebble: Merge is not supported in TTL mode")
+	errTTLIngest   = errors.New("pebble: ingestion is not supported in TTL mode")
+	errTTLValue    = errors.New("pebble: invalid TTL value")
+	errTTLBatch    = errors.New("pebble: batch was not created by a DB in TTL mode")
+)
+
+// ttlSuffixLen is the length of the expiration time appended to every value
+// in TTL mode.
+const ttlSuffixLen = 8
+
+// encodeTTLValue returns a copy of value with the expiration time
+// appended. The expiration time is a wall time in nanoseconds since the Unix
+// epoch. An expiration time of zero indicates that the value never expires.
+func encodeTTLValue(value []byte, expiration uint64) []byte {
+	buf := make([]byte, len(value)+ttlSuffixLen)
+	copy(buf, value)
+	binary.LittleEndian.PutUint64(buf[len(value):], expiration)
+	return buf
+}
+
+// decodeTTLValue splits a value encoded by encodeTTLValue into the user value
+// and the expiration time.
+func decodeTTLValue(value []byte) ([]byte, uint64, error) {
+	n := len(value) - ttlSuffixLen
+	if n < 0 {
+		return nil, 0, errTTLValue
+	}
+	return value[:n:n], binary.LittleEndian.Uint64(value[n:]), nil
+}
+
+// ttlExpired returns true if a value with the specified expiration time has
+// expired at time now.
+func ttlExpired(expiration, now uint64) bool {
+	return expiration != 0 && expiration <= now
+}
+
+// ttlNow returns the current time in the form used by expiration times.
+func (d *DB) ttlNow() uint64 {
+	return uint64(d.opts.Clock.Now().UnixNano())
+}
+
+// ttlFilter is the compaction filter used in TTL mode. It removes expired
+// entries and invokes the user's compaction filter, if any, on the remaining
+// entries with the expiration time stripped from their values.
+func ttlFilter(
+	filter db.CompactionFilter, now uint64, level int, key []byte, kind db.InternalKeyKind, value []byte,
+) (db.CompactionFilterDecision, []byte) {
+	v, expiration, err := decodeTTLValue(value)
+	if err != nil {
+		// Leave the value alone so that the error is reported when it is read.
+		return db.CompactionFilterKeep, nil
+	}
+	if ttlExpired(expiration, now) {
+		return db.CompactionFilterRemove, nil
+	}
+	if filter == nil {
+		return db.CompactionFilterKeep, nil
+	}
+	decision, newValue := filter.Filter(level, key, kind, v)
+	if decision == db.CompactionFilterChangeValue {
+		newValue = encodeTTLValue(newValue, expiration)
+	}
+	return decision, newValue
+}
+
+// ttlValue strips the expiration time from a value read by the iterator in TTL
+// mode. It returns false if the value has expired, or if the value is invalid
+// in which case the iterator's error is set.
+func (i *Iterator) ttlValue(value []byte) ([]byte, bool) {
+	v, expiration, err := decodeTTLValue(value)
+	if err != nil {
+		i.err = err
+		return nil, false
+	}
+	return v, !ttlExpired(expiration, i.ttlNow)
+}
+
+// ttlEnabled returns true if the batch belongs to a DB in TTL mode.
+func (b *Batch) ttlEnabled() bool {
+	return b.db != nil && b.db.opts.EnableTTL
+}
+
+// SetWithTTL adds an action to the batch that sets the key to map to the
+// value until the ttl elapses, after which the key is treated as deleted. The
+// ttl is measured from the time SetWithTTL is called. SetWithTTL returns an
+// error if the DB is not in TTL mode (see Options.EnableTTL).
+//
+// It is safe to modify the contents of the arguments after SetWithTTL
+// returns.
+func (b *Batch) SetWithTTL(key, value []byte, ttl time.Duration, _ *db.WriteOptions) error {
+	if !b.ttlEnabled() {
+		return errTTLDisabled
+	}
+	expiration := b.db.opts.Clock.Now().Add(ttl).UnixNano()
+	return b.set(key, encodeTTLValue(value, uint64(expiration)))
+}
+
+// SetWithTTL sets the value for the given key until the ttl elapses, after
+// which the key is treated as deleted. Reads do not return expired entries,
+// and flushes and compactions discard them. SetWithTTL returns an error if
+// the DB is not in TTL mode (see Options.EnableTTL).
+//
+// It is safe to modify the contents of the arguments after SetWithTTL
+// returns.
+func (d *DB) SetWithTTL(key, value []byte, ttl time.Duration, opts *db.WriteOptions) error {
+	b := newBatch(d)
+	defer b.release()
+	if err := b.SetWithTTL(key, value, ttl, opts); err != nil {
+		return err
+	}
+	return d.Apply(b, opts)
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestTTL(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	mem := vfs.NewMem()
+	d, err := Open("", &db.Options{
+		Clock:     clock,
+		EnableTTL: true,
+		VFS:       mem,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(r Reader, key string) string {
+		v, err := r.Get([]byte(key))
+		if err == db.ErrNotFound {
+			return "<not found>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+	scan := func(r Reader) string {
+		iter := r.NewIter(nil)
+		var forward, reverse []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			forward = append(forward, string(iter.Key())+"="+string(iter.Value()))
+		}
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			reverse = append([]string{string(iter.Key()) + "=" + string(iter.Value())}, reverse...)
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if f, r := strings.Join(forward, " "), strings.Join(reverse, " "); f != r {
+			t.Fatalf("forward scan %q != reverse scan %q", f, r)
+		}
+		return strings.Join(forward, " ")
+	}
+
+	if err := d.Set([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set([]byte("b"), []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Compact([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWithTTL([]byte("b"), []byte("2"), time.Minute, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetWithTTL([]byte("c"), []byte("3"), time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Merge([]byte("d"), []byte("4"), nil); err != errTTLMerge {
+		t.Fatalf("expected %v, but found %v", errTTLMerge, err)
+	}
+
+	// A batch which was not created by the DB can only delete, as its values
+	// lack an expiration time. This holds when its operations are copied into
+	// a batch created by the DB.
+	var b Batch
+	if err := b.Set([]byte("d"), []byte("4"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var merge Batch
+	if err := merge.Merge([]byte("d"), []byte("4"), nil); err != nil {
+		t.Fatal(err)
+	}
+	copied := d.NewBatch()
+	if err := copied.Apply(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range []*Batch{&b, &merge, copied} {
+		if err := d.Apply(batch, nil); err != errTTLBatch {
+			t.Fatalf("expected %v, but found %v", errTTLBatch, err)
+		}
+		if err := d.Prepare([]byte("xid"), batch, nil); err != errTTLBatch {
+			t.Fatalf("expected %v, but found %v", errTTLBatch, err)
+		}
+	}
+	var del Batch
+	if err := del.Delete([]byte("d"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(&del, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := scan(d); v != "a=1 b=2 c=3" {
+		t.Fatalf("expected a=1 b=2 c=3, but found %q", v)
+	}
+
+	// Expired entries are hidden from reads, and do not uncover older values.
+	snap := d.NewSnapshot()
+	clock.now = clock.now.Add(time.Minute)
+	if v := get(d, "a") + " " + get(d, "b") + " " + get(snap, "b"); v != "1 <not found> <not found>" {
+		t.Fatalf("expected 1 <not found> <not found>, but found %q", v)
+	}
+	if v := scan(d); v != "a=1 c=3" {
+		t.Fatalf("expected a=1 c=3, but found %q", v)
+	}
+
+	// The snapshot prevents the flush from dropping b, which is visible to
+	// it. Once the snapshot is closed a compaction drops both b and c.
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if err := snap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Compact([]byte("a"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	d.mu.Lock()
+	current := d.mu.versions.currentVersion()
+	d.mu.Unlock()
+	for level := range current.files {
+		for i := range current.files[level] {
+			iter, _, err := d.newIters(&current.files[level][i], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+				keys = append(keys, key.String())
+			}
+			if err := iter.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if v := strings.Join(keys, " "); v != "a#0,1" {
+		t.Fatalf("expected a#0,1, but found %q", v)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A DB created in TTL mode must be opened in TTL mode.
+	if _, err := Open("", &db.Options{VFS: mem}); err == nil {
+		t.Fatalf("expected error opening DB without TTL mode")
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/petermattis/pebble/db"
+)
+
+// ErrConflict is returned by Txn.Commit when a key read or written by the
+// transaction was modified after the transaction started.
+var ErrConflict = errors.New("pebble: transaction conflict")
+
+var errTxnCommitted = errors.New("pebble: transaction already committed")
+
+// txnSpan is a span of user keys read or written by a transaction. A point
+// span contains only its start key. Otherwise the span is [start,end), where a
+// nil end denotes a span without an upper bound.
+type txnSpan struct {
+	start, end []byte
+	point      bool
+}
+
+// contains returns true if key is contained by the span.
+func (s *txnSpan) contains(cmp db.Compare, key []byte) bool {
+	if s.point {
+		return cmp(s.start, key) == 0
+	}
+	return cmp(s.start, key) <= 0 && (s.end == nil || cmp(key, s.end) < 0)
+}
+
+// overlaps returns true if the span overlaps the range [start,end).
+func (s *txnSpan) overlaps(cmp db.Compare, start, end []byte) bool {
+	if s.point {
+		return cmp(start, s.start) <= 0 && cmp(s.start, end) < 0
+	}
+	return (s.end == nil || cmp(start, s.end) < 0) && cmp(s.start, end) < 0
+}
+
+// Txn is an optimistic transaction. Writes to a Txn are buffered in an
+// indexed batch and reads observe those writes merged with the state of the
+// DB as of the creation of the transaction. The keys read and written by the
+// transaction are tracked, and Txn.Commit fails with ErrConflict if any of
+// them were modified after the transaction started.
+//
+// Point reads track the key that was read. Iterators track the entire range
+// specified by their lower and upper bounds, regardless of how much of that
+// range was actually iterated over, so an iterator without bounds will
+// conflict with any concurrent write.
+//
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db        *DB
+	batch     *Batch
+	snapshot  *Snapshot
+	reads     []txnSpan
+	committed bool
+}
+
+var _ Reader = (*Txn)(nil)
+var _ Writer = (*Txn)(nil)
+
+// Get gets the value for the given key. It returns ErrNotFound if neither the
+// transaction nor the DB contain the key.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	t.reads = append(t.reads, txnSpan{
+		start: append([]byte(nil), key...),
+		point: true,
+	})
+	return t.db.getInternal(key, t.batch, t.snapshot)
+}
+
+// NewIter returns an iterator that is unpositioned (Iterator.Valid() will
+// return false). The iterator can be positioned via a call to SeekGE,
+// SeekLT, First or Last. The range between the lower and upper bounds of the
+// iterator options is added to the transaction's read set.
+func (t *Txn) NewIter(o *db.IterOptions) *Iterator {
+	s := txnSpan{start: []byte{}}
+	if lower := o.GetLowerBound(); lower != nil {
+		s.start = append(s.start, lower...)
+	}
+	if upper := o.GetUpperBound(); upper != nil {
+		s.end = append([]byte(nil), upper...)
+	}
+	t.reads = append(t.reads, s)
+	return t.db.newIterInternal(t.batch, t.snapshot, o)
+}
+
+// Apply the operations contained in the batch to the transaction.
+//
+// It is safe to modify the contents of the arguments after Apply returns.
+func (t *Txn) Apply(batch *Batch, opts *db.WriteOptions) error {
+	return t.batch.Apply(batch, opts)
+}
+
+// Delete adds an action to the transaction which deletes the entry for key.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (t *Txn) Delete(key []byte, opts *db.WriteOptions) error {
+	return t.batch.Delete(key, opts)
+}
+
+// SingleDelete adds an action to the transaction which single deletes the
+// entry for key. See Writer.SingleDelete for more details on the semantics
+// of SingleDelete.
+//
+// It is safe to modify the contents of the arguments after SingleDelete
+// returns.
+func (t *Txn) SingleDelete(key []byte, opts *db.WriteOptions) error {
+	return t.batch.SingleDelete(key, opts)
+}
+
+// DeleteRange deletes all of the keys (and values) in the range [start,end)
+// (inclusive on start, exclusive on end).
+//
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns.
+func (t *Txn) DeleteRange(start, end []byte, opts *db.WriteOptions) error {
+	return t.batch.DeleteRange(start, end, opts)
+}
+
+// LogData adds the specified to the transaction. The data will be written to
+// the WAL when the transaction commits, but not added to memtables or
+// sstables.
+//
+// It is safe to modify the contents of the argument after LogData returns.
+func (t *Txn) LogData(data []byte, opts *db.WriteOptions) error {
+	return t.batch.LogData(data, opts)
+}
+
+// Merge adds an action to the transaction that merges the value at key with
+// the new value. The details of the merge are dependent upon the configured
+// merge operator.
+//
+// It is safe to modify the contents of the arguments after Merge returns.
+func (t *Txn) Merge(key, value []byte, opts *db.WriteOptions) error {
+	return t.batch.Merge(key, value, opts)
+}
+
+// Set adds an action to the transaction that sets the key to map to the
+// value.
+//
+// It is safe to modify the contents of the arguments after Set returns.
+func (t *Txn) Set(key, value []byte, opts *db.WriteOptions) error {
+	return t.batch.Set(key, value, opts)
+}
+
+// Commit applies the writes buffered in the transaction to the DB. It returns
+// ErrConflict, and does not apply any of the writes, if a key read or written
+// by the transaction was modified after the transaction was created. A
+// transaction which did not perform any writes always commits successfully
+// as its reads were all performed against a consistent view of the DB.
+//
+// The transaction must still be closed after Commit returns. A transaction
+// can only be committed once.
+func (t *Txn) Commit(opts *db.WriteOptions) error {
+	if t.committed {
+		return errTxnCommitted
+	}
+	if len(t.batch.storage.data) == 0 {
+		t.committed = true
+		return nil
+	}
+
+	// Check for conflicts in the sstables before entering the commit pipeline
+	// as doing so requires I/O. Every write with a sequence number below
+	// seqNum is present in the readState loaded after it.
+	d := t.db
+	seqNum := atomic.LoadUint64(&d.mu.versions.visibleSeqNum)
+	readState := d.loadReadState()
+	err := t.checkConflicts(readState, 0 /* checkedSeqNum */)
+	readState.unref()
+	if err != nil {
+		return err
+	}
+
+	err = d.applyInternal(t.batch, opts, func() error {
+		readState := d.loadReadState()
+		defer readState.unref()
+		return t.checkConflicts(readState, seqNum)
+	})
+	if err == nil {
+		t.committed = true
+	}
+	return err
+}
+
+// Close closes the transaction, releasing its resources. Any writes that were
+// not committed are discarded. Close must be called.
+func (t *Txn) Close() error {
+	return firstError(t.snapshot.Close(), t.batch.Close())
+}
+
+// spans returns the spans of keys read or written by the transaction.
+func (t *Txn) spans() []txnSpan {
+	spans := t.reads
+	for r := t.batch.iter(); ; {
+		kind, ukey, value, ok := r.next()
+		if !ok {
+			break
+		}
+		switch kind {
+		case db.InternalKeyKindSet, db.InternalKeyKindMerge,
+			db.InternalKeyKindDelete, db.InternalKeyKindSingleDelete:
+			spans = append(spans, txnSpan{start: ukey, point: true})
+		case db.InternalKeyKindRangeDelete:
+			spans = append(spans, txnSpan{start: ukey, end: value})
+		}
+	}
+	return spans
+}
+
+// checkConflicts returns ErrConflict if any key read or written by the
+// transaction was modified after the transaction's snapshot. Commit invokes
+// it twice: first without holding any locks, and then from within the commit
+// pipeline at a point where every prior write is visible and no other writes
+// can be committed.
+//
+// The sstables whose entries all have sequence numbers below checkedSeqNum
+// were examined by the first invocation and are skipped, so that the second
+// invocation, which blocks every other write, does not perform any I/O. The
+// sstables containing newer entries were flushed or ingested concurrently
+// with the commit; rather than reading them, the transaction conservatively
+// conflicts with any of them which overlap its keys.
+//
+// The check examines every version of the keys in the memtables and the
+// sstables rather than only the latest, because a newer write may have been
+// hidden by a range tombstone. Note that the transaction's snapshot prevents
+// compactions from zeroing the sequence numbers of entries that were written
+// after the transaction started.
+func (t *Txn) checkConflicts(readState *readState, checkedSeqNum uint64) error {
+	d := t.db
+	for _, s := range t.spans() {
+		for _, mem := range readState.memtables {
+			conflict, err := t.iterConflicts(&s, mem.newIter(nil), mem.newRangeDelIter(nil))
+			if err != nil || conflict {
+				return firstError(err, ErrConflict)
+			}
+		}
+
+		current := readState.current
+		for level := range current.files {
+			var files []fileMetadata
+			switch {
+			case s.point:
+				files = current.overlaps(level, d.cmp, s.start, s.start)
+			case s.end != nil:
+				files = current.overlaps(level, d.cmp, s.start, s.end)
+			default:
+				files = current.files[level]
+			}
+			for i := range files {
+				f := &files[i]
+				if f.largestSeqNum < t.snapshot.seqNum || f.largestSeqNum < checkedSeqNum {
+					// The table does not contain any writes performed after the
+					// transaction started, or it was already checked.
+					continue
+				}
+				if d.cmp(f.largest.UserKey, s.start) < 0 {
+					continue
+				}
+				if checkedSeqNum != 0 {
+					return ErrConflict
+				}
+				iter, rangeDelIter, err := d.newIters(f, nil /* iter options */)
+				if err != nil {
+					return err
+				}
+				conflict, err := t.iterConflicts(&s, iter, rangeDelIter)
+				if err != nil || conflict {
+					return firstError(err, ErrConflict)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// iterConflicts returns true if either of the iterators contains an entry
+// within the span which was written after the transaction's snapshot. The
+// iterators are closed before returning.
+func (t *Txn) iterConflicts(
+	s *txnSpan, iter internalIterator, rangeDelIter internalIterator,
+) (conflict bool, err error) {
+	cmp := t.db.cmp
+	seqNum := t.snapshot.seqNum
+
+	for key, _ := iter.SeekGE(s.start); key != nil; key, _ = iter.Next() {
+		if !s.contains(cmp, key.UserKey) {
+			break
+		}
+		if key.SeqNum() >= seqNum {
+			conflict = true
+			break
+		}
+	}
+	err = iter.Close()
+
+	if rangeDelIter != nil {
+		// Range tombstones are sorted by start key, so there is no need to look
+		// at tombstones which start after the span.
+		for key, end := rangeDelIter.First(); key != nil && !conflict; key, end = rangeDelIter.Next() {
+			if s.end != nil && cmp(key.UserKey, s.end) >= 0 {
+				break
+			}
+			if s.point && cmp(key.UserKey, s.start) > 0 {
+				break
+			}
+			if key.SeqNum() >= seqNum && s.overlaps(cmp, key.UserKey, end) {
+				conflict = true
+			}
+		}
+		err = firstError(err, rangeDelIter.Close())
+	}
+	return conflict, err
+}
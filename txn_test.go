@@ -0,0 +1,312 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/petermattis/pebble/db"
+	"github.com/petermattis/pebble/vfs"
+)
+
+func TestTxnReadYourWrites(t *testing.T) {
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Set([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := d.NewTxn()
+	defer txn.Close()
+
+	// Writes performed after the transaction started are not visible to it.
+	if err := d.Set([]byte("a"), []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := txn.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "1" {
+		t.Fatalf("expected a=1, but found %q", v)
+	}
+
+	// Writes performed by the transaction are visible to it, but not to the
+	// DB until the transaction commits.
+	if err := txn.Set([]byte("b"), []byte("3"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("c"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := txn.Get([]byte("b")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "3" {
+		t.Fatalf("expected b=3, but found %q", v)
+	}
+	if _, err := d.Get([]byte("b")); err != db.ErrNotFound {
+		t.Fatalf("expected not found, but found %v", err)
+	}
+
+	iter := txn.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys, " "); got != "a=1 b=3" {
+		t.Fatalf("expected a=1 b=3, but found %q", got)
+	}
+}
+
+func TestTxnConflicts(t *testing.T) {
+	testCases := []struct {
+		name string
+		// txn is invoked on the transaction before the concurrent write.
+		txn func(txn *Txn) error
+		// write is invoked on the DB after the transaction has started.
+		write    func(d *DB) error
+		conflict bool
+	}{
+		{
+			name: "read-write",
+			txn: func(txn *Txn) error {
+				_, err := txn.Get([]byte("b"))
+				return err
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("b"), []byte("2"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "read-unrelated-write",
+			txn: func(txn *Txn) error {
+				_, err := txn.Get([]byte("b"))
+				return err
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("c"), []byte("2"), nil)
+			},
+		},
+		{
+			name: "read-missing-key",
+			txn: func(txn *Txn) error {
+				if _, err := txn.Get([]byte("x")); err != db.ErrNotFound {
+					return err
+				}
+				return nil
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("x"), []byte("2"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "write-write",
+			txn: func(txn *Txn) error {
+				return txn.Merge([]byte("b"), []byte("2"), nil)
+			},
+			write: func(d *DB) error {
+				return d.Delete([]byte("b"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "read-flushed-write",
+			txn: func(txn *Txn) error {
+				_, err := txn.Get([]byte("b"))
+				return err
+			},
+			write: func(d *DB) error {
+				if err := d.Set([]byte("b"), []byte("2"), nil); err != nil {
+					return err
+				}
+				return d.Flush()
+			},
+			conflict: true,
+		},
+		{
+			name: "read-range-deleted",
+			txn: func(txn *Txn) error {
+				_, err := txn.Get([]byte("b"))
+				return err
+			},
+			write: func(d *DB) error {
+				return d.DeleteRange([]byte("a"), []byte("c"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "iter-range-write",
+			txn: func(txn *Txn) error {
+				iter := txn.NewIter(&db.IterOptions{
+					LowerBound: []byte("b"),
+					UpperBound: []byte("d"),
+				})
+				iter.First()
+				return iter.Close()
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("c"), []byte("2"), nil)
+			},
+			conflict: true,
+		},
+		{
+			name: "iter-range-unrelated-write",
+			txn: func(txn *Txn) error {
+				iter := txn.NewIter(&db.IterOptions{
+					LowerBound: []byte("b"),
+					UpperBound: []byte("d"),
+				})
+				iter.First()
+				return iter.Close()
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("d"), []byte("2"), nil)
+			},
+		},
+		{
+			name: "iter-range-overlapping-range-delete",
+			txn: func(txn *Txn) error {
+				iter := txn.NewIter(&db.IterOptions{
+					LowerBound: []byte("c"),
+					UpperBound: []byte("e"),
+				})
+				iter.First()
+				return iter.Close()
+			},
+			write: func(d *DB) error {
+				if err := d.DeleteRange([]byte("a"), []byte("d"), nil); err != nil {
+					return err
+				}
+				return d.Flush()
+			},
+			conflict: true,
+		},
+		{
+			name: "range-delete-write",
+			txn: func(txn *Txn) error {
+				return txn.DeleteRange([]byte("a"), []byte("c"), nil)
+			},
+			write: func(d *DB) error {
+				return d.Set([]byte("a"), []byte("2"), nil)
+			},
+			conflict: true,
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := Open("", &db.Options{
+				VFS: vfs.NewMem(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			// Place the initial data in an sstable so that the conflict checks
+			// need to look at both the memtable and the sstables.
+			if err := d.Set([]byte("b"), []byte("1"), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			txn := d.NewTxn()
+			defer txn.Close()
+			if err := c.txn(txn); err != nil {
+				t.Fatal(err)
+			}
+			if err := txn.Set([]byte("z"), []byte("txn"), nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.write(d); err != nil {
+				t.Fatal(err)
+			}
+
+			err = txn.Commit(nil)
+			if c.conflict {
+				if err != ErrConflict {
+					t.Fatalf("expected conflict, but found %v", err)
+				}
+				if _, err := d.Get([]byte("z")); err != db.ErrNotFound {
+					t.Fatalf("expected z to not be written, but found %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, err := d.Get([]byte("z")); err != nil {
+				t.Fatal(err)
+			} else if string(v) != "txn" {
+				t.Fatalf("expected z=txn, but found %q", v)
+			}
+			if err := txn.Commit(nil); err != errTxnCommitted {
+				t.Fatalf("expected %v, but found %v", errTxnCommitted, err)
+			}
+		})
+	}
+}
+
+func TestTxnCheckConflictsCheckedTables(t *testing.T) {
+	d, err := Open("", &db.Options{
+		VFS: vfs.NewMem(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Set([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	txn := d.NewTxn()
+	defer txn.Close()
+	if _, err := txn.Get([]byte("b")); err != db.ErrNotFound {
+		t.Fatal(err)
+	}
+	if err := txn.Set([]byte("z"), []byte("txn"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set([]byte("b"), []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+	f := &readState.current.files[0][0]
+
+	// The conflicting table is read when the tables have not been checked.
+	if err := txn.checkConflicts(readState, 0 /* checkedSeqNum */); err != ErrConflict {
+		t.Fatalf("expected conflict, but found %v", err)
+	}
+	// The tables which were already checked are skipped.
+	if err := txn.checkConflicts(readState, f.largestSeqNum+1); err != nil {
+		t.Fatal(err)
+	}
+	// A table written after the tables were checked conflicts without being
+	// read.
+	d.tableCache.evict(f.fileNum)
+	if err := d.opts.VFS.Remove(dbFilename("", fileTypeTable, f.fileNum)); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.checkConflicts(readState, f.largestSeqNum); err != ErrConflict {
+		t.Fatalf("expected conflict, but found %v", err)
+	}
+}
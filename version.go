@@ -33,6 +33,10 @@ type fileMetadata struct {
 	// smallest and largest sequence numbers in the table.
 	smallestSeqNum uint64
 	largestSeqNum  uint64
+	// creationTime is the time the table was created, in seconds since the
+	// Unix epoch, or 0 if it is unknown. It is not persisted in the manifest;
+	// see DB.loadTableCreationTimes.
+	creationTime uint64
 	// true if client asked us nicely to compact this file.
 	markedForCompaction bool
 }
@@ -50,10 +50,14 @@ type versionSet struct {
 
 	writing    bool
 	writerCond sync.Cond
+
+	// metrics holds the cumulative flush, compaction and ingestion metrics. The
+	// remaining fields of Metrics are computed on demand by DB.Metrics.
+	metrics Metrics
 }
 
-// load loads the version set from the manifest file.
-func (vs *versionSet) load(dirname string, opts *db.Options, mu *sync.Mutex) error {
+// init initializes an empty version set.
+func (vs *versionSet) init(dirname string, opts *db.Options, mu *sync.Mutex) {
 	vs.dirname = dirname
 	vs.mu = mu
 	vs.versions.mu = mu
@@ -65,33 +69,20 @@ func (vs *versionSet) load(dirname string, opts *db.Options, mu *sync.Mutex) err
 	vs.versions.init()
 	// For historical reasons, the next file number is initialized to 2.
 	vs.nextFileNumber = 2
+}
+
+// load loads the version set from the manifest file.
+func (vs *versionSet) load(dirname string, opts *db.Options, mu *sync.Mutex) error {
+	vs.init(dirname, opts, mu)
 
 	// Read the CURRENT file to find the current manifest file.
-	current, err := vs.fs.Open(dbFilename(dirname, fileTypeCurrent, 0))
-	if err != nil {
-		return fmt.Errorf("pebble: could not open CURRENT file for DB %q: %v", dirname, err)
-	}
-	defer current.Close()
-	stat, err := current.Stat()
-	if err != nil {
-		return err
-	}
-	n := stat.Size()
-	if n == 0 {
-		return fmt.Errorf("pebble: CURRENT file for DB %q is empty", dirname)
-	}
-	if n > 4096 {
-		return fmt.Errorf("pebble: CURRENT file for DB %q is too large", dirname)
-	}
-	b := make([]byte, n)
-	_, err = current.ReadAt(b, 0)
+	b, err := readCurrentFile(vs.fs, dirname)
 	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return fmt.Errorf("pebble: could not open CURRENT file for DB %q: %v", dirname, err)
+		}
 		return err
 	}
-	if b[n-1] != '\n' {
-		return fmt.Errorf("pebble: CURRENT file for DB %q is malformed", dirname)
-	}
-	b = b[:n-1]
 
 	// Read the versionEdits in the manifest file.
 	var bve bulkVersionEdit
@@ -153,6 +144,37 @@ func (vs *versionSet) load(dirname string, opts *db.Options, mu *sync.Mutex) err
 	return nil
 }
 
+// readCurrentFile returns the name of the current manifest file, as recorded
+// in the CURRENT file. The error opening the CURRENT file is returned as is,
+// so that callers can check it with os.IsNotExist.
+func readCurrentFile(fs vfs.FS, dirname string) ([]byte, error) {
+	current, err := fs.Open(dbFilename(dirname, fileTypeCurrent, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer current.Close()
+	stat, err := current.Stat()
+	if err != nil {
+		return nil, err
+	}
+	n := stat.Size()
+	if n == 0 {
+		return nil, fmt.Errorf("pebble: CURRENT file for DB %q is empty", dirname)
+	}
+	if n > 4096 {
+		return nil, fmt.Errorf("pebble: CURRENT file for DB %q is too large", dirname)
+	}
+	b := make([]byte, n)
+	_, err = current.ReadAt(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if b[n-1] != '\n' {
+		return nil, fmt.Errorf("pebble: CURRENT file for DB %q is malformed", dirname)
+	}
+	return b[:n-1], nil
+}
+
 // logAndApply logs the version edit to the manifest, applies the version edit
 // to the current version, and installs the new version. DB.mu must be held
 // when calling this method and will be released temporarily while performing
@@ -275,6 +297,8 @@ func (vs *versionSet) createManifest(dirname string, fileNum uint64) (err error)
 
 	snapshot := versionEdit{
 		comparatorName: vs.cmpName,
+		logNumber:      vs.logNumber,
+		prevLogNumber:  vs.prevLogNumber,
 	}
 	for level, fileMetadata := range vs.currentVersion().files {
 		for _, meta := range fileMetadata {